@@ -2,23 +2,42 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
+	"github.com/aksgithub/kube_remediator/pkg/history"
 	"github.com/aksgithub/kube_remediator/pkg/http"
 	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/aksgithub/kube_remediator/pkg/leader"
+	"github.com/aksgithub/kube_remediator/pkg/notify"
 	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/aksgithub/kube_remediator/pkg/reload"
 	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"github.com/aksgithub/kube_remediator/pkg/window"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
-	"reflect"
-	"strings"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+	_ "time/tzdata" // the image has no timezone database, windows need it
 )
 
-// catch interrupts to gracefully exit since otherwise goroutines get killed without running defer
+// catch interrupts to gracefully exit since otherwise goroutines get killed without running defer,
+// also returns when ctx is done for another reason
 // TODO: is there no better way of doing this ?
-func signalHandler(cancelFn func(), wg *sync.WaitGroup, logger *zap.Logger) {
+func signalHandler(ctx context.Context, cancelFn func(), wg *sync.WaitGroup, logger *zap.Logger) {
 	defer cancelFn()
 	defer wg.Done()
 	c := make(chan os.Signal, 1)
@@ -29,69 +48,339 @@ func signalHandler(cancelFn func(), wg *sync.WaitGroup, logger *zap.Logger) {
 		syscall.SIGABRT,
 		syscall.SIGILL,
 		syscall.SIGFPE)
-	signal := <-c
-	logger.Sugar().Warnf("Signal %v Received, Shutting Down", signal) // TODO: prefer structured logging
+	defer signal.Stop(c)
+	select {
+	case received := <-c:
+		logger.Sugar().Warnf("Signal %v Received, Shutting Down", received) // TODO: prefer structured logging
+	case <-ctx.Done():
+	}
 }
 
-func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
+// what every enabled remediator should run with according to the policy
+func remediatorSpecs(
+	logger *zap.Logger,
+	remediatorPolicy policy.RemediatorPolicy,
+	dryRun bool,
+	recorder record.EventRecorder,
+	store *history.Store,
+	notifier *notify.Notifier,
+	approvals *approval.Store,
+	namespaces *policy.NamespacePolicies,
+	self types.NamespacedName,
+	lastRuns *remediator.LastRuns,
+) map[string]remediator.Spec {
+	specs := map[string]remediator.Spec{}
+	for _, registration := range remediator.Registrations() {
+		name := registration.Name
+		if remediatorPolicy.IsDisabled(name) {
+			logger.Info("Skipping remediator as it is disabled.", zap.String("remediator", name))
+			continue
+		}
+
+		spec := remediator.Spec{
+			Options: remediator.Options{
+				Name:                      name,
+				DryRun:                    dryRun || remediatorPolicy.IsDryRun(name),
+				Action:                    remediatorPolicy.ActionFor(name),
+				DeleteWhenEvictionBlocked: remediatorPolicy.DeletesWhenEvictionBlocked(name),
+				Recorder:                  recorder,
+				History:                   store,
+				Notifier:                  notifier,
+				Approval:                  remediatorPolicy.ApprovalFor(name),
+				Approvals:                 approvals,
+				Namespaces:                namespaces,
+				Scopes:                    remediatorPolicy.ScopesFor(name),
+				Windows:                   remediatorPolicy.WindowsFor(name),
+				CircuitBreaker:            remediatorPolicy.CircuitBreakerFor(name),
+				RestartOwner:              remediatorPolicy.RestartOwnerFor(name),
+				Self:                      self,
+				LastRuns:                  lastRuns,
+			},
+			Budget: remediatorPolicy.BudgetFor(name),
+		}
+		if registration.ConfigFile != nil {
+			content, _ := os.ReadFile(registration.ConfigFile()) // unreadable config fails in Setup
+			spec.Config = string(content)
+		}
+		specs[name] = spec
+	}
+	return specs
+}
+
+func remediatorWindows(specs map[string]remediator.Spec) map[string][]policy.Windows {
+	windows := map[string][]policy.Windows{}
+	for name, spec := range specs {
+		windows[name] = spec.Options.Windows
+	}
+	return windows
+}
 
-	// build a logger:
-	// - without timestamps because docker already logs with timestamps
-	// - use "message" instead of "msg" for consistency with other services / datadog parsing
-	// - remove caller since it points to shared methods most of the time anyway
+// directories of all config files, so changes to them can be applied without a restart
+func configDirs() []string {
+	dirs := []string{policy.ConfigPath}
+	for _, registration := range remediator.Registrations() {
+		if registration.ConfigFile != nil {
+			dirs = append(dirs, filepath.Dir(registration.ConfigFile()))
+		}
+	}
+	return dirs
+}
+
+// reads the policy and fails on anything it can not use, like unknown fields or remediators, errors name the file
+func loadPolicy() (policy.RemediatorPolicy, error) {
+	remediatorPolicy, err := policy.ReadRemediatorPolicy()
+	if err != nil {
+		return remediatorPolicy, err
+	}
+	known := func(name string) bool {
+		_, found := remediator.Lookup(name)
+		return found
+	}
+	if err := remediatorPolicy.ValidateRemediators(known); err != nil {
+		return remediatorPolicy, fmt.Errorf("%s: %w", policy.ConfigFile(), err)
+	}
+	return remediatorPolicy, nil
+}
+
+// builds a logger:
+// - without timestamps because docker already logs with timestamps
+// - use "message" instead of "msg" for consistency with other services / datadog parsing
+// - remove caller since it points to shared methods most of the time anyway
+func newLogger() (*zap.Logger, error) {
 	loggerConfig := zap.NewProductionConfig()
 	loggerConfig.EncoderConfig.TimeKey = ""
 	loggerConfig.EncoderConfig.MessageKey = "message"
 	loggerConfig.DisableCaller = true
+	return loggerConfig.Build()
+}
+
+// RemediationPolicies of namespaces when the policy turns them on, nil otherwise, watched until ctx is done
+func watchNamespacePolicies(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	logger *zap.Logger,
+	k8sClient *k8s.Client,
+	remediatorPolicy policy.RemediatorPolicy,
+) (*policy.NamespacePolicies, cache.InformerSynced, error) {
+	if !remediatorPolicy.NamespacePolicies {
+		return nil, func() bool { return true }, nil
+	}
+	controller, err := policy.NewNamespacePolicyController(
+		logger.With(zap.String("component", "namespace_policies")),
+		k8sClient.NewDynamicInformer(policy.RemediationPolicyResource),
+		func(object *unstructured.Unstructured) error {
+			return k8sClient.UpdateStatus(policy.RemediationPolicyResource, object)
+		},
+		func(name string) bool {
+			_, found := remediator.Lookup(name)
+			return found
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	wg.Add(1)
+	go controller.Run(ctx, wg)
+	return controller.Policies, controller.HasSynced, nil
+}
+
+// where the leader serves, its identity is the name of its pod, which runs next to ours
+func leaderURL(k8sClient *k8s.Client, elector *leader.Elector) (*url.URL, error) {
+	identity := elector.Leader()
+	if identity == "" || identity == leader.Identity() {
+		return nil, errors.New("no leader elected yet")
+	}
+	pod, err := k8sClient.GetPod(leader.Namespace(""), identity)
+	if err != nil {
+		return nil, err
+	}
+	if pod.Status.PodIP == "" {
+		return nil, fmt.Errorf("leader %s has no IP yet", identity)
+	}
+	return &url.URL{Scheme: "http", Host: net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(http.Port))}, nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		os.Exit(plan(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "run-once" {
+		os.Exit(runOnce(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfig(os.Args[2:]))
+	}
+	os.Exit(serve())
+}
+
+// runs the remediators until interrupted, returns the exit code once everything stopped
+func serve() int {
+	dryRun := flag.Bool("dry-run", false, "Log and count what every remediator would do without doing it (overrides the policy)")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
 
 	// general logger
-	logger, err := loggerConfig.Build()
+	logger, err := newLogger()
 	runtime.Must(err)
 
 	wg.Add(1)
-	go signalHandler(cancel, &wg, logger)
+	go signalHandler(ctx, cancel, &wg, logger)
 
-	remediators := []remediator.BaseIntf{
-		&remediator.OldPodDeleter{},
-		&remediator.CrashLoopBackOffRescheduler{},
-		&remediator.FailedPodRescheduler{},
-		&remediator.CompletedPodDeleter{},
+	remediatorPolicy, err := loadPolicy()
+	if err != nil {
+		logger.Panic("Invalid remediator policy", zap.Error(err))
 	}
+	startPolicy := remediatorPolicy // for sections that are only read on start
 
-	remediatorPolicy := policy.LoadRemediatorPolicy()
+	// one client and informer cache shared by all remediators
+	k8sClient, err := k8s.NewClient(logger)
+	runtime.Must(err)
 
-	for _, r := range remediators {
-		// remediator.OldPodDeleter -> OldPodDeleter
-		name := strings.Split(reflect.TypeOf(r).String(), ".")[1]
+	var elector *leader.Elector
+	if config := remediatorPolicy.LeaderElection; config.Enabled {
+		lock := k8sClient.NewLeaseLock(config.LeaseName, leader.Namespace(config.LeaseNamespace), leader.Identity())
+		elector = leader.NewElector(logger.With(zap.String("component", "leader")), config, lock)
+	}
 
-		// make each logged line show what remediator it came from
-		loggerConfig.InitialFields = map[string]interface{}{"remediator": name}
+	// every replica only has what it did itself, so followers pass requests on to the leader
+	var store *history.Store
+	server := http.NewServer(logger)
+	if remediatorPolicy.History.Size > 0 {
+		store, err = history.NewStore(remediatorPolicy.History.Size, remediatorPolicy.History.Path)
+		if err != nil {
+			logger.Panic("Error loading remediation history", zap.Error(err))
+		}
+		defer store.Close()
+		if elector != nil {
+			store.ForwardToLeader(elector.IsLeading, func() (*url.URL, error) { return leaderURL(k8sClient, elector) })
+		}
+		server.RegisterHandler(store.RegisterHandler)
+	}
 
-		logger, err := loggerConfig.Build()
-		runtime.Must(err)
+	// flushes batches of remediators following pod updates, and what is left on shutdown
+	notifier, err := notify.NewNotifier(logger.With(zap.String("component", "notifications")), remediatorPolicy.Notifications)
+	if err != nil {
+		logger.Panic("Invalid notifications", zap.Error(err))
+	}
+	if notifier != nil {
+		wg.Add(1)
+		go notifier.Run(ctx, &wg)
+	}
 
-		if remediatorPolicy.IsDisabled(name) {
-			logger.Info("Skipping remediator as it is disabled.")
-			continue
-		}
+	// proposals are shared by all replicas, so any of them can decide and they survive a new leader
+	approvals := approval.NewConfigMapStore(k8sClient, leader.Namespace(""), approval.ConfigMapName)
+	approvals.AuthorizeWith(k8sClient)
+	server.RegisterHandler(approvals.RegisterHandler)
+	wg.Add(1)
+	go approvals.Run(ctx, &wg)
 
-		k8sClient, err := k8s.NewClient(logger)
-		runtime.Must(err)
+	// only reports remediators while leading, since only then they are running
+	windows := window.NewMonitor(logger.With(zap.String("component", "windows")), time.Now)
+	server.RegisterHandler(windows.RegisterHandler)
+	wg.Add(1)
+	go windows.Run(ctx, &wg)
 
-		err = r.Setup(logger, k8sClient)
+	// serve /healthz, /readyz and /metrics on every replica, not just the leader
+	wg.Add(1)
+	go server.Serve(ctx, &wg)
+
+	wg.Add(1)
+	reloads := reload.Watch(ctx, &wg, logger.With(zap.String("component", "reload")), configDirs()...)
+
+	recorder := k8sClient.NewEventRecorder("kube-remediator")
+
+	// remediators never act on our own pods, the hostname of a pod is its name
+	self := types.NamespacedName{Namespace: leader.Namespace(""), Name: leader.Identity()}
+
+	// shared by all replicas, so a new leader does not repeat runs the previous one just did
+	lastRuns := remediator.NewLastRuns(k8sClient, self.Namespace, remediator.LastRunsConfigMap)
+
+	// RemediationPolicies are watched by every replica, so a new leader can start right away
+	namespaces, namespacesSynced, err := watchNamespacePolicies(ctx, &wg, logger, k8sClient, remediatorPolicy)
+	runtime.Must(err)
+
+	// kept across leadership terms so re-election does not refill the global budget
+	manager := remediator.NewManager(
+		logger,
+		remediator.Registrations(),
+		func(name string) *zap.Logger {
+			// make each logged line show what remediator it came from
+			return logger.With(zap.String("remediator", name))
+		},
+		k8sClient,
+	)
+
+	// informers keep running across leadership terms and restarts of remediators
+	informersStop := ctx.Done()
+
+	// remediators that can not be set up on start would fail the same way on every leader, so stop instead
+	var setupFailed atomic.Bool
+	run := func(ctx context.Context) {
+		defer manager.Stop()
+		defer windows.Update(nil) // stopped remediators have no windows
+
+		// otherwise remediators could act in namespaces whose policy turns them off
+		if !cache.WaitForCacheSync(ctx.Done(), namespacesSynced) {
+			return
+		}
+
+		specs := remediatorSpecs(logger, remediatorPolicy, *dryRun, recorder, store, notifier, approvals, namespaces, self, lastRuns)
+		err := manager.Apply(ctx, remediatorPolicy.Budget, specs)
 		if err != nil {
-			logger.Panic("Error initializing", zap.Error(err))
+			logger.Error("Error initializing", zap.Error(err))
+			setupFailed.Store(true)
+			cancel()
+			return
 		}
+		windows.Update(remediatorWindows(specs))
+		// only starts informers requested by Setup
+		k8sClient.SharedInformerFactory().Start(informersStop)
+		k8sClient.MetadataInformerFactory().Start(informersStop)
 
-		wg.Add(1)
-		go r.Run(ctx, &wg)
+		for {
+			select {
+			case <-reloads:
+				reloadedPolicy, err := loadPolicy()
+				if err != nil {
+					logger.Error("Error reloading remediator policy, keeping the previous one", zap.Error(err))
+				} else {
+					if changed := reloadedPolicy.NeedsRestart(startPolicy); len(changed) > 0 {
+						logger.Warn("Policy changes are only applied on restart", zap.Strings("sections", changed))
+					}
+					remediatorPolicy = reloadedPolicy
+				}
+
+				// remediator config files might have changed even when the policy did not
+				specs := remediatorSpecs(logger, remediatorPolicy, *dryRun, recorder, store, notifier, approvals, namespaces, self, lastRuns)
+				err = manager.Apply(ctx, remediatorPolicy.Budget, specs)
+				if err != nil {
+					logger.Error("Error reloading remediators", zap.Error(err))
+				}
+				windows.Update(remediatorWindows(specs))
+				k8sClient.SharedInformerFactory().Start(informersStop)
+				k8sClient.MetadataInformerFactory().Start(informersStop)
+			case <-ctx.Done():
+				return
+			}
+		}
 	}
 
 	wg.Add(1)
-	go http.NewServer(logger).Serve(ctx, &wg)
+	if elector != nil {
+		go elector.Run(ctx, &wg, run)
+	} else {
+		go func() {
+			defer wg.Done()
+			run(ctx)
+		}()
+	}
 
 	<-ctx.Done()
 	wg.Wait()
+	if setupFailed.Load() {
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/history"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"go.uber.org/zap"
+	"io"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"sigs.k8s.io/yaml"
+	"text/tabwriter"
+	"time"
+)
+
+// prints what every enabled remediator would do right now without changing anything,
+// uses KUBECONFIG (or ~/.kube/config) outside of a cluster, returns the exit code
+func plan(args []string) int {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	output := flags.String("o", "table", "Output format: table, json or yaml")
+	timeout := flags.Duration("timeout", 2*time.Minute, "How long loading pods and their owners may take")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: remediator plan [-o table|json|yaml] [-timeout 2m]")
+		fmt.Fprintln(flags.Output(), "Prints what every enabled remediator would do right now, regardless of windows, budgets and circuit breakers.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *output != "table" && *output != "json" && *output != "yaml" {
+		fmt.Fprintf(os.Stderr, "unknown output %q, use table, json or yaml\n", *output)
+		return 2
+	}
+
+	// only warnings, so dry-run lines do not drown the plan
+	loggerConfig := zap.NewProductionConfig()
+	loggerConfig.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	loggerConfig.DisableCaller = true
+	logger, err := loggerConfig.Build()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	remediatorPolicy, err := loadPolicy()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid remediator policy:", err)
+		return 1
+	}
+	client, err := k8s.NewClient(logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "connecting to the cluster:", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	specs := remediatorSpecs(logger, remediatorPolicy, true, nil, nil, nil, nil, nil, types.NamespacedName{}, nil)
+	entries, planErr := remediator.Plan(ctx, logger, client, remediator.Registrations(), specs)
+	if entries != nil {
+		if err := printPlan(os.Stdout, *output, entries); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if planErr != nil {
+		fmt.Fprintln(os.Stderr, "plan is incomplete:", planErr)
+		return 1
+	}
+	return 0
+}
+
+func printPlan(w io.Writer, output string, entries []history.Entry) error {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case "yaml":
+		content, err := yaml.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "REMEDIATOR\tACTION\tKIND\tNAMESPACE\tNAME\tOWNER\tREASON")
+	for _, entry := range entries {
+		owner := "-"
+		if entry.Owner != nil {
+			owner = entry.Owner.Kind + "/" + entry.Owner.Name
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Remediator, entry.Action, entry.Kind, entry.Namespace, entry.Name, owner, entry.Reason)
+	}
+	return table.Flush()
+}
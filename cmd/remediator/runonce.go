@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/aksgithub/kube_remediator/pkg/leader"
+	"github.com/aksgithub/kube_remediator/pkg/notify"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// printed on stdout when run-once is done
+type runOnceSummary struct {
+	Succeeded   bool                 `json:"succeeded"`
+	Remediators []remediator.Outcome `json:"remediators"`
+}
+
+// runs one pass of the selected remediators like on start and exits, for running them from a CronJob.
+// Logs go to stderr and a json summary to stdout, returns the exit code
+func runOnce(args []string) int {
+	flags := flag.NewFlagSet("run-once", flag.ExitOnError)
+	selected := flags.String("remediators", "", "Comma separated remediators to run, default all that are not disabled by the policy")
+	dryRun := flags.Bool("dry-run", false, "Log and count what every remediator would do without doing it (overrides the policy)")
+	timeout := flags.Duration("timeout", 2*time.Minute, "How long loading pods and their owners, and recording events may take")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: remediator run-once [-remediators CompletedPodDeleter,OldPodDeleter] [-dry-run] [-timeout 2m]")
+		fmt.Fprintln(flags.Output(), "Runs one pass of the remediators, prints a json summary and exits with 1 when anything failed.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	remediatorPolicy, err := loadPolicy()
+	if err != nil {
+		logger.Error("Invalid remediator policy", zap.Error(err))
+		return 1
+	}
+
+	var registrations []remediator.Registration
+	if *selected == "" {
+		registrations = remediator.Registrations()
+	} else {
+		for _, name := range strings.Split(*selected, ",") {
+			registration, found := remediator.Lookup(strings.TrimSpace(name))
+			if !found {
+				fmt.Fprintf(os.Stderr, "unknown remediator %q\n", name)
+				return 2
+			}
+			if remediatorPolicy.IsDisabled(registration.Name) {
+				// it would not run and be missing from the summary, so asking for it is a usage error
+				fmt.Fprintf(os.Stderr, "remediator %q is disabled by the policy\n", registration.Name)
+				return 2
+			}
+			registrations = append(registrations, registration)
+		}
+	}
+
+	k8sClient, err := k8s.NewClient(logger)
+	if err != nil {
+		logger.Error("Error connecting to the cluster", zap.Error(err))
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait() // RemediationPolicy statuses are written before exiting
+	defer cancel()
+
+	namespaces, namespacesSynced, err := watchNamespacePolicies(ctx, &wg, logger, k8sClient, remediatorPolicy)
+	if err != nil {
+		logger.Error("Error watching RemediationPolicies", zap.Error(err))
+		return 1
+	}
+	syncCtx, syncCancel := context.WithTimeout(ctx, *timeout)
+	defer syncCancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), namespacesSynced) {
+		logger.Error("Timed out loading RemediationPolicies")
+		return 1
+	}
+
+	notifier, err := notify.NewNotifier(logger.With(zap.String("component", "notifications")), remediatorPolicy.Notifications)
+	if err != nil {
+		logger.Error("Invalid notifications", zap.Error(err))
+		return 1
+	}
+	recorder, flushEvents := k8sClient.NewFlushedEventRecorder("kube-remediator")
+	defer func() {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), *timeout)
+		defer flushCancel()
+		flushEvents(flushCtx)
+	}()
+
+	// shared with the deployment, so proposals can be approved there and the next pass acts on them
+	approvals := approval.NewConfigMapStore(k8sClient, leader.Namespace(""), approval.ConfigMapName)
+	self := types.NamespacedName{Namespace: leader.Namespace(""), Name: leader.Identity()}
+	// so a deployment next to the CronJob does not catch up on runs it just did
+	lastRuns := remediator.NewLastRuns(k8sClient, self.Namespace, remediator.LastRunsConfigMap)
+	specs := remediatorSpecs(logger, remediatorPolicy, *dryRun, recorder, nil, notifier, approvals, namespaces, self, lastRuns)
+
+	outcomes, err := remediator.RunOnce(syncCtx, logger, k8sClient, registrations, remediatorPolicy.Budget, specs)
+	if err != nil {
+		logger.Error("Error running remediators", zap.Error(err))
+		return 1
+	}
+
+	summary := runOnceSummary{Succeeded: true, Remediators: outcomes}
+	if summary.Remediators == nil {
+		summary.Remediators = []remediator.Outcome{}
+	}
+	for _, outcome := range outcomes {
+		if outcome.Failed() {
+			summary.Succeeded = false
+		}
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(summary); err != nil {
+		logger.Error("Error printing summary", zap.Error(err)) // untested section
+		return 1
+	}
+	if !summary.Succeeded {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checks the config files like the remediator does on start, without a cluster, and exits with 1 when any is invalid.
+// Without arguments the files in ./config are checked, otherwise the ConfigMaps in the given manifests, so it can run
+// as a pre-merge check
+func validateConfig(args []string) int {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: remediator validate-config [configmap.yaml ...]")
+		fmt.Fprintln(flags.Output(), "Checks the files in ./config, or the ConfigMaps in the manifests, and exits with 1 when any is invalid.")
+	}
+	flags.Parse(args)
+
+	var errs []error
+	if flags.NArg() == 0 {
+		errs = validateConfigDir(nil)
+	}
+	for _, manifest := range flags.Args() {
+		configMaps, err := readConfigMaps(manifest)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", manifest, err))
+			continue
+		}
+		if len(configMaps) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no ConfigMap with config files", manifest))
+		}
+		for _, configMap := range configMaps {
+			for _, err := range validateConfigMap(configMap) {
+				errs = append(errs, fmt.Errorf("%s: ConfigMap %s/%s: %w", manifest, configMap.Namespace, configMap.Name, err))
+			}
+		}
+	}
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Println("OK")
+	return 0
+}
+
+// names of the files in config/ by the name of the ConfigMap key they are mounted from
+func configFiles() map[string]bool {
+	files := map[string]bool{filepath.Base(policy.ConfigFile()): true}
+	for _, registration := range remediator.Registrations() {
+		if registration.ConfigFile != nil {
+			files[filepath.Base(registration.ConfigFile())] = true
+		}
+	}
+	return files
+}
+
+// every ConfigMap in the manifest that has a config file, manifests can have multiple yaml documents or be json
+func readConfigMaps(manifest string) ([]corev1.ConfigMap, error) {
+	file, err := os.Open(manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	files := configFiles()
+	var configMaps []corev1.ConfigMap
+	decoder := yaml.NewYAMLOrJSONDecoder(file, 4096)
+	for {
+		var configMap corev1.ConfigMap
+		if err := decoder.Decode(&configMap); err != nil {
+			if errors.Is(err, io.EOF) {
+				return configMaps, nil
+			}
+			return nil, err
+		}
+		if configMap.Kind != "ConfigMap" {
+			continue
+		}
+		for key := range configMap.Data {
+			if files[key] {
+				configMaps = append(configMaps, configMap)
+				break
+			}
+		}
+	}
+}
+
+// writes the files of the ConfigMap where they are mounted and checks them, files it does not have are not checked
+func validateConfigMap(configMap corev1.ConfigMap) []error {
+	files := configFiles()
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, key := range keys {
+		if !files[key] {
+			errs = append(errs, fmt.Errorf("unknown config file %q", key))
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "validate-config")
+	if err != nil {
+		return append(errs, err) // untested section
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, policy.ConfigPath), 0755); err != nil {
+		return append(errs, err) // untested section
+	}
+	present := map[string]bool{}
+	for _, key := range keys {
+		if !files[key] {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, policy.ConfigPath, key), []byte(configMap.Data[key]), 0644); err != nil {
+			return append(errs, err) // untested section
+		}
+		present[key] = true
+	}
+
+	// config file paths are relative to the working directory
+	cwd, err := os.Getwd()
+	if err != nil {
+		return append(errs, err) // untested section
+	}
+	if err := os.Chdir(dir); err != nil {
+		return append(errs, err) // untested section
+	}
+	defer os.Chdir(cwd)
+	for _, err := range validateConfigDir(present) {
+		// viper names the policy by its absolute path
+		errs = append(errs, errors.New(strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), "")))
+	}
+	return errs
+}
+
+// checks the policy and remediator config files in ./config, only the present ones unless present is nil
+func validateConfigDir(present map[string]bool) []error {
+	var errs []error
+	if present == nil || present[filepath.Base(policy.ConfigFile())] {
+		if _, err := loadPolicy(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, registration := range remediator.Registrations() {
+		if registration.ConfigFile == nil {
+			continue
+		}
+		if present != nil && !present[filepath.Base(registration.ConfigFile())] {
+			continue
+		}
+		if err := remediator.ValidateConfig(registration); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,373 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/util/retry"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatePending  = "pending"
+	StateApproved = "approved"
+	StateRejected = "rejected"
+)
+
+var (
+	ErrNotFound = errors.New("no such remediation, it might have expired")
+	ErrDecided  = errors.New("remediations can only be decided once")
+)
+
+// ConfigMapName is where remediations are kept, in the namespace kube-remediator runs in
+const ConfigMapName = "kube-remediator-approvals"
+
+// how often Run checks for approvals decided on other replicas
+var Interval = time.Minute
+
+// an action a remediator proposed instead of taking it
+type Remediation struct {
+	ID         string    `json:"id"`
+	Remediator string    `json:"remediator"`
+	Action     string    `json:"action"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid"` // a replacement with the same name needs its own approval
+	Reason     string    `json:"reason"`
+	State      string    `json:"state"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	DecidedBy  string    `json:"decidedBy,omitempty"`
+}
+
+// zero values match everything
+type Filter struct {
+	Namespace  string
+	Remediator string
+	State      string
+}
+
+// what the store needs from the client, implemented by k8s.Client
+type configMaps interface {
+	GetConfigMap(namespace string, name string) (*v1.ConfigMap, error)
+	PatchConfigMap(namespace string, name string, patch []byte) (*v1.ConfigMap, error)
+}
+
+// Keeps proposed remediations until they were acted on or expired. Approved ones are acted on by the remediator
+// once it checked the object still needs it.
+// With a ConfigMap it is the source of truth, so every replica lists and decides on the same remediations
+// and they survive restarts and new leaders, otherwise they are kept in memory and proposed again after a restart.
+// Proposing and completing work on the remediations as last read, a conflict tells they changed since,
+// listing reads them again, which Run does regularly.
+type Store struct {
+	lock        sync.Mutex
+	items       map[string]*Remediation       // by id, as last read from the ConfigMap
+	loaded      bool                          // items were read at least once
+	subscribers map[string]*func(Remediation) // by remediator, told about approvals
+	notified    map[string]bool               // approvals subscribers were told about, by id
+	now         func() time.Time
+
+	reviews         reviews    // who may decide over http, nil refuses all decisions there
+	configMaps      configMaps // nil keeps remediations in memory only
+	namespace       string
+	name            string
+	resourceVersion string // of the ConfigMap when it was read, so concurrent changes are not overwritten
+}
+
+func NewStore() *Store {
+	return &Store{items: map[string]*Remediation{}, subscribers: map[string]*func(Remediation){}, notified: map[string]bool{},
+		now: time.Now}
+}
+
+// keeps remediations in data of the ConfigMap by id, it is created when the first one is proposed
+func NewConfigMapStore(configMaps configMaps, namespace string, name string) *Store {
+	s := NewStore()
+	s.configMaps, s.namespace, s.name = configMaps, namespace, name
+	return s
+}
+
+// the remediation of the object that is not expired, proposing it when there is none, created says which
+func (s *Store) Propose(remediation Remediation, expiry time.Duration) (current Remediation, created bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err = s.update(func() (map[string]*Remediation, error) {
+		changes := s.expired()
+		for _, item := range s.items {
+			if item.Remediator == remediation.Remediator && item.Action == remediation.Action && item.UID == remediation.UID &&
+				item.Namespace == remediation.Namespace && item.Name == remediation.Name {
+				current, created = *item, false
+				return changes, nil // only cleans up, the remediation is there either way
+			}
+		}
+		current = remediation
+		current.ID = utilrand.String(10)
+		current.State = StatePending
+		current.Created = s.now()
+		current.Expires = current.Created.Add(expiry)
+		proposed := current
+		changes[current.ID] = &proposed
+		created = true
+		return changes, nil
+	})
+	if err != nil {
+		return Remediation{}, false, err
+	}
+	return current, created, nil
+}
+
+// approves or rejects a pending remediation, by says who for the record
+func (s *Store) Decide(id string, approve bool, by string) (Remediation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// decisions come from people looking at a list read from another replica, so they always start from the ConfigMap
+	if err := s.load(); err != nil {
+		return Remediation{}, err
+	}
+	var decided Remediation
+	err := s.update(func() (map[string]*Remediation, error) {
+		item, found := s.items[id]
+		if !found || !s.now().Before(item.Expires) {
+			return nil, ErrNotFound
+		}
+		if item.State != StatePending {
+			decided = *item
+			return nil, fmt.Errorf("remediation %s was already %s: %w", id, item.State, ErrDecided)
+		}
+		decided = *item
+		decided.State = StateRejected
+		if approve {
+			decided.State = StateApproved
+		}
+		decided.DecidedBy = by
+		changed := decided
+		return map[string]*Remediation{id: &changed}, nil
+	})
+	if err != nil && !errors.Is(err, ErrDecided) {
+		return Remediation{}, err
+	}
+	return decided, err
+}
+
+// the remediation that is not expired, read from the ConfigMap
+func (s *Store) get(id string) (Remediation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return Remediation{}, err
+	}
+	item, found := s.items[id]
+	if !found || !s.now().Before(item.Expires) {
+		return Remediation{}, ErrNotFound
+	}
+	return *item, nil
+}
+
+// forgets a remediation that was acted on
+func (s *Store) Complete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.update(func() (map[string]*Remediation, error) {
+		return map[string]*Remediation{id: nil}, nil
+	})
+}
+
+// remediations that are not expired, oldest first
+func (s *Store) List(filter Filter) ([]Remediation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	now := s.now()
+	remediations := []Remediation{}
+	for _, item := range s.items {
+		if now.Before(item.Expires) &&
+			(filter.Namespace == "" || item.Namespace == filter.Namespace) &&
+			(filter.Remediator == "" || item.Remediator == filter.Remediator) &&
+			(filter.State == "" || item.State == filter.State) {
+			remediations = append(remediations, *item)
+		}
+	}
+	sort.Slice(remediations, func(i, j int) bool { return remediations[i].Created.Before(remediations[j].Created) })
+	return remediations, nil
+}
+
+// fn is called with remediations of the remediator approved on /approvals, so it can act without waiting for its next run.
+// Unsubscribing leaves alone whoever subscribed for the remediator since, like a restarted remediator.
+func (s *Store) Subscribe(remediator string, fn func(Remediation)) (unsubscribe func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	subscription := &fn
+	s.subscribers[remediator] = subscription
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.subscribers[remediator] == subscription {
+			delete(s.subscribers, remediator)
+		}
+	}
+}
+
+// tells subscribers about remediations approved on other replicas, which share the ConfigMap,
+// until ctx is done
+func (s *Store) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check tells subscribers about approvals they were not told about yet.
+// Failing to read is left to the next check, remediators report it when proposing.
+func (s *Store) Check() {
+	approved, err := s.List(Filter{State: StateApproved})
+	if err != nil {
+		return
+	}
+	current := map[string]bool{}
+	for _, remediation := range approved {
+		current[remediation.ID] = true
+		s.notify(remediation)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id := range s.notified {
+		if !current[id] {
+			delete(s.notified, id)
+		}
+	}
+}
+
+// only once per remediation, acting can take a while
+func (s *Store) notify(remediation Remediation) {
+	s.lock.Lock()
+	subscription := s.subscribers[remediation.Remediator]
+	if subscription == nil || s.notified[remediation.ID] {
+		s.lock.Unlock()
+		return
+	}
+	s.notified[remediation.ID] = true
+	s.lock.Unlock()
+	(*subscription)(remediation)
+}
+
+// removals of expired remediations, needs the lock
+func (s *Store) expired() map[string]*Remediation {
+	changes := map[string]*Remediation{}
+	now := s.now()
+	for id, item := range s.items {
+		if !now.Before(item.Expires) {
+			changes[id] = nil
+		}
+	}
+	return changes
+}
+
+// applies the changes fn makes to the remediations, reading them again when they changed since and trying again,
+// like when another replica decided in the meantime. Needs the lock.
+func (s *Store) update(fn func() (map[string]*Remediation, error)) error {
+	reload := !s.loaded
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if reload {
+			if err := s.load(); err != nil {
+				return err
+			}
+		}
+		reload = true
+		changes, err := fn()
+		if err != nil {
+			return err
+		}
+		return s.save(changes)
+	})
+}
+
+// reads the ConfigMap, needs the lock
+func (s *Store) load() error {
+	if s.configMaps == nil {
+		s.loaded = true
+		return nil
+	}
+	configMap, err := s.configMaps.GetConfigMap(s.namespace, s.name)
+	if apierrors.IsNotFound(err) {
+		s.items, s.resourceVersion, s.loaded = map[string]*Remediation{}, "", true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading remediations from ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+	s.use(configMap)
+	return nil
+}
+
+// remembers the remediations of the ConfigMap as read or patched, needs the lock
+func (s *Store) use(configMap *v1.ConfigMap) {
+	items := map[string]*Remediation{}
+	for id, value := range configMap.Data {
+		var item Remediation
+		if err := json.Unmarshal([]byte(value), &item); err != nil {
+			continue // unreadable ones can not be acted on, they are left alone
+		}
+		items[id] = &item
+	}
+	s.items, s.resourceVersion, s.loaded = items, configMap.ResourceVersion, true
+}
+
+// stores changed remediations, nil removes them, needs the lock.
+// Fails with a conflict when the ConfigMap changed since it was read, like when two replicas decide at once.
+func (s *Store) save(changes map[string]*Remediation) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if s.configMaps == nil {
+		for id, item := range changes {
+			if item == nil {
+				delete(s.items, id)
+			} else {
+				s.items[id] = item
+			}
+		}
+		return nil
+	}
+	data := map[string]interface{}{}
+	for id, item := range changes {
+		if item == nil {
+			data[id] = nil
+			continue
+		}
+		value, _ := json.Marshal(item)
+		data[id] = string(value)
+	}
+	patch := map[string]interface{}{"data": data}
+	if s.resourceVersion != "" {
+		patch["metadata"] = map[string]interface{}{"resourceVersion": s.resourceVersion}
+	}
+	content, _ := json.Marshal(patch)
+	configMap, err := s.configMaps.PatchConfigMap(s.namespace, s.name, content)
+	if err != nil {
+		return fmt.Errorf("storing remediations in ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+	s.use(configMap) // includes changes of others when it was just created
+	return nil
+}
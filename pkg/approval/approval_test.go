@@ -0,0 +1,610 @@
+package approval_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
+	"github.com/stretchr/testify/suite"
+	"gotest.tools/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TestApprovalSuite struct {
+	suite.Suite
+	store   *approval.Store
+	reviews *fakeReviews
+	mux     *http.ServeMux
+	t       *testing.T
+}
+
+func TestSuiteApproval(t *testing.T) {
+	suite.Run(t, &TestApprovalSuite{t: t})
+}
+
+func (suite *TestApprovalSuite) SetupTest() {
+	suite.store = approval.NewStore()
+	suite.reviews = newFakeReviews()
+	suite.store.AuthorizeWith(suite.reviews)
+	suite.mux = http.NewServeMux()
+	assert.NilError(suite.t, suite.store.RegisterHandler(suite.mux))
+}
+
+// TokenReviews and SubjectAccessReviews of k8s.Client, alice may decide in default, bob may not
+type fakeReviews struct {
+	lock     sync.Mutex
+	users    map[string]authenticationv1.UserInfo // by token
+	allowed  map[string]bool                      // by user, verb and namespace
+	reviewed []authorizationv1.ResourceAttributes
+	err      error // of reviewing tokens
+	denyErr  error // of reviewing access
+}
+
+func newFakeReviews() *fakeReviews {
+	return &fakeReviews{
+		users: map[string]authenticationv1.UserInfo{
+			"alice-token": {Username: "alice"},
+			"bob-token":   {Username: "bob"},
+		},
+		allowed: map[string]bool{"alice/approve/default": true, "alice/reject/default": true},
+	}
+}
+
+func (f *fakeReviews) ReviewToken(token string) (authenticationv1.UserInfo, bool, error) {
+	user, found := f.users[token]
+	return user, found, f.err
+}
+
+func (f *fakeReviews) ReviewAccess(user authenticationv1.UserInfo, attributes authorizationv1.ResourceAttributes) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.reviewed = append(f.reviewed, attributes)
+	return f.allowed[user.Username+"/"+attributes.Verb+"/"+attributes.Namespace], f.denyErr
+}
+
+func remediation(name string) approval.Remediation {
+	return approval.Remediation{
+		Remediator: "CrashLoopBackOffRescheduler",
+		Action:     "delete",
+		Kind:       "Pod",
+		Namespace:  "default",
+		Name:       name,
+		UID:        types.UID("uid-" + name),
+		Reason:     "CrashLoopBackOff",
+	}
+}
+
+func (suite *TestApprovalSuite) list(filter approval.Filter) []approval.Remediation {
+	remediations, err := suite.store.List(filter)
+	assert.NilError(suite.t, err)
+	return remediations
+}
+
+func (suite *TestApprovalSuite) request(method string, target string) *httptest.ResponseRecorder {
+	return suite.requestAs("alice-token", method, target)
+}
+
+func (suite *TestApprovalSuite) requestAs(token string, method string, target string) *httptest.ResponseRecorder {
+	return requestAs(suite.mux, token, method, target)
+}
+
+func (suite *TestApprovalSuite) TestProposesOnce() {
+	first, created, _ := suite.store.Propose(remediation("foo"), time.Hour)
+	assert.Assert(suite.t, created)
+	assert.Equal(suite.t, first.State, approval.StatePending)
+	assert.Assert(suite.t, first.ID != "")
+	assert.Equal(suite.t, first.Expires, first.Created.Add(time.Hour))
+
+	second, created, _ := suite.store.Propose(remediation("foo"), time.Hour)
+	assert.Assert(suite.t, !created)
+	assert.Equal(suite.t, second.ID, first.ID)
+
+	_, created, _ = suite.store.Propose(remediation("bar"), time.Hour)
+	assert.Assert(suite.t, created)
+	assert.Equal(suite.t, len(suite.list(approval.Filter{})), 2)
+}
+
+func (suite *TestApprovalSuite) TestProposesReplacementsAgain() {
+	first, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+	replacement := remediation("foo")
+	replacement.UID = "other"
+	second, created, _ := suite.store.Propose(replacement, time.Hour)
+	assert.Assert(suite.t, created)
+	assert.Assert(suite.t, second.ID != first.ID)
+}
+
+func (suite *TestApprovalSuite) TestExpires() {
+	suite.store.Propose(remediation("foo"), 0)
+	assert.Equal(suite.t, len(suite.list(approval.Filter{})), 0)
+}
+
+func (suite *TestApprovalSuite) TestDecides() {
+	proposed, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+	decided, err := suite.store.Decide(proposed.ID, true, "alice")
+	assert.NilError(suite.t, err)
+	assert.Equal(suite.t, decided.State, approval.StateApproved)
+	assert.Equal(suite.t, decided.DecidedBy, "alice")
+
+	_, err = suite.store.Decide(proposed.ID, false, "bob")
+	assert.ErrorContains(suite.t, err, "already approved")
+
+	assert.NilError(suite.t, suite.store.Complete(proposed.ID))
+	_, err = suite.store.Decide(proposed.ID, true, "alice")
+	assert.Equal(suite.t, err, approval.ErrNotFound)
+}
+
+func (suite *TestApprovalSuite) TestListsWithFilter() {
+	foo, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+	suite.store.Propose(remediation("bar"), time.Hour)
+	suite.store.Decide(foo.ID, false, "")
+
+	response := suite.request(http.MethodGet, "/approvals?state=pending")
+	assert.Equal(suite.t, response.Code, http.StatusOK)
+	var listed []approval.Remediation
+	assert.NilError(suite.t, json.NewDecoder(response.Body).Decode(&listed))
+	assert.Equal(suite.t, len(listed), 1)
+	assert.Equal(suite.t, listed[0].Name, "bar")
+}
+
+func (suite *TestApprovalSuite) TestApprovesOverHttp() {
+	notified := make(chan approval.Remediation, 1)
+	suite.store.Subscribe("CrashLoopBackOffRescheduler", func(remediation approval.Remediation) { notified <- remediation })
+	proposed, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+
+	response := suite.request(http.MethodPost, "/approvals/"+proposed.ID+"/approve?by=mallory")
+	assert.Equal(suite.t, response.Code, http.StatusOK)
+	var decided approval.Remediation
+	assert.NilError(suite.t, json.NewDecoder(response.Body).Decode(&decided))
+	assert.Equal(suite.t, decided.State, approval.StateApproved)
+	assert.Equal(suite.t, decided.DecidedBy, "alice") // who the token belongs to, not who the caller claims to be
+	assert.DeepEqual(suite.t, suite.reviews.reviewed, []authorizationv1.ResourceAttributes{{
+		Namespace: "default", Verb: "approve", Group: "kube-remediator.io", Resource: "remediations", Name: proposed.ID,
+	}})
+	assert.Equal(suite.t, (<-notified).ID, proposed.ID)
+
+	assert.Equal(suite.t, suite.request(http.MethodPost, "/approvals/"+proposed.ID+"/reject").Code, http.StatusConflict)
+}
+
+func (suite *TestApprovalSuite) TestRejectsOverHttp() {
+	suite.store.Subscribe("CrashLoopBackOffRescheduler", func(approval.Remediation) { suite.Fail("notified about rejection") })
+	proposed, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+
+	assert.Equal(suite.t, suite.request(http.MethodPost, "/approvals/"+proposed.ID+"/reject").Code, http.StatusOK)
+	assert.Equal(suite.t, suite.list(approval.Filter{})[0].State, approval.StateRejected)
+}
+
+func (suite *TestApprovalSuite) TestRefusesInvalidRequests() {
+	proposed, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+	assert.Equal(suite.t, suite.request(http.MethodGet, "/approvals/"+proposed.ID+"/approve").Code, http.StatusMethodNotAllowed)
+	assert.Equal(suite.t, suite.request(http.MethodPost, "/approvals/"+proposed.ID+"/maybe").Code, http.StatusNotFound)
+	assert.Equal(suite.t, suite.request(http.MethodPost, "/approvals/unknown/approve").Code, http.StatusNotFound)
+}
+
+func (suite *TestApprovalSuite) TestOnlyLetsAuthorizedUsersDecide() {
+	proposed, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+
+	assert.Equal(suite.t, suite.requestAs("", http.MethodPost, "/approvals/"+proposed.ID+"/approve").Code, http.StatusUnauthorized)
+	assert.Equal(suite.t, suite.requestAs("unknown", http.MethodPost, "/approvals/"+proposed.ID+"/approve").Code, http.StatusUnauthorized)
+	response := suite.requestAs("bob-token", http.MethodPost, "/approvals/"+proposed.ID+"/approve")
+	assert.Equal(suite.t, response.Code, http.StatusForbidden)
+	assert.Equal(suite.t, strings.TrimSpace(response.Body.String()), "bob may not approve remediations in namespace default")
+	assert.Equal(suite.t, suite.list(approval.Filter{})[0].State, approval.StatePending)
+
+	// unknown remediations are not told apart from forbidden ones before knowing who asks
+	assert.Equal(suite.t, suite.requestAs("unknown", http.MethodPost, "/approvals/unknown/approve").Code, http.StatusUnauthorized)
+}
+
+func (suite *TestApprovalSuite) TestReportsFailingReviews() {
+	proposed, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+	suite.reviews.err = errors.New("Foo")
+	assert.Equal(suite.t, suite.request(http.MethodPost, "/approvals/"+proposed.ID+"/approve").Code, http.StatusInternalServerError)
+
+	suite.reviews.err, suite.reviews.denyErr = nil, errors.New("Foo")
+	assert.Equal(suite.t, suite.request(http.MethodPost, "/approvals/"+proposed.ID+"/approve").Code, http.StatusInternalServerError)
+	assert.Equal(suite.t, suite.list(approval.Filter{})[0].State, approval.StatePending)
+}
+
+func (suite *TestApprovalSuite) TestRefusesDecisionsWithoutReviews() {
+	store := approval.NewStore()
+	proposed, _, _ := store.Propose(remediation("foo"), time.Hour)
+	mux := http.NewServeMux()
+	assert.NilError(suite.t, store.RegisterHandler(mux))
+
+	response := requestAs(mux, "alice-token", http.MethodPost, "/approvals/"+proposed.ID+"/approve")
+	assert.Equal(suite.t, response.Code, http.StatusForbidden)
+	listed, err := store.List(approval.Filter{})
+	assert.NilError(suite.t, err)
+	assert.Equal(suite.t, listed[0].State, approval.StatePending)
+}
+
+func (suite *TestApprovalSuite) TestUnsubscribes() {
+	unsubscribe := suite.store.Subscribe("CrashLoopBackOffRescheduler", func(approval.Remediation) { suite.Fail("unsubscribed") })
+	unsubscribe()
+	proposed, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+	_, err := suite.store.Decide(proposed.ID, true, "alice")
+	assert.NilError(suite.t, err)
+	suite.store.Check()
+}
+
+func (suite *TestApprovalSuite) TestUnsubscribingKeepsNewerSubscriptions() {
+	notified := make(chan approval.Remediation, 1)
+	unsubscribe := suite.store.Subscribe("CrashLoopBackOffRescheduler", func(approval.Remediation) { suite.Fail("old subscription") })
+	suite.store.Subscribe("CrashLoopBackOffRescheduler", func(remediation approval.Remediation) { notified <- remediation })
+	unsubscribe()
+	proposed, _, _ := suite.store.Propose(remediation("foo"), time.Hour)
+
+	suite.request(http.MethodPost, "/approvals/"+proposed.ID+"/approve")
+	assert.Equal(suite.t, (<-notified).ID, proposed.ID)
+}
+
+// the ConfigMap calls of k8s.Client against a fake clientset, shared by stores like replicas share the cluster
+type clusterConfigMaps struct {
+	clientSet   *fake.Clientset
+	beforePatch func() // changes made by someone else in the meantime
+}
+
+var configMapsResource = corev1.SchemeGroupVersion.WithResource("configmaps")
+
+// refuses patches with a resourceVersion that is not current and counts up resourceVersions like the API server,
+// the fake clientset does neither
+func newClusterConfigMaps() *clusterConfigMaps {
+	clientSet := fake.NewSimpleClientset()
+	tracker := clientSet.Tracker()
+	clientSet.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		object, err := tracker.Get(configMapsResource, patch.GetNamespace(), patch.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		var changes struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+			Data map[string]*string `json:"data"`
+		}
+		if err := json.Unmarshal(patch.GetPatch(), &changes); err != nil {
+			return true, nil, err
+		}
+		configMap := object.(*corev1.ConfigMap).DeepCopy()
+		if version := changes.Metadata.ResourceVersion; version != "" && version != configMap.ResourceVersion {
+			return true, nil, apierrors.NewConflict(configMapsResource.GroupResource(), patch.GetName(), errors.New("changed"))
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		for key, value := range changes.Data {
+			if value == nil {
+				delete(configMap.Data, key)
+			} else {
+				configMap.Data[key] = *value
+			}
+		}
+		version, _ := strconv.Atoi(configMap.ResourceVersion)
+		configMap.ResourceVersion = strconv.Itoa(version + 1)
+		return true, configMap, tracker.Update(configMapsResource, configMap, patch.GetNamespace())
+	})
+	return &clusterConfigMaps{clientSet: clientSet}
+}
+
+func (c *clusterConfigMaps) GetConfigMap(namespace string, name string) (*corev1.ConfigMap, error) {
+	return c.clientSet.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func (c *clusterConfigMaps) PatchConfigMap(namespace string, name string, patch []byte) (*corev1.ConfigMap, error) {
+	if hook := c.beforePatch; hook != nil {
+		c.beforePatch = nil
+		hook()
+	}
+	ctx := context.Background()
+	configMaps := c.clientSet.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMaps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if !apierrors.IsNotFound(err) {
+		return configMap, err
+	}
+	_, err = configMaps.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return configMaps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
+
+// what is stored, by id
+func (c *clusterConfigMaps) data(t *testing.T) map[string]string {
+	configMap, err := c.GetConfigMap("kube-system", approval.ConfigMapName)
+	assert.NilError(t, err)
+	return configMap.Data
+}
+
+// how often the ConfigMap was read
+func (c *clusterConfigMaps) reads() int {
+	count := 0
+	for _, action := range c.clientSet.Actions() {
+		if action.GetVerb() == "get" {
+			count++
+		}
+	}
+	return count
+}
+
+// fails every call with err
+func (c *clusterConfigMaps) fail(err error) {
+	c.clientSet.PrependReactor("*", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, err
+	})
+}
+
+// alice may decide, see newFakeReviews
+func newMux(t *testing.T, store *approval.Store) *http.ServeMux {
+	store.AuthorizeWith(newFakeReviews())
+	mux := http.NewServeMux()
+	assert.NilError(t, store.RegisterHandler(mux))
+	return mux
+}
+
+func request(mux *http.ServeMux, method string, target string) *httptest.ResponseRecorder {
+	return requestAs(mux, "alice-token", method, target)
+}
+
+func requestAs(mux *http.ServeMux, token string, method string, target string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	mux.ServeHTTP(response, request)
+	return response
+}
+
+func TestConfigMapStoreIsSharedByReplicas(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	leader := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	follower := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	notified := make(chan approval.Remediation, 1)
+	leader.Subscribe("CrashLoopBackOffRescheduler", func(remediation approval.Remediation) { notified <- remediation })
+
+	proposed, created, err := leader.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	assert.Assert(t, created)
+
+	response := request(newMux(t, follower), http.MethodPost, "/approvals/"+proposed.ID+"/approve")
+	assert.Equal(t, response.Code, http.StatusOK)
+
+	// the leader acts on approvals decided elsewhere once it checked, once
+	leader.Check()
+	assert.Equal(t, (<-notified).ID, proposed.ID)
+	leader.Check()
+	assert.Equal(t, len(notified), 0)
+	current, created, err := leader.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	assert.Assert(t, !created)
+	assert.Equal(t, current.State, approval.StateApproved)
+	assert.Equal(t, current.DecidedBy, "alice")
+
+	assert.NilError(t, leader.Complete(proposed.ID))
+	listed, err := follower.List(approval.Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(listed), 0)
+	leader.Check()
+	assert.Equal(t, len(notified), 0)
+}
+
+func TestConfigMapStoreSurvivesRestarts(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	store := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	proposed, _, err := store.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	_, _, err = store.Propose(remediation("expired"), 0)
+	assert.NilError(t, err)
+
+	restarted := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	listed, err := restarted.List(approval.Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(listed), 1)
+	assert.Equal(t, listed[0].ID, proposed.ID)
+
+	// expired ones are removed with the next change
+	_, _, err = restarted.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	assert.Equal(t, len(configMaps.data(t)), 1)
+}
+
+func TestConfigMapStoreRefusesConcurrentDecisions(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	store := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	other := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	proposed, _, err := store.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+
+	configMaps.beforePatch = func() {
+		_, err := other.Decide(proposed.ID, false, "bob")
+		assert.NilError(t, err)
+	}
+	response := request(newMux(t, store), http.MethodPost, "/approvals/"+proposed.ID+"/approve")
+	assert.Equal(t, response.Code, http.StatusConflict)
+
+	listed, err := store.List(approval.Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, listed[0].State, approval.StateRejected)
+	assert.Equal(t, listed[0].DecidedBy, "bob")
+}
+
+func TestConfigMapStoreReportsUnreadableConfigMap(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	configMaps.fail(errors.New("forbidden"))
+	store := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+
+	_, _, err := store.Propose(remediation("foo"), time.Hour)
+	assert.ErrorContains(t, err, "forbidden")
+	mux := newMux(t, store)
+	assert.Equal(t, request(mux, http.MethodGet, "/approvals").Code, http.StatusInternalServerError)
+	assert.Equal(t, request(mux, http.MethodPost, "/approvals/foo/approve").Code, http.StatusInternalServerError)
+	_, err = store.Decide("foo", true, "alice")
+	assert.ErrorContains(t, err, "forbidden")
+	store.Subscribe("CrashLoopBackOffRescheduler", func(approval.Remediation) { t.Fatal("notified without approvals") })
+	store.Check()
+}
+
+func TestConfigMapStoreReportsUnwritableConfigMap(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	store := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	proposed, _, err := store.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	configMaps.clientSet.PrependReactor("patch", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	response := request(newMux(t, store), http.MethodPost, "/approvals/"+proposed.ID+"/approve")
+	assert.Equal(t, response.Code, http.StatusInternalServerError)
+	assert.Assert(t, strings.Contains(response.Body.String(), "forbidden"))
+}
+
+func TestConfigMapStoreKeepsUnreadableRemediations(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	_, err := configMaps.clientSet.CoreV1().ConfigMaps("kube-system").Create(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: approval.ConfigMapName, Namespace: "kube-system"},
+		Data:       map[string]string{"broken": "{"},
+	}, metav1.CreateOptions{})
+	assert.NilError(t, err)
+	store := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+
+	_, _, err = store.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	listed, err := store.List(approval.Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(listed), 1)
+	assert.Equal(t, configMaps.data(t)["broken"], "{")
+}
+
+func TestRunChecksForApprovalsUntilDone(t *testing.T) {
+	interval := approval.Interval
+	approval.Interval = time.Millisecond
+	defer func() { approval.Interval = interval }()
+
+	configMaps := newClusterConfigMaps()
+	store := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	other := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	notified := make(chan approval.Remediation, 1)
+	store.Subscribe("CrashLoopBackOffRescheduler", func(remediation approval.Remediation) { notified <- remediation })
+	proposed, _, err := store.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	_, err = other.Decide(proposed.ID, true, "alice")
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go store.Run(ctx, &wg)
+	assert.Equal(t, (<-notified).ID, proposed.ID)
+	cancel()
+	wg.Wait()
+}
+
+func TestConfigMapStoreOnlyReadsWhenChanged(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	store := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	other := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	proposed, _, err := store.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	_, _, err = store.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+	assert.Equal(t, configMaps.reads(), 1)
+
+	// the change of the other replica makes the next one conflict, which is when it reads again
+	_, err = other.Decide(proposed.ID, true, "alice")
+	assert.NilError(t, err)
+	_, created, err := store.Propose(remediation("bar"), time.Hour)
+	assert.NilError(t, err)
+	assert.Assert(t, created)
+	assert.Equal(t, configMaps.reads(), 3)
+	assert.Equal(t, len(configMaps.data(t)), 2)
+}
+
+func TestConfigMapStoreRetriesConflictingChanges(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	leader := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	follower := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	foo, _, err := leader.Propose(remediation("foo"), time.Hour)
+	assert.NilError(t, err)
+
+	configMaps.beforePatch = func() {
+		_, err := follower.Decide(foo.ID, true, "alice")
+		assert.NilError(t, err)
+	}
+	_, created, err := leader.Propose(remediation("bar"), time.Hour)
+	assert.NilError(t, err)
+	assert.Assert(t, created)
+
+	listed, err := follower.List(approval.Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(listed), 2)
+	assert.Equal(t, listed[0].State, approval.StateApproved)
+	assert.Equal(t, listed[1].State, approval.StatePending)
+}
+
+func TestConfigMapStoreKeepsConcurrentProposalsAndDecisions(t *testing.T) {
+	configMaps := newClusterConfigMaps()
+	leader := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	followers := []*approval.Store{
+		approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName),
+		approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName),
+	}
+	var proposed []approval.Remediation
+	for i := 0; i < 5; i++ {
+		remediation, _, err := leader.Propose(remediation("old-"+strconv.Itoa(i)), time.Hour)
+		assert.NilError(t, err)
+		proposed = append(proposed, remediation)
+	}
+
+	// every follower decides on every remediation while the leader keeps proposing, only one decision can win
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	decisions := map[string]int{}
+	for i := range proposed {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, err := leader.Propose(remediation("new-"+strconv.Itoa(i)), time.Hour)
+			assert.NilError(t, err)
+		}(i)
+		for f, follower := range followers {
+			wg.Add(1)
+			go func(id string, follower *approval.Store, approve bool) {
+				defer wg.Done()
+				_, err := follower.Decide(id, approve, "")
+				if errors.Is(err, approval.ErrDecided) {
+					return
+				}
+				assert.NilError(t, err)
+				lock.Lock()
+				defer lock.Unlock()
+				decisions[id]++
+			}(proposed[i].ID, follower, f == 0)
+		}
+	}
+	wg.Wait()
+
+	for _, remediation := range proposed {
+		assert.Equal(t, decisions[remediation.ID], 1)
+	}
+	pending, err := leader.List(approval.Filter{State: approval.StatePending})
+	assert.NilError(t, err)
+	assert.Equal(t, len(pending), 5)
+	for _, remediation := range pending {
+		assert.Assert(t, strings.HasPrefix(remediation.Name, "new-"))
+	}
+}
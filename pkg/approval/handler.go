@@ -0,0 +1,128 @@
+package approval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	httpmux "github.com/google/cadvisor/http/mux"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+	"strings"
+)
+
+// deciding on remediations of a namespace needs the verb approve or reject on this resource there,
+// it does not exist, RBAC allows it anyway
+const (
+	ResourceGroup = "kube-remediator.io"
+	Resource      = "remediations"
+)
+
+// what deciding over http needs from the client, implemented by k8s.Client
+type reviews interface {
+	ReviewToken(token string) (user authenticationv1.UserInfo, authenticated bool, err error)
+	ReviewAccess(user authenticationv1.UserInfo, attributes authorizationv1.ResourceAttributes) (bool, error)
+}
+
+// lists remediations on /approvals?namespace=default&state=pending and decides on them with
+// POST /approvals/<id>/approve or /approvals/<id>/reject, see AuthorizeWith
+func (s *Store) RegisterHandler(mux httpmux.Mux) error {
+	mux.HandleFunc("/approvals", s.handleList)
+	mux.HandleFunc("/approvals/", s.handleDecision)
+	return nil
+}
+
+// deciding needs a bearer token of someone allowed to approve or reject remediations in their namespace,
+// who is recorded as the one who decided. Without it nobody can decide over http. Must be called before serving.
+func (s *Store) AuthorizeWith(reviews reviews) {
+	s.reviews = reviews
+}
+
+func (s *Store) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := Filter{Namespace: query.Get("namespace"), Remediator: query.Get("remediator"), State: query.Get("state")}
+	remediations, err := s.List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(remediations)
+}
+
+func (s *Store) handleDecision(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/approvals/"), "/")
+	if len(parts) != 2 || (parts[1] != "approve" && parts[1] != "reject") {
+		http.Error(w, "use /approvals/<id>/approve or /approvals/<id>/reject", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	by, status, err := s.authorize(r, parts[0], parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	remediation, err := s.Decide(parts[0], parts[1] == "approve", by)
+	switch {
+	case errors.Is(err, ErrNotFound): // expired since it was authorized
+		http.Error(w, err.Error(), http.StatusNotFound) // untested section
+		return
+	case errors.Is(err, ErrDecided) || apierrors.IsConflict(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if remediation.State == StateApproved {
+		go s.notify(remediation) // acting can take a while, the remediator checks the object first
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(remediation)
+}
+
+// the name of the user who may decide on the remediation with verb, or the status to fail with.
+// Unknown remediations are only reported to authenticated users.
+func (s *Store) authorize(r *http.Request, id string, verb string) (string, int, error) {
+	if s.reviews == nil {
+		return "", http.StatusForbidden, errors.New("deciding over http is not enabled, use the annotation")
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return "", http.StatusUnauthorized, errors.New("use a bearer token, like kubectl create token")
+	}
+	user, authenticated, err := s.reviews.ReviewToken(token)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if !authenticated {
+		return "", http.StatusUnauthorized, errors.New("invalid bearer token")
+	}
+
+	remediation, err := s.get(id)
+	if errors.Is(err, ErrNotFound) {
+		return "", http.StatusNotFound, err
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	allowed, err := s.reviews.ReviewAccess(user, authorizationv1.ResourceAttributes{
+		Namespace: remediation.Namespace,
+		Verb:      verb,
+		Group:     ResourceGroup,
+		Resource:  Resource,
+		Name:      id,
+	})
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if !allowed {
+		return "", http.StatusForbidden, fmt.Errorf("%s may not %s remediations in namespace %s", user.Username, verb, remediation.Namespace)
+	}
+	return user.Username, http.StatusOK, nil
+}
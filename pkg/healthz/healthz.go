@@ -1,17 +1,95 @@
 package healthz
 
 import (
+	"fmt"
 	httpmux "github.com/google/cadvisor/http/mux"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-// TODO: add more checks as needed
+// how long a check may keep failing before /healthz/reconciles fails, so a short api server outage does not alert
+var Tolerance = 15 * time.Minute
+
+var now = time.Now
+
+type check struct {
+	err          error
+	failingSince time.Time
+}
+
+var (
+	lock   sync.Mutex
+	checks = map[string]check{}
+)
+
+// Report records the latest result of a check, like a reconcile of a remediator
+func Report(name string, err error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err == nil {
+		checks[name] = check{}
+		return
+	}
+	previous, found := checks[name]
+	if !found || previous.err == nil {
+		previous.failingSince = now()
+	}
+	checks[name] = check{err: err, failingSince: previous.failingSince}
+}
+
+// Remove forgets a check, for example of a remediator that stopped
+func Remove(name string) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(checks, name)
+}
+
+// checks that failed for longer than Tolerance, sorted by name
+func failing() []string {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var failing []string
+	for name, check := range checks {
+		if check.err != nil && now().Sub(check.failingSince) >= Tolerance {
+			failing = append(failing, fmt.Sprintf("%s failing since %s: %s", name, check.failingSince.Format(time.RFC3339), check.err))
+		}
+	}
+	sort.Strings(failing)
+	return failing
+}
+
+// only process health for the liveness probe, restarting does not fix failing checks like missing permissions
 func handleHealthz(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
 
+// for the readiness probe, every replica serves while it runs. Failing checks would take the leader out of the
+// Service, which keeps it from serving /remediations and /approvals without fixing anything.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// fails while checks keep failing, for monitoring
+func handleReconciles(w http.ResponseWriter, r *http.Request) {
+	if failing := failing(); len(failing) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Join(failing, "\n")))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
 func RegisterHandler(mux httpmux.Mux) error {
 	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/healthz/reconciles", handleReconciles)
+	mux.HandleFunc("/readyz", handleReadyz)
 	return nil
 }
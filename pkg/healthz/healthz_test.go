@@ -0,0 +1,106 @@
+package healthz
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func useClock(t *testing.T) *time.Time {
+	current := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	t.Cleanup(func() {
+		now = time.Now
+		checks = map[string]check{}
+	})
+	return &current
+}
+
+func get() (int, string) {
+	recorder := httptest.NewRecorder()
+	handleReconciles(recorder, httptest.NewRequest("GET", "/healthz/reconciles", nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestHealthyWithoutChecks(t *testing.T) {
+	useClock(t)
+	status, body := get()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", body)
+}
+
+func TestToleratesShortFailures(t *testing.T) {
+	current := useClock(t)
+	Report("OldPodDeleter", errors.New("getting pod list: timeout"))
+	*current = current.Add(Tolerance - time.Second)
+	Report("OldPodDeleter", errors.New("getting pod list: timeout"))
+
+	status, _ := get()
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestFailsAfterTolerance(t *testing.T) {
+	current := useClock(t)
+	Report("OldPodDeleter", errors.New("getting pod list: timeout"))
+	Report("CompletedPodDeleter", nil)
+	*current = current.Add(Tolerance)
+	Report("OldPodDeleter", errors.New("getting pod list: forbidden"))
+
+	status, body := get()
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "OldPodDeleter failing since 2024-03-01T12:00:00Z: getting pod list: forbidden", body)
+}
+
+func TestLivenessIgnoresFailingChecks(t *testing.T) {
+	current := useClock(t)
+	Report("OldPodDeleter", errors.New("getting pod list: forbidden"))
+	*current = current.Add(Tolerance)
+
+	recorder := httptest.NewRecorder()
+	handleHealthz(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestReadinessIgnoresFailingChecks(t *testing.T) {
+	current := useClock(t)
+	Report("OldPodDeleter", errors.New("getting pod list: forbidden"))
+	*current = current.Add(Tolerance)
+
+	recorder := httptest.NewRecorder()
+	handleReadyz(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestRegistersHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	assert.NoError(t, RegisterHandler(mux))
+	for _, path := range []string{"/healthz", "/healthz/reconciles", "/readyz"} {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+	}
+}
+
+func TestRecoversWithSuccess(t *testing.T) {
+	current := useClock(t)
+	Report("OldPodDeleter", errors.New("getting pod list: timeout"))
+	*current = current.Add(Tolerance)
+	Report("OldPodDeleter", nil)
+	Report("OldPodDeleter", errors.New("getting pod list: timeout")) // failing again starts over
+
+	status, _ := get()
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestForgetsRemovedChecks(t *testing.T) {
+	current := useClock(t)
+	Report("OldPodDeleter", errors.New("getting pod list: timeout"))
+	*current = current.Add(Tolerance)
+	Remove("OldPodDeleter")
+
+	status, _ := get()
+	assert.Equal(t, http.StatusOK, status)
+}
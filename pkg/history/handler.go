@@ -0,0 +1,85 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	httpmux "github.com/google/cadvisor/http/mux"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// serves recent remediations as json, for example /remediations?namespace=default&since=1h
+func (s *Store) RegisterHandler(mux httpmux.Mux) error {
+	mux.HandleFunc("/remediations", s.handleRemediations)
+	return nil
+}
+
+// marks requests a follower passed on, so they are not passed on again while leadership changes
+const forwardedHeader = "X-Kube-Remediator-Forwarded"
+
+// every replica only knows what it did itself, so with leader election followers pass requests on to the leader,
+// leader says where it serves. Must be called before serving.
+func (s *Store) ForwardToLeader(leading func() bool, leader func() (*url.URL, error)) {
+	s.leading, s.leader = leading, leader
+}
+
+func (s *Store) handleRemediations(w http.ResponseWriter, r *http.Request) {
+	if s.leading != nil && !s.leading() {
+		s.forward(w, r)
+		return
+	}
+	query := r.URL.Query()
+	filter := Filter{Namespace: query.Get("namespace"), Remediator: query.Get("remediator")}
+
+	var err error
+	if filter.Since, err = parseTime(query.Get("since")); err != nil {
+		http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = parseTime(query.Get("until")); err != nil {
+		http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.List(filter))
+}
+
+func (s *Store) forward(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(forwardedHeader) != "" {
+		http.Error(w, "not the leader either, try again once a leader is elected", http.StatusServiceUnavailable)
+		return
+	}
+	target, err := s.leader()
+	if err != nil {
+		http.Error(w, "not the leader and can not reach it: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	direct := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		direct(r)
+		r.Header.Set(forwardedHeader, "true")
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, "not the leader and can not reach it: "+err.Error(), http.StatusServiceUnavailable)
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// accepts RFC3339 timestamps or durations that are relative to now, so "1h" means one hour ago
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("%q is neither an RFC3339 time nor a duration", value)
+	}
+	return t, nil
+}
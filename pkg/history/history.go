@@ -0,0 +1,208 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a single action a remediator took or would have taken
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Remediator string    `json:"remediator"`
+	Action     string    `json:"action"`
+	Result     string    `json:"result"` // same as the result label of remediator_pod_actions
+	Reason     string    `json:"reason"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	Owner      *Owner    `json:"owner,omitempty"` // controller of the object, if any
+}
+
+type Owner struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// zero values match everything
+type Filter struct {
+	Namespace  string
+	Remediator string
+	Since      time.Time
+	Until      time.Time
+}
+
+// keeps the most recent entries in memory, optionally appending them to a file so they survive restarts
+type Store struct {
+	lock    sync.Mutex
+	entries []Entry // ring buffer, next is where the next entry goes
+	next    int
+	full    bool
+
+	path      string
+	file      *os.File
+	persisted int // lines in the file, it is compacted when it holds too many old entries
+
+	leading func() bool              // nil serves on every replica
+	leader  func() (*url.URL, error) // where the leader serves, for followers
+}
+
+// size is how many entries are kept, an empty path keeps them in memory only
+func NewStore(size int, path string) (*Store, error) {
+	if size <= 0 {
+		return nil, errors.New("history size needs to be positive")
+	}
+	s := &Store{entries: make([]Entry, size), path: path}
+	if path == "" {
+		return s, nil
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Add(entry Entry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.add(entry)
+	if s.file == nil {
+		return nil
+	}
+	if s.persisted >= 2*len(s.entries) {
+		return s.compact()
+	}
+	return s.persist(entry)
+}
+
+// entries matching the filter, newest first
+func (s *Store) List(filter Filter) []Entry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := []Entry{}
+	for _, entry := range s.newestFirst() {
+		if filter.matches(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *Store) add(entry Entry) {
+	s.entries[s.next] = entry
+	s.next = (s.next + 1) % len(s.entries)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+func (s *Store) newestFirst() []Entry {
+	count := s.next
+	if s.full {
+		count = len(s.entries)
+	}
+	result := make([]Entry, 0, count)
+	for i := 1; i <= count; i++ {
+		result = append(result, s.entries[(s.next-i+len(s.entries))%len(s.entries)])
+	}
+	return result
+}
+
+// reads the entries of a previous run, broken lines from an interrupted write are skipped
+func (s *Store) load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			s.add(entry)
+		}
+	}
+	return scanner.Err()
+}
+
+// rewrites the file with only the entries in memory, so it does not grow forever
+func (s *Store) compact() error {
+	if s.file != nil {
+		s.file.Close() // reopened below
+		s.file = nil
+	}
+
+	tmp := s.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	entries := s.newestFirst()
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := encoder.Encode(entries[i]); err != nil {
+			file.Close() // untested section
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close() // untested section
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err // untested section
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err // untested section
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	s.persisted = len(entries)
+	return err
+}
+
+func (s *Store) persist(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err // untested section
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err // untested section
+	}
+	s.persisted++
+	return nil
+}
+
+func (f Filter) matches(entry Entry) bool {
+	return (f.Namespace == "" || f.Namespace == entry.Namespace) &&
+		(f.Remediator == "" || strings.EqualFold(f.Remediator, entry.Remediator)) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !entry.Time.After(f.Until))
+}
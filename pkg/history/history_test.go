@@ -0,0 +1,239 @@
+package history_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/history"
+	"github.com/stretchr/testify/suite"
+	"gotest.tools/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type TestHistorySuite struct {
+	suite.Suite
+	now  time.Time
+	path string
+	t    *testing.T
+}
+
+func TestSuiteHistory(t *testing.T) {
+	suite.Run(t, &TestHistorySuite{t: t})
+}
+
+func (suite *TestHistorySuite) SetupTest() {
+	suite.now = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	suite.path = filepath.Join(suite.t.TempDir(), "history.jsonl")
+}
+
+func (suite *TestHistorySuite) entry(name string, age time.Duration) history.Entry {
+	return history.Entry{
+		Time:       suite.now.Add(-age),
+		Remediator: "CrashLoopBackOffRescheduler",
+		Action:     "delete",
+		Result:     "success",
+		Kind:       "Pod",
+		Namespace:  "default",
+		Name:       name,
+	}
+}
+
+func (suite *TestHistorySuite) newStore(size int, path string) *history.Store {
+	store, err := history.NewStore(size, path)
+	assert.NilError(suite.t, err)
+	suite.T().Cleanup(func() { store.Close() })
+	return store
+}
+
+func names(entries []history.Entry) []string {
+	result := []string{}
+	for _, entry := range entries {
+		result = append(result, entry.Name)
+	}
+	return result
+}
+
+func (suite *TestHistorySuite) lines() int {
+	file, err := os.Open(suite.path)
+	assert.NilError(suite.t, err)
+	defer file.Close()
+	count := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		count++
+	}
+	return count
+}
+
+func (suite *TestHistorySuite) TestRejectsEmptyStore() {
+	_, err := history.NewStore(0, "")
+	assert.ErrorContains(suite.t, err, "positive")
+}
+
+func (suite *TestHistorySuite) TestListsNewestFirst() {
+	store := suite.newStore(10, "")
+	store.Add(suite.entry("a", 2*time.Minute))
+	store.Add(suite.entry("b", time.Minute))
+
+	assert.DeepEqual(suite.t, names(store.List(history.Filter{})), []string{"b", "a"})
+}
+
+func (suite *TestHistorySuite) TestKeepsOnlyTheMostRecentEntries() {
+	store := suite.newStore(2, "")
+	store.Add(suite.entry("a", 0))
+	store.Add(suite.entry("b", 0))
+	store.Add(suite.entry("c", 0))
+
+	assert.DeepEqual(suite.t, names(store.List(history.Filter{})), []string{"c", "b"})
+}
+
+func (suite *TestHistorySuite) TestFilters() {
+	store := suite.newStore(10, "")
+	store.Add(suite.entry("old", time.Hour))
+	other := suite.entry("other-namespace", 0)
+	other.Namespace = "kube-system"
+	store.Add(other)
+	failed := suite.entry("other-remediator", 0)
+	failed.Remediator = "FailedPodRescheduler"
+	store.Add(failed)
+	store.Add(suite.entry("match", 0))
+
+	filter := history.Filter{Namespace: "default", Remediator: "crashloopbackoffrescheduler", Since: suite.now.Add(-time.Minute)}
+	assert.DeepEqual(suite.t, names(store.List(filter)), []string{"match"})
+	filter = history.Filter{Until: suite.now.Add(-time.Minute)}
+	assert.DeepEqual(suite.t, names(store.List(filter)), []string{"old"})
+}
+
+func (suite *TestHistorySuite) TestPersistsAcrossRestarts() {
+	store := suite.newStore(2, suite.path)
+	store.Add(suite.entry("a", 0))
+	store.Add(suite.entry("b", 0))
+	store.Add(suite.entry("c", 0))
+	assert.NilError(suite.t, store.Close())
+
+	restarted := suite.newStore(2, suite.path)
+	assert.DeepEqual(suite.t, names(restarted.List(history.Filter{})), []string{"c", "b"})
+	assert.Equal(suite.t, suite.lines(), 2) // compacted on start
+}
+
+func (suite *TestHistorySuite) TestSkipsBrokenLines() {
+	err := os.WriteFile(suite.path, []byte("{\"name\":\"a\"}\n{\"name\":"), 0o644)
+	assert.NilError(suite.t, err)
+
+	store := suite.newStore(10, suite.path)
+	assert.DeepEqual(suite.t, names(store.List(history.Filter{})), []string{"a"})
+}
+
+func (suite *TestHistorySuite) TestCompactsFile() {
+	store := suite.newStore(2, suite.path)
+	for i := 0; i < 5; i++ {
+		store.Add(suite.entry("a", 0))
+	}
+	assert.Assert(suite.t, suite.lines() <= 4)
+}
+
+func (suite *TestHistorySuite) get(store *history.Store, url string) (int, []history.Entry) {
+	mux := http.NewServeMux()
+	store.RegisterHandler(mux)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+
+	var entries []history.Entry
+	if recorder.Code == http.StatusOK {
+		assert.NilError(suite.t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+	}
+	return recorder.Code, entries
+}
+
+func (suite *TestHistorySuite) TestServesRemediations() {
+	store := suite.newStore(10, "")
+	old := suite.entry("old", 0)
+	old.Time = time.Now().Add(-2 * time.Hour)
+	store.Add(old)
+	recent := suite.entry("recent", 0)
+	recent.Time = time.Now()
+	store.Add(recent)
+
+	status, entries := suite.get(store, "/remediations")
+	assert.Equal(suite.t, status, http.StatusOK)
+	assert.DeepEqual(suite.t, names(entries), []string{"recent", "old"})
+
+	_, entries = suite.get(store, "/remediations?namespace=default&since=1h")
+	assert.DeepEqual(suite.t, names(entries), []string{"recent"})
+
+	_, entries = suite.get(store, "/remediations?until="+time.Now().Add(-time.Hour).Format(time.RFC3339))
+	assert.DeepEqual(suite.t, names(entries), []string{"old"})
+
+	_, entries = suite.get(store, "/remediations?remediator=OldPodDeleter")
+	assert.DeepEqual(suite.t, names(entries), []string{})
+}
+
+func (suite *TestHistorySuite) TestRejectsInvalidTimes() {
+	store := suite.newStore(10, "")
+	status, _ := suite.get(store, "/remediations?since=yesterday")
+	assert.Equal(suite.t, status, http.StatusBadRequest)
+	status, _ = suite.get(store, "/remediations?until=tomorrow")
+	assert.Equal(suite.t, status, http.StatusBadRequest)
+}
+
+func (suite *TestHistorySuite) TestForwardsToLeader() {
+	leader := suite.newStore(10, "")
+	leader.Add(suite.entry("a", 0))
+	leader.ForwardToLeader(func() bool { return true }, nil)
+	mux := http.NewServeMux()
+	leader.RegisterHandler(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	follower := suite.newStore(10, "")
+	follower.Add(suite.entry("b", 0))
+	follower.ForwardToLeader(func() bool { return false }, func() (*url.URL, error) { return url.Parse(server.URL) })
+	status, entries := suite.get(follower, "/remediations?namespace=default")
+	assert.Equal(suite.t, status, http.StatusOK)
+	assert.DeepEqual(suite.t, names(entries), []string{"a"})
+}
+
+func (suite *TestHistorySuite) TestRefusesWithoutReachableLeader() {
+	store := suite.newStore(10, "")
+	store.ForwardToLeader(func() bool { return false }, func() (*url.URL, error) { return nil, errors.New("no leader elected yet") })
+	status, _ := suite.get(store, "/remediations")
+	assert.Equal(suite.t, status, http.StatusServiceUnavailable)
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	store.ForwardToLeader(func() bool { return false }, func() (*url.URL, error) { return url.Parse(server.URL) })
+	status, _ = suite.get(store, "/remediations")
+	assert.Equal(suite.t, status, http.StatusServiceUnavailable)
+}
+
+// while leadership changes the old leader might be asked, which must not pass it on again
+func (suite *TestHistorySuite) TestOnlyForwardsOnce() {
+	follower := suite.newStore(10, "")
+	mux := http.NewServeMux()
+	follower.RegisterHandler(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	follower.ForwardToLeader(func() bool { return false }, func() (*url.URL, error) { return url.Parse(server.URL) })
+
+	status, _ := suite.get(follower, "/remediations")
+	assert.Equal(suite.t, status, http.StatusServiceUnavailable)
+}
+
+func (suite *TestHistorySuite) TestReportsUnreadableFile() {
+	_, err := history.NewStore(10, suite.t.TempDir())
+	assert.ErrorContains(suite.t, err, "is a directory")
+
+	assert.NilError(suite.t, os.WriteFile(suite.path, nil, 0o644))
+	_, err = history.NewStore(10, filepath.Join(suite.path, "history.jsonl"))
+	assert.ErrorContains(suite.t, err, "not a directory")
+}
+
+func (suite *TestHistorySuite) TestReportsUnwritableFile() {
+	_, err := history.NewStore(10, filepath.Join(suite.path, "missing", "history.jsonl"))
+	assert.ErrorContains(suite.t, err, "no such file or directory")
+}
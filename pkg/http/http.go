@@ -2,22 +2,33 @@ package http
 
 import (
 	"context"
+	"fmt"
 	"github.com/aksgithub/kube_remediator/pkg/healthz"
 	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	httpmux "github.com/google/cadvisor/http/mux"
 	"go.uber.org/zap"
 	"net/http"
 	"sync"
 	"time"
 )
 
+// where every replica serves, so they can reach each other
+const Port = 8080
+
 type Server struct {
-	logger *zap.Logger
+	logger   *zap.Logger
+	handlers []func(httpmux.Mux) error
 }
 
 func NewServer(logger *zap.Logger) *Server {
 	return &Server{logger: logger}
 }
 
+// adds handlers of other packages next to /healthz and /metrics, needs to be called before Serve
+func (s *Server) RegisterHandler(register func(httpmux.Mux) error) {
+	s.handlers = append(s.handlers, register)
+}
+
 // allow checking from the outside if the app is still running
 // eventually this should show if the remediators are working
 // maybe later also for /metrics
@@ -30,7 +41,12 @@ func (s *Server) Serve(ctx context.Context, wg *sync.WaitGroup) {
 	mux := http.NewServeMux()
 	healthz.RegisterHandler(mux)
 	metrics.RegisterHandler(mux)
-	srv := &http.Server{Addr: ":8080", Handler: mux}
+	for _, register := range s.handlers {
+		if err := register(mux); err != nil {
+			s.logger.Error("Error registering handler", zap.Error(err))
+		}
+	}
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", Port), Handler: mux}
 
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
@@ -2,7 +2,9 @@ package http_test
 
 import (
 	"context"
+	"errors"
 	remediator_http "github.com/aksgithub/kube_remediator/pkg/http"
+	httpmux "github.com/google/cadvisor/http/mux"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
 	"gotest.tools/assert"
@@ -69,6 +71,35 @@ func (suite *TestHttpServerSuite) TestServer() {
 	wg.Wait()
 }
 
+func (suite *TestHttpServerSuite) TestServerRegisteredHandlers() {
+	ctx, cancel := context.WithCancel(suite.ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	server := remediator_http.NewServer(suite.logger)
+	server.RegisterHandler(func(mux httpmux.Mux) error {
+		mux.HandleFunc("/extra", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("extra"))
+		})
+		return nil
+	})
+	server.RegisterHandler(func(mux httpmux.Mux) error {
+		return errors.New("broken")
+	})
+	go server.Serve(ctx, &wg)
+
+	time.Sleep(100 * time.Millisecond) // wait for http server to get ready
+
+	// a broken handler does not keep the others from being served
+	status, body := suite.httpGet("http://localhost:8080/extra")
+	assert.Equal(suite.t, status, 200)
+	assert.Equal(suite.t, body, "extra")
+	status, _ = suite.httpGet("http://localhost:8080/healthz")
+	assert.Equal(suite.t, status, 200)
+
+	cancel()
+	wg.Wait()
+}
+
 func TestHttpServer(t *testing.T) {
 	suite.Run(t, &TestHttpServerSuite{t: &testing.T{}})
 }
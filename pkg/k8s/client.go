@@ -2,70 +2,296 @@ package k8s
 
 import (
 	"context"
+	"fmt"
 	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	apiv1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	"k8s.io/client-go/rest"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// informers re-deliver all pods this often, so remediators retry pods they could not remediate (e.g. blocked evictions)
+const resyncPeriod = 10 * time.Minute
+
 type ClientInterface interface {
-	GetPods(namespace string, options metav1.ListOptions) (*apiv1.PodList, error)
 	DeletePod(pod *apiv1.Pod) error
-	NewSharedInformerFactory(ns string) (informers.SharedInformerFactory, error)
+	EvictPod(pod *apiv1.Pod) error
+	PatchPod(pod *apiv1.Pod, patch []byte) error
+	GetPersistentVolumeClaims(namespace string, options metav1.ListOptions) (*apiv1.PersistentVolumeClaimList, error)
+	GetPersistentVolume(name string) (*apiv1.PersistentVolume, error)
+	PatchPersistentVolumeClaim(pvc *apiv1.PersistentVolumeClaim, patch []byte) error
+	DeletePersistentVolumeClaim(pvc *apiv1.PersistentVolumeClaim) error
+	GetStatefulSets(namespace string, options metav1.ListOptions) (*appsv1.StatefulSetList, error)
+	PatchOwner(kind string, namespace string, name string, patch []byte) error
+	SharedInformerFactory() informers.SharedInformerFactory
+	MetadataInformerFactory() metadatainformer.SharedInformerFactory
 }
 
 type Client struct {
-	logger    *zap.Logger
-	clientSet *kubernetes.Clientset
+	logger          *zap.Logger
+	clientSet       *kubernetes.Clientset
+	dynamicClient   dynamic.Interface // for custom resources
+	informerFactory informers.SharedInformerFactory
+	// only annotations, labels and ownerReferences, for resources where that is all remediators need
+	metadataInformerFactory metadatainformer.SharedInformerFactory
 }
 
-func (c *Client) GetPods(namespace string, options metav1.ListOptions) (*apiv1.PodList, error) {
+func (c *Client) DeletePod(pod *apiv1.Pod) error {
 	ctx := context.Background()
-	return c.clientSet.CoreV1().Pods(namespace).List(ctx, options)
+	return c.clientSet.CoreV1().Pods(pod.ObjectMeta.Namespace).Delete(ctx, pod.ObjectMeta.Name, metav1.DeleteOptions{})
 }
 
-func (c *Client) DeletePod(pod *apiv1.Pod) error {
+// respects PodDisruptionBudgets, fails with 429 TooManyRequests when the budget does not allow it
+func (c *Client) EvictPod(pod *apiv1.Pod) error {
 	ctx := context.Background()
-	return c.clientSet.CoreV1().Pods(pod.ObjectMeta.Namespace).Delete(ctx, pod.ObjectMeta.Name, metav1.DeleteOptions{})
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.ObjectMeta.Name, Namespace: pod.ObjectMeta.Namespace},
+	}
+	return c.clientSet.PolicyV1().Evictions(pod.ObjectMeta.Namespace).Evict(ctx, eviction)
 }
 
-func (c *Client) NewSharedInformerFactory(ns string) (informers.SharedInformerFactory, error) {
-	factory := informers.NewSharedInformerFactoryWithOptions(c.clientSet, 0, informers.WithNamespace(ns))
-	return factory, nil
+// applies a json merge patch, for example to set annotations
+func (c *Client) PatchPod(pod *apiv1.Pod, patch []byte) error {
+	ctx := context.Background()
+	_, err := c.clientSet.CoreV1().Pods(pod.ObjectMeta.Namespace).Patch(ctx, pod.ObjectMeta.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
 }
 
-func newClientSet() (*kubernetes.Clientset, error) {
+func (c *Client) GetPersistentVolumeClaims(namespace string, options metav1.ListOptions) (*apiv1.PersistentVolumeClaimList, error) {
+	ctx := context.Background()
+	return c.clientSet.CoreV1().PersistentVolumeClaims(namespace).List(ctx, options)
+}
+
+func (c *Client) GetPersistentVolume(name string) (*apiv1.PersistentVolume, error) {
+	ctx := context.Background()
+	return c.clientSet.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+}
+
+// applies a json merge patch, for example to set annotations
+func (c *Client) PatchPersistentVolumeClaim(pvc *apiv1.PersistentVolumeClaim, patch []byte) error {
+	ctx := context.Background()
+	_, err := c.clientSet.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Patch(ctx, pvc.ObjectMeta.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func (c *Client) DeletePersistentVolumeClaim(pvc *apiv1.PersistentVolumeClaim) error {
+	ctx := context.Background()
+	return c.clientSet.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Delete(ctx, pvc.ObjectMeta.Name, metav1.DeleteOptions{})
+}
+
+func (c *Client) GetStatefulSets(namespace string, options metav1.ListOptions) (*appsv1.StatefulSetList, error) {
+	ctx := context.Background()
+	return c.clientSet.AppsV1().StatefulSets(namespace).List(ctx, options)
+}
+
+// applies a json merge patch to a controller of pods, kind is Deployment, StatefulSet, ReplicaSet, DaemonSet, Job or CronJob
+func (c *Client) PatchOwner(kind string, namespace string, name string, patch []byte) error {
+	ctx := context.Background()
 	var err error
-	var config *restclient.Config
+	switch kind {
+	case "Deployment":
+		_, err = c.clientSet.AppsV1().Deployments(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "StatefulSet":
+		_, err = c.clientSet.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "ReplicaSet":
+		_, err = c.clientSet.AppsV1().ReplicaSets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "DaemonSet":
+		_, err = c.clientSet.AppsV1().DaemonSets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "Job":
+		_, err = c.clientSet.BatchV1().Jobs(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "CronJob":
+		_, err = c.clientSet.BatchV1().CronJobs(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	default:
+		err = fmt.Errorf("can not patch %s", kind)
+	}
+	return err
+}
+
+func (c *Client) GetPod(namespace string, name string) (*apiv1.Pod, error) {
+	ctx := context.Background()
+	return c.clientSet.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *Client) GetConfigMap(namespace string, name string) (*apiv1.ConfigMap, error) {
+	ctx := context.Background()
+	return c.clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// applies a json merge patch to the ConfigMap, it is created first when it does not exist yet
+func (c *Client) PatchConfigMap(namespace string, name string, patch []byte) (*apiv1.ConfigMap, error) {
+	ctx := context.Background()
+	configMaps := c.clientSet.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMaps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if !apierrors.IsNotFound(err) {
+		return configMap, err
+	}
+	_, err = configMaps.Create(ctx, &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return configMaps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
+
+// the user a bearer token belongs to, authenticated is false for unknown or expired tokens
+func (c *Client) ReviewToken(token string) (user authenticationv1.UserInfo, authenticated bool, err error) {
+	ctx := context.Background()
+	review, err := c.clientSet.AuthenticationV1().TokenReviews().Create(ctx,
+		&authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, false, err
+	}
+	return review.Status.User, review.Status.Authenticated, nil
+}
+
+// if the user may do what the attributes say, like kubectl auth can-i
+func (c *Client) ReviewAccess(user authenticationv1.UserInfo, attributes authorizationv1.ResourceAttributes) (bool, error) {
+	ctx := context.Background()
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review, err := c.clientSet.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+			User:               user.Username,
+			Groups:             user.Groups,
+			UID:                user.UID,
+			Extra:              extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// shared by all remediators so every resource is only cached once, for all namespaces,
+// informers need to be requested before the factory is started
+func (c *Client) SharedInformerFactory() informers.SharedInformerFactory {
+	return c.informerFactory
+}
+
+// like SharedInformerFactory, for informers that only cache the metadata of objects
+func (c *Client) MetadataInformerFactory() metadatainformer.SharedInformerFactory {
+	return c.metadataInformerFactory
+}
+
+// informer of a custom resource in all namespaces, indexed by namespace
+func (c *Client) NewDynamicInformer(resource schema.GroupVersionResource) cache.SharedIndexInformer {
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	return dynamicinformer.NewFilteredDynamicInformer(c.dynamicClient, resource, "", resyncPeriod, indexers, nil).Informer()
+}
+
+func (c *Client) UpdateStatus(resource schema.GroupVersionResource, object *unstructured.Unstructured) error {
+	ctx := context.Background()
+	_, err := c.dynamicClient.Resource(resource).Namespace(object.GetNamespace()).UpdateStatus(ctx, object, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *Client) NewLeaseLock(name string, namespace string, identity string) resourcelock.Interface {
+	return &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Client:     c.clientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+}
+
+// records events in the background, events are aggregated and rate limited by the broadcaster
+func (c *Client) NewEventRecorder(component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.clientSet.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: component})
+}
+
+// marks the end of recorded events in NewFlushedEventRecorder, never written
+const flushReason = "Flush"
+
+// like NewEventRecorder, for processes that exit right away: flush writes all events recorded so far before it returns,
+// unless ctx is done first. Events are written once, without aggregation and retries.
+func (c *Client) NewFlushedEventRecorder(component string) (record.EventRecorder, func(ctx context.Context)) {
+	broadcaster := record.NewBroadcaster()
+	flushed := make(chan struct{}, 1)
+	// events are handled one at a time in the order they were recorded
+	broadcaster.StartEventWatcher(func(event *apiv1.Event) {
+		if event.Reason == flushReason && event.Source.Component == "" {
+			flushed <- struct{}{}
+			return
+		}
+		if _, err := c.clientSet.CoreV1().Events(event.Namespace).Create(context.TODO(), event, metav1.CreateOptions{}); err != nil {
+			c.logger.Warn("Error recording event", zap.String("reason", event.Reason), zap.String("object", event.InvolvedObject.Name), zap.Error(err))
+		}
+	})
+	flush := func(ctx context.Context) {
+		defer broadcaster.Shutdown()
+		// recorded without a source, so it cannot be mistaken for an event of the component
+		broadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{}).Event(&apiv1.ObjectReference{}, apiv1.EventTypeNormal, flushReason, "")
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			c.logger.Warn("Stopped waiting for events to be recorded", zap.Error(ctx.Err()))
+		}
+	}
+	return broadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: component}), flush
+}
+
+func newConfig() (*restclient.Config, error) {
 	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
 		kubeconfig := os.Getenv("KUBECONFIG")
 		if kubeconfig == "" {
 			kubeconfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
 		}
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	} else {
-		// Reads config when in cluster
-		config, err = rest.InClusterConfig()
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
 	}
+	// Reads config when in cluster
+	return rest.InClusterConfig()
+}
 
+func NewClient(logger *zap.Logger) (*Client, error) {
+	config, err := newConfig()
 	if err != nil {
 		return nil, err
 	}
-
-	return kubernetes.NewForConfig(config)
-}
-
-func NewClient(logger *zap.Logger) (*Client, error) {
-	clientSet, err := newClientSet()
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	metadataClient, err := metadata.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{clientSet: clientSet, logger: logger}, err
+	return &Client{
+		clientSet:               clientSet,
+		dynamicClient:           dynamicClient,
+		logger:                  logger,
+		informerFactory:         informers.NewSharedInformerFactoryWithOptions(clientSet, resyncPeriod),
+		metadataInformerFactory: metadatainformer.NewSharedInformerFactory(metadataClient, resyncPeriod),
+	}, nil
 }
@@ -6,9 +6,11 @@ package mock_k8s
 
 import (
 	gomock "github.com/golang/mock/gomock"
+	v10 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	informers "k8s.io/client-go/informers"
+	metadatainformer "k8s.io/client-go/metadata/metadatainformer"
 	reflect "reflect"
 )
 
@@ -35,46 +37,159 @@ func (m *MockClientInterface) EXPECT() *MockClientInterfaceMockRecorder {
 	return m.recorder
 }
 
-// GetPods mocks base method
-func (m *MockClientInterface) GetPods(namespace string, options metav1.ListOptions) (*v1.PodList, error) {
+// DeletePod mocks base method
+func (m *MockClientInterface) DeletePod(pod *v1.Pod) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePod", pod)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePod indicates an expected call of DeletePod
+func (mr *MockClientInterfaceMockRecorder) DeletePod(pod interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePod", reflect.TypeOf((*MockClientInterface)(nil).DeletePod), pod)
+}
+
+// EvictPod mocks base method
+func (m *MockClientInterface) EvictPod(pod *v1.Pod) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EvictPod", pod)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EvictPod indicates an expected call of EvictPod
+func (mr *MockClientInterfaceMockRecorder) EvictPod(pod interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EvictPod", reflect.TypeOf((*MockClientInterface)(nil).EvictPod), pod)
+}
+
+// PatchPod mocks base method
+func (m *MockClientInterface) PatchPod(pod *v1.Pod, patch []byte) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPods", namespace)
-	ret0, _ := ret[0].(*v1.PodList)
+	ret := m.ctrl.Call(m, "PatchPod", pod, patch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PatchPod indicates an expected call of PatchPod
+func (mr *MockClientInterfaceMockRecorder) PatchPod(pod, patch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchPod", reflect.TypeOf((*MockClientInterface)(nil).PatchPod), pod, patch)
+}
+
+// GetPersistentVolumeClaims mocks base method
+func (m *MockClientInterface) GetPersistentVolumeClaims(namespace string, options metav1.ListOptions) (*v1.PersistentVolumeClaimList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPersistentVolumeClaims", namespace, options)
+	ret0, _ := ret[0].(*v1.PersistentVolumeClaimList)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetPods indicates an expected call of GetPods
-func (mr *MockClientInterfaceMockRecorder) GetPods(namespace interface{}) *gomock.Call {
+// GetPersistentVolumeClaims indicates an expected call of GetPersistentVolumeClaims
+func (mr *MockClientInterfaceMockRecorder) GetPersistentVolumeClaims(namespace, options interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPods", reflect.TypeOf((*MockClientInterface)(nil).GetPods), namespace)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPersistentVolumeClaims", reflect.TypeOf((*MockClientInterface)(nil).GetPersistentVolumeClaims), namespace, options)
 }
 
-// DeletePod mocks base method
-func (m *MockClientInterface) DeletePod(pod *v1.Pod) error {
+// GetPersistentVolume mocks base method
+func (m *MockClientInterface) GetPersistentVolume(name string) (*v1.PersistentVolume, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeletePod", pod)
+	ret := m.ctrl.Call(m, "GetPersistentVolume", name)
+	ret0, _ := ret[0].(*v1.PersistentVolume)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPersistentVolume indicates an expected call of GetPersistentVolume
+func (mr *MockClientInterfaceMockRecorder) GetPersistentVolume(name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPersistentVolume", reflect.TypeOf((*MockClientInterface)(nil).GetPersistentVolume), name)
+}
+
+// PatchPersistentVolumeClaim mocks base method
+func (m *MockClientInterface) PatchPersistentVolumeClaim(pvc *v1.PersistentVolumeClaim, patch []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PatchPersistentVolumeClaim", pvc, patch)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DeletePod indicates an expected call of DeletePod
-func (mr *MockClientInterfaceMockRecorder) DeletePod(pod interface{}) *gomock.Call {
+// PatchPersistentVolumeClaim indicates an expected call of PatchPersistentVolumeClaim
+func (mr *MockClientInterfaceMockRecorder) PatchPersistentVolumeClaim(pvc, patch interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePod", reflect.TypeOf((*MockClientInterface)(nil).DeletePod), pod)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchPersistentVolumeClaim", reflect.TypeOf((*MockClientInterface)(nil).PatchPersistentVolumeClaim), pvc, patch)
 }
 
-// NewSharedInformerFactory mocks base method
-func (m *MockClientInterface) NewSharedInformerFactory(ns string) (informers.SharedInformerFactory, error) {
+// PatchOwner mocks base method
+func (m *MockClientInterface) PatchOwner(kind, namespace, name string, patch []byte) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NewSharedInformerFactory", ns)
-	ret0, _ := ret[0].(informers.SharedInformerFactory)
+	ret := m.ctrl.Call(m, "PatchOwner", kind, namespace, name, patch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PatchOwner indicates an expected call of PatchOwner
+func (mr *MockClientInterfaceMockRecorder) PatchOwner(kind, namespace, name, patch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchOwner", reflect.TypeOf((*MockClientInterface)(nil).PatchOwner), kind, namespace, name, patch)
+}
+
+// DeletePersistentVolumeClaim mocks base method
+func (m *MockClientInterface) DeletePersistentVolumeClaim(pvc *v1.PersistentVolumeClaim) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePersistentVolumeClaim", pvc)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePersistentVolumeClaim indicates an expected call of DeletePersistentVolumeClaim
+func (mr *MockClientInterfaceMockRecorder) DeletePersistentVolumeClaim(pvc interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePersistentVolumeClaim", reflect.TypeOf((*MockClientInterface)(nil).DeletePersistentVolumeClaim), pvc)
+}
+
+// GetStatefulSets mocks base method
+func (m *MockClientInterface) GetStatefulSets(namespace string, options metav1.ListOptions) (*v10.StatefulSetList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetStatefulSets", namespace, options)
+	ret0, _ := ret[0].(*v10.StatefulSetList)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// NewSharedInformerFactory indicates an expected call of NewSharedInformerFactory
-func (mr *MockClientInterfaceMockRecorder) NewSharedInformerFactory(ns interface{}) *gomock.Call {
+// GetStatefulSets indicates an expected call of GetStatefulSets
+func (mr *MockClientInterfaceMockRecorder) GetStatefulSets(namespace, options interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStatefulSets", reflect.TypeOf((*MockClientInterface)(nil).GetStatefulSets), namespace, options)
+}
+
+// SharedInformerFactory mocks base method
+func (m *MockClientInterface) SharedInformerFactory() informers.SharedInformerFactory {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SharedInformerFactory")
+	ret0, _ := ret[0].(informers.SharedInformerFactory)
+	return ret0
+}
+
+// SharedInformerFactory indicates an expected call of SharedInformerFactory
+func (mr *MockClientInterfaceMockRecorder) SharedInformerFactory() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SharedInformerFactory", reflect.TypeOf((*MockClientInterface)(nil).SharedInformerFactory))
+}
+
+// MetadataInformerFactory mocks base method
+func (m *MockClientInterface) MetadataInformerFactory() metadatainformer.SharedInformerFactory {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MetadataInformerFactory")
+	ret0, _ := ret[0].(metadatainformer.SharedInformerFactory)
+	return ret0
+}
+
+// MetadataInformerFactory indicates an expected call of MetadataInformerFactory
+func (mr *MockClientInterfaceMockRecorder) MetadataInformerFactory() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewSharedInformerFactory", reflect.TypeOf((*MockClientInterface)(nil).NewSharedInformerFactory), ns)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MetadataInformerFactory", reflect.TypeOf((*MockClientInterface)(nil).MetadataInformerFactory))
 }
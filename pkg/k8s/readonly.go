@@ -0,0 +1,41 @@
+package k8s
+
+import (
+	"errors"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+var ErrReadOnly = errors.New("read-only client, nothing is changed")
+
+// wraps a client so every change fails, for looking at what remediators would do without any chance of doing it
+type ReadOnlyClient struct {
+	ClientInterface
+}
+
+func ReadOnly(client ClientInterface) ClientInterface {
+	return &ReadOnlyClient{ClientInterface: client}
+}
+
+func (c *ReadOnlyClient) DeletePod(*apiv1.Pod) error {
+	return ErrReadOnly
+}
+
+func (c *ReadOnlyClient) EvictPod(*apiv1.Pod) error {
+	return ErrReadOnly
+}
+
+func (c *ReadOnlyClient) PatchPod(*apiv1.Pod, []byte) error {
+	return ErrReadOnly
+}
+
+func (c *ReadOnlyClient) PatchPersistentVolumeClaim(*apiv1.PersistentVolumeClaim, []byte) error {
+	return ErrReadOnly
+}
+
+func (c *ReadOnlyClient) DeletePersistentVolumeClaim(*apiv1.PersistentVolumeClaim) error {
+	return ErrReadOnly
+}
+
+func (c *ReadOnlyClient) PatchOwner(string, string, string, []byte) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,114 @@
+package leader
+
+import (
+	"context"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"go.uber.org/zap"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+type Elector struct {
+	logger  *zap.Logger
+	config  policy.LeaderElection
+	lock    resourcelock.Interface
+	running sync.Mutex // a new term only starts after the previous one stopped, so remediators never overlap
+	leading atomic.Bool
+	leader  atomic.Value // identity of the current leader as last observed
+}
+
+func NewElector(logger *zap.Logger, config policy.LeaderElection, lock resourcelock.Interface) *Elector {
+	return &Elector{logger: logger, config: config, lock: lock}
+}
+
+// Run campaigns for leadership until ctx is done and calls lead every time we become leader,
+// the context passed to lead is cancelled when leadership is lost and lead must return then.
+func (e *Elector) Run(ctx context.Context, wg *sync.WaitGroup, lead func(context.Context)) {
+	defer wg.Done()
+	defer metrics.SetLeader(false)
+
+	e.logger.Info("Starting", zap.String("identity", e.lock.Identity()), zap.String("lease", e.lock.Describe()))
+	for ctx.Err() == nil {
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            e.lock,
+			Name:            e.config.LeaseName,
+			LeaseDuration:   e.config.LeaseDuration,
+			RenewDeadline:   e.config.RenewDeadline,
+			RetryPeriod:     e.config.RetryPeriod,
+			ReleaseOnCancel: true, // lets followers take over right away on shutdown
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					e.running.Lock()
+					defer e.running.Unlock()
+					if ctx.Err() != nil {
+						return // untested section, lost leadership while the previous term was stopping
+					}
+					e.logger.Info("Started leading")
+					metrics.SetLeader(true)
+					e.leader.Store(e.lock.Identity())
+					e.leading.Store(true)
+					defer e.leading.Store(false)
+					lead(ctx)
+				},
+				OnStoppedLeading: func() {
+					e.logger.Info("Stopped leading")
+					metrics.SetLeader(false)
+				},
+				OnNewLeader: func(identity string) {
+					e.logger.Info("Leader elected", zap.String("leader", identity))
+					e.leader.Store(identity)
+				},
+			},
+		})
+		if err != nil {
+			e.logger.Panic("Error configuring leader election", zap.Error(err)) // untested section
+		}
+		elector.Run(ctx) // returns when leadership is lost or ctx is done
+	}
+
+	// wait for the last term to stop before reporting that we are done
+	e.running.Lock()
+	defer e.running.Unlock()
+	e.logger.Info("Stopping", zap.String("reason", "Signal"))
+}
+
+// if this replica is leading right now, so handlers that only have state on the leader can refuse on followers
+func (e *Elector) IsLeading() bool {
+	return e.leading.Load()
+}
+
+// identity of the current leader, which might be this replica, "" until one was elected
+func (e *Elector) Leader() string {
+	identity, _ := e.leader.Load().(string)
+	return identity
+}
+
+// unique per pod since the hostname of a pod is its name
+func Identity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "kube-remediator" // untested section
+	}
+	return hostname
+}
+
+// namespace we run in, so the lease does not need to be configured per deployment
+func Namespace(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	if namespace, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		return strings.TrimSpace(string(namespace)) // untested section
+	}
+	return "default"
+}
@@ -0,0 +1,123 @@
+package leader_test
+
+import (
+	"context"
+	"github.com/aksgithub/kube_remediator/pkg/leader"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TestElectorSuite struct {
+	suite.Suite
+	logger *zap.Logger
+	client *fake.Clientset
+	config policy.LeaderElection
+	t      *testing.T
+}
+
+func TestSuiteElector(t *testing.T) {
+	suite.Run(t, &TestElectorSuite{t: t})
+}
+
+func (suite *TestElectorSuite) SetupTest() {
+	suite.logger, _ = zap.NewDevelopment()
+	suite.client = fake.NewSimpleClientset()
+	suite.config = policy.LeaderElection{
+		Enabled:       true,
+		LeaseName:     "kube-remediator",
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: 1 * time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+}
+
+func (suite *TestElectorSuite) newElector(identity string) *leader.Elector {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: suite.config.LeaseName, Namespace: "default"},
+		Client:     suite.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	return leader.NewElector(suite.logger, suite.config, lock)
+}
+
+func (suite *TestElectorSuite) TestRunsWhenLeading() {
+	ctx, cancel := context.WithCancel(context.Background())
+	led := make(chan struct{})
+	elector := suite.newElector("a")
+	assert.Assert(suite.t, !elector.IsLeading())
+	assert.Equal(suite.t, elector.Leader(), "")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go elector.Run(ctx, &wg, func(ctx context.Context) {
+		close(led)
+		<-ctx.Done()
+	})
+
+	select {
+	case <-led:
+	case <-time.After(5 * time.Second):
+		suite.t.Fatal("never became leader")
+	}
+	assert.Assert(suite.t, elector.IsLeading())
+	assert.Equal(suite.t, elector.Leader(), "a")
+	cancel()
+	wg.Wait()
+	assert.Assert(suite.t, !elector.IsLeading())
+}
+
+func (suite *TestElectorSuite) TestOnlyOneLeader() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var leaders sync.Map
+	var wg sync.WaitGroup
+
+	for _, identity := range []string{"a", "b"} {
+		identity := identity
+		wg.Add(1)
+		go suite.newElector(identity).Run(ctx, &wg, func(ctx context.Context) {
+			leaders.Store(identity, true)
+			<-ctx.Done()
+		})
+	}
+
+	time.Sleep(1 * time.Second)
+	count := 0
+	leaders.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	assert.Equal(suite.t, count, 1)
+
+	cancel()
+	wg.Wait()
+}
+
+func (suite *TestElectorSuite) TestNamespacePrefersConfig() {
+	assert.Equal(suite.t, leader.Namespace("foo"), "foo")
+}
+
+func (suite *TestElectorSuite) TestNamespaceUsesEnvVar() {
+	os.Setenv("POD_NAMESPACE", "bar")
+	defer os.Unsetenv("POD_NAMESPACE")
+	assert.Equal(suite.t, leader.Namespace(""), "bar")
+}
+
+func (suite *TestElectorSuite) TestNamespaceDefaultsOutsideOfPods() {
+	os.Unsetenv("POD_NAMESPACE")
+	assert.Equal(suite.t, leader.Namespace(""), "default")
+}
+
+func (suite *TestElectorSuite) TestIdentityIsHostname() {
+	hostname, err := os.Hostname()
+	assert.NilError(suite.t, err)
+	assert.Equal(suite.t, leader.Identity(), hostname)
+}
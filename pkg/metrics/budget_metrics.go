@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var budgetExhausted = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "remediator_budget_exhausted",
+		Help: "Total number of actions skipped because a deletion budget was exhausted",
+	},
+	[]string{"remediator", "scope"},
+)
+
+var budgetRemaining = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "remediator_budget_remaining",
+		Help: "Actions left in a deletion budget",
+	},
+	[]string{"scope"},
+)
+
+func init() {
+	prometheus.MustRegister(budgetExhausted, budgetRemaining)
+}
+
+func UpdateBudgetExhaustedCount(remediator string, scope string) {
+	budgetExhausted.With(prometheus.Labels{"remediator": remediator, "scope": scope}).Inc()
+}
+
+func SetBudgetRemaining(scope string, remaining float64) {
+	budgetRemaining.With(prometheus.Labels{"scope": scope}).Set(remaining)
+}
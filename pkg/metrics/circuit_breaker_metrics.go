@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var circuitBreakerOpen = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "remediator_circuit_breaker_open",
+		Help: "1 for scopes where a remediator paused because too many candidates piled up",
+	},
+	[]string{"remediator", "scope"},
+)
+
+func init() {
+	prometheus.MustRegister(circuitBreakerOpen)
+}
+
+func SetCircuitBreakerOpen(remediator string, scope string) {
+	circuitBreakerOpen.With(prometheus.Labels{"remediator": remediator, "scope": scope}).Set(1)
+}
+
+// closed scopes are removed, so images and nodes of past storms do not pile up
+func DeleteCircuitBreakerOpen(remediator string, scope string) {
+	circuitBreakerOpen.Delete(prometheus.Labels{"remediator": remediator, "scope": scope})
+}
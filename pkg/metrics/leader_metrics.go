@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var leader = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "remediator_leader",
+		Help: "1 when this replica is the leader and runs remediators, 0 otherwise",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(leader)
+}
+
+func SetLeader(isLeader bool) {
+	if isLeader {
+		leader.Set(1)
+	} else {
+		leader.Set(0)
+	}
+}
@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var notifications = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "remediator_notifications",
+		Help: "Total number of notifications sent to routes by result, success or error",
+	},
+	[]string{"route", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(notifications)
+}
+
+func UpdateNotificationCount(route string, result string) {
+	notifications.With(prometheus.Labels{"route": route, "result": result}).Inc()
+}
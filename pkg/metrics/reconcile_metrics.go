@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var reconciles = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "remediator_reconciles",
+		Help: "Total number of reconciles of remediators by result, success or error",
+	},
+	[]string{"remediator", "result"},
+)
+
+var lastSuccessfulReconcile = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "remediator_last_successful_reconcile_timestamp_seconds",
+		Help: "Unix time of the last reconcile of remediators that succeeded",
+	},
+	[]string{"remediator"},
+)
+
+func init() {
+	prometheus.MustRegister(reconciles, lastSuccessfulReconcile)
+}
+
+func UpdateReconcileCount(remediator string, result string) {
+	reconciles.With(prometheus.Labels{"remediator": remediator, "result": result}).Inc()
+}
+
+func SetLastSuccessfulReconcile(remediator string, at time.Time) {
+	lastSuccessfulReconcile.With(prometheus.Labels{"remediator": remediator}).Set(float64(at.Unix()))
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ResultSuccess  = "success"
+	ResultError    = "error"
+	ResultDryRun   = "dry_run"
+	ResultBlocked  = "blocked"  // refused by the api, for example an eviction blocked by a PodDisruptionBudget
+	ResultDeferred = "deferred" // outside of the remediator's windows, retried later
+	ResultPaused   = "paused"   // held back by an open circuit breaker
+	ResultPending  = "pending"  // waiting for a human to approve it
+	ResultRejected = "rejected" // a human rejected it
+)
+
+// shared by all remediators, so it is registered once instead of per remediator
+var podActions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "remediator_pod_actions",
+		Help: "Total number of actions taken on Pods, dry_run results were only logged",
+	},
+	[]string{"remediator", "action", "result"},
+)
+
+var remediationExhausted = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "remediator_remediation_exhausted",
+		Help: "Total number of owners marked as exhausted because their pods kept needing remediation",
+	},
+	[]string{"remediator"},
+)
+
+func init() {
+	prometheus.MustRegister(podActions, remediationExhausted)
+}
+
+func UpdatePodActionCount(remediator string, action string, result string) {
+	podActions.With(prometheus.Labels{"remediator": remediator, "action": action, "result": result}).Inc()
+}
+
+func UpdateRemediationExhaustedCount(remediator string) {
+	remediationExhausted.With(prometheus.Labels{"remediator": remediator}).Inc()
+}
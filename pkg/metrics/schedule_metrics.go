@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var nextRun = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "remediator_next_run_timestamp_seconds",
+		Help: "Unix time of the next scheduled run of periodic remediators",
+	},
+	[]string{"remediator"},
+)
+
+func init() {
+	prometheus.MustRegister(nextRun)
+}
+
+func SetNextRun(remediator string, next time.Time) {
+	nextRun.With(prometheus.Labels{"remediator": remediator}).Set(float64(next.Unix()))
+}
+
+// for remediators that are no longer running
+func DeleteNextRun(remediator string) {
+	nextRun.Delete(prometheus.Labels{"remediator": remediator})
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var windowOpen = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "remediator_window_open",
+		Help: "1 when the remediator may act according to its windows, 0 while actions are deferred",
+	},
+	[]string{"remediator"},
+)
+
+func init() {
+	prometheus.MustRegister(windowOpen)
+}
+
+func SetWindowOpen(remediator string, open bool) {
+	if open {
+		windowOpen.With(prometheus.Labels{"remediator": remediator}).Set(1)
+	} else {
+		windowOpen.With(prometheus.Labels{"remediator": remediator}).Set(0)
+	}
+}
+
+// for remediators that are no longer running
+func DeleteWindowOpen(remediator string) {
+	windowOpen.Delete(prometheus.Labels{"remediator": remediator})
+}
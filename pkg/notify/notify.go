@@ -0,0 +1,267 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/history"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// how long sending a notification may take
+var Timeout = 10 * time.Second
+
+// remediations listed in a Slack message, the summary counts all of them
+const maxListed = 20
+
+// a remediation as routes get it
+type Remediation struct {
+	history.Entry
+	Team string `json:"team,omitempty"` // from the team label of the object or its controlling owners
+}
+
+// what a route gets per remediator and batch, also the data of webhook templates
+type Batch struct {
+	Remediator   string        `json:"remediator"`
+	Route        string        `json:"route"`
+	Summary      string        `json:"summary"` // like "CompletedPodDeleter: 200 delete"
+	Remediations []Remediation `json:"remediations"`
+}
+
+// Batches the remediations of every remediator and sends them to the routes they match,
+// so a run that deletes 200 pods sends one message per route instead of 200.
+// A nil Notifier ignores everything.
+type Notifier struct {
+	logger        *zap.Logger
+	teamLabel     string
+	batchInterval time.Duration
+	routes        []route
+	client        *http.Client
+
+	lock    sync.Mutex
+	pending map[string][]Remediation // by remediator
+}
+
+type route struct {
+	policy.NotificationRoute
+	url      string             // with environment variables expanded
+	template *template.Template // nil sends the batch as json
+}
+
+// nil when there are no routes
+func NewNotifier(logger *zap.Logger, config policy.Notifications) (*Notifier, error) {
+	if len(config.Routes) == 0 {
+		return nil, nil
+	}
+	n := &Notifier{
+		logger:        logger,
+		teamLabel:     config.TeamLabel,
+		batchInterval: config.BatchInterval,
+		client:        &http.Client{Timeout: Timeout},
+		pending:       map[string][]Remediation{},
+	}
+	for _, config := range config.Routes {
+		r := route{NotificationRoute: config, url: os.ExpandEnv(config.Webhook + config.Slack)}
+		if config.Template != "" {
+			var err error
+			r.template, err = template.New(config.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(config.Template)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: invalid template: %w", config.Name, err)
+			}
+		}
+		n.routes = append(n.routes, r)
+	}
+	return n, nil
+}
+
+// label that says which team owns an object
+func (n *Notifier) TeamLabel() string {
+	if n == nil {
+		return ""
+	}
+	return n.teamLabel
+}
+
+// remembers the remediation until the batch of its remediator is sent
+func (n *Notifier) Add(remediation Remediation) {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.pending[remediation.Remediator] = append(n.pending[remediation.Remediator], remediation)
+}
+
+// sends the batch of the remediator to every route that matches some of it
+func (n *Notifier) Flush(remediator string) {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	remediations := n.pending[remediator]
+	delete(n.pending, remediator)
+	n.lock.Unlock()
+
+	if len(remediations) == 0 {
+		return
+	}
+	for _, route := range n.routes {
+		var matching []Remediation
+		for _, remediation := range remediations {
+			if route.matches(remediation) {
+				matching = append(matching, remediation)
+			}
+		}
+		if len(matching) > 0 {
+			n.send(route, Batch{Remediator: remediator, Route: route.Name, Summary: summary(remediator, matching), Remediations: matching})
+		}
+	}
+}
+
+// sends batches every batch interval, for remediators that follow pod updates instead of running periodically,
+// and what is left when ctx is done
+func (n *Notifier) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(n.batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.flushAll()
+		case <-ctx.Done():
+			n.flushAll()
+			return
+		}
+	}
+}
+
+func (n *Notifier) flushAll() {
+	n.lock.Lock()
+	var remediators []string
+	for remediator := range n.pending {
+		remediators = append(remediators, remediator)
+	}
+	n.lock.Unlock()
+
+	sort.Strings(remediators)
+	for _, remediator := range remediators {
+		n.Flush(remediator)
+	}
+}
+
+func (n *Notifier) send(route route, batch Batch) {
+	var payload bytes.Buffer
+	var err error
+	switch {
+	case route.Slack != "":
+		err = json.NewEncoder(&payload).Encode(map[string]string{"text": slackText(batch)})
+	case route.template != nil:
+		err = route.template.Execute(&payload, batch)
+	default:
+		err = json.NewEncoder(&payload).Encode(batch)
+	}
+	if err == nil {
+		err = n.post(route.url, payload.Bytes())
+	}
+
+	logInfo := []zap.Field{zap.String("route", route.Name), zap.String("remediator", batch.Remediator), zap.Int("remediations", len(batch.Remediations))}
+	if err != nil {
+		n.logger.Warn("Error sending notification", append(logInfo, zap.Error(err))...)
+		metrics.UpdateNotificationCount(route.Name, metrics.ResultError)
+		return
+	}
+	n.logger.Debug("Sent notification", logInfo...)
+	metrics.UpdateNotificationCount(route.Name, metrics.ResultSuccess)
+}
+
+func (n *Notifier) post(url string, payload []byte) error {
+	response, err := n.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func (r route) matches(remediation Remediation) bool {
+	if len(r.Teams) > 0 && !contains(r.Teams, remediation.Team) {
+		return false
+	}
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range r.Namespaces {
+		if matched, _ := path.Match(pattern, remediation.Namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// counts by action and result, like "CompletedPodDeleter: 198 delete, 2 delete error"
+func summary(remediator string, remediations []Remediation) string {
+	counts := map[string]int{}
+	for _, remediation := range remediations {
+		key := remediation.Action
+		if remediation.Result != metrics.ResultSuccess {
+			key += " " + remediation.Result
+		}
+		counts[key]++
+	}
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[key], key))
+	}
+	return remediator + ": " + strings.Join(parts, ", ")
+}
+
+func slackText(batch Batch) string {
+	lines := []string{"*" + batch.Summary + "*"}
+	for i, remediation := range batch.Remediations {
+		if i == maxListed {
+			lines = append(lines, fmt.Sprintf("… and %d more", len(batch.Remediations)-maxListed))
+			break
+		}
+		line := fmt.Sprintf("• %s %s `%s/%s`", remediation.Action, remediation.Kind, remediation.Namespace, remediation.Name)
+		if remediation.Result != metrics.ResultSuccess {
+			line += " (" + remediation.Result + ")"
+		}
+		lines = append(lines, line+": "+remediation.Reason)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// for templates, like {{ json .Remediations }}
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
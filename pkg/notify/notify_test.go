@@ -0,0 +1,255 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/history"
+	"github.com/aksgithub/kube_remediator/pkg/notify"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TestNotifySuite struct {
+	suite.Suite
+	logger   *zap.Logger
+	server   *httptest.Server
+	lock     sync.Mutex
+	requests map[string][]string // bodies by path
+	t        *testing.T
+}
+
+func TestSuiteNotify(t *testing.T) {
+	suite.Run(t, &TestNotifySuite{t: t})
+}
+
+func (suite *TestNotifySuite) SetupTest() {
+	suite.logger, _ = zap.NewDevelopment()
+	suite.requests = map[string][]string{}
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		suite.lock.Lock()
+		defer suite.lock.Unlock()
+		suite.requests[r.URL.Path] = append(suite.requests[r.URL.Path], string(body))
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	suite.T().Cleanup(suite.server.Close)
+}
+
+func (suite *TestNotifySuite) newNotifier(routes ...policy.NotificationRoute) *notify.Notifier {
+	notifier, err := notify.NewNotifier(suite.logger, policy.Notifications{TeamLabel: "team", BatchInterval: time.Hour, Routes: routes})
+	assert.NilError(suite.t, err)
+	return notifier
+}
+
+func (suite *TestNotifySuite) received(path string) []string {
+	suite.lock.Lock()
+	defer suite.lock.Unlock()
+	return suite.requests[path]
+}
+
+func remediation(name string, namespace string, team string) notify.Remediation {
+	return notify.Remediation{
+		Entry: history.Entry{
+			Remediator: "CompletedPodDeleter",
+			Action:     "delete",
+			Result:     "success",
+			Reason:     "Completed",
+			Kind:       "Pod",
+			Namespace:  namespace,
+			Name:       name,
+		},
+		Team: team,
+	}
+}
+
+func (suite *TestNotifySuite) TestIsNilWithoutRoutes() {
+	notifier := suite.newNotifier()
+	assert.Assert(suite.t, notifier == nil)
+	notifier.Add(remediation("foo", "default", ""))
+	notifier.Flush("CompletedPodDeleter")
+	assert.Equal(suite.t, notifier.TeamLabel(), "")
+}
+
+func (suite *TestNotifySuite) TestBatchesRemediations() {
+	notifier := suite.newNotifier(policy.NotificationRoute{Name: "all", Webhook: suite.server.URL + "/all"})
+	for i := 0; i < 200; i++ {
+		notifier.Add(remediation(fmt.Sprintf("pod-%d", i), "default", ""))
+	}
+	notifier.Flush("CompletedPodDeleter")
+	notifier.Flush("CompletedPodDeleter") // nothing left to send
+
+	received := suite.received("/all")
+	assert.Equal(suite.t, len(received), 1)
+	var batch notify.Batch
+	assert.NilError(suite.t, json.Unmarshal([]byte(received[0]), &batch))
+	assert.Equal(suite.t, batch.Route, "all")
+	assert.Equal(suite.t, batch.Summary, "CompletedPodDeleter: 200 delete")
+	assert.Equal(suite.t, len(batch.Remediations), 200)
+	assert.Equal(suite.t, batch.Remediations[0].Name, "pod-0")
+}
+
+func (suite *TestNotifySuite) TestOnlyFlushesTheRemediator() {
+	notifier := suite.newNotifier(policy.NotificationRoute{Name: "all", Webhook: suite.server.URL + "/all"})
+	other := remediation("foo", "default", "")
+	other.Remediator = "OldPodDeleter"
+	notifier.Add(other)
+	notifier.Flush("CompletedPodDeleter")
+
+	assert.Equal(suite.t, len(suite.received("/all")), 0)
+}
+
+func (suite *TestNotifySuite) TestRoutesByTeamAndNamespace() {
+	notifier := suite.newNotifier(
+		policy.NotificationRoute{Name: "payments", Teams: []string{"payments"}, Webhook: suite.server.URL + "/payments"},
+		policy.NotificationRoute{Name: "prod", Namespaces: []string{"prod-*"}, Webhook: suite.server.URL + "/prod"},
+		policy.NotificationRoute{Name: "nobody", Teams: []string{"search"}, Webhook: suite.server.URL + "/nobody"},
+	)
+	notifier.Add(remediation("checkout", "prod-payments", "payments"))
+	notifier.Add(remediation("billing", "staging", "payments"))
+	notifier.Add(remediation("web", "prod-web", ""))
+	notifier.Flush("CompletedPodDeleter")
+
+	names := func(path string) []string {
+		var batch notify.Batch
+		assert.NilError(suite.t, json.Unmarshal([]byte(suite.received(path)[0]), &batch))
+		var names []string
+		for _, remediation := range batch.Remediations {
+			names = append(names, remediation.Name)
+		}
+		return names
+	}
+	assert.DeepEqual(suite.t, names("/payments"), []string{"checkout", "billing"})
+	assert.DeepEqual(suite.t, names("/prod"), []string{"checkout", "web"})
+	assert.Equal(suite.t, len(suite.received("/nobody")), 0)
+}
+
+func (suite *TestNotifySuite) TestSendsSlackMessages() {
+	notifier := suite.newNotifier(policy.NotificationRoute{Name: "slack", Slack: suite.server.URL + "/slack"})
+	failed := remediation("bar", "default", "")
+	failed.Result = "error"
+	notifier.Add(remediation("foo", "default", ""))
+	notifier.Add(failed)
+	notifier.Flush("CompletedPodDeleter")
+
+	var message struct{ Text string }
+	assert.NilError(suite.t, json.Unmarshal([]byte(suite.received("/slack")[0]), &message))
+	assert.Equal(suite.t, message.Text, "*CompletedPodDeleter: 1 delete, 1 delete error*\n"+
+		"• delete Pod `default/foo`: Completed\n"+
+		"• delete Pod `default/bar` (error): Completed")
+}
+
+func (suite *TestNotifySuite) TestListsFewRemediationsInSlack() {
+	notifier := suite.newNotifier(policy.NotificationRoute{Name: "slack", Slack: suite.server.URL + "/slack"})
+	for i := 0; i < 25; i++ {
+		notifier.Add(remediation(fmt.Sprintf("pod-%d", i), "default", ""))
+	}
+	notifier.Flush("CompletedPodDeleter")
+
+	var message struct{ Text string }
+	assert.NilError(suite.t, json.Unmarshal([]byte(suite.received("/slack")[0]), &message))
+	lines := strings.Split(message.Text, "\n")
+	assert.Equal(suite.t, len(lines), 22)
+	assert.Equal(suite.t, lines[21], "… and 5 more")
+}
+
+func (suite *TestNotifySuite) TestRendersTemplates() {
+	notifier := suite.newNotifier(policy.NotificationRoute{
+		Name:     "template",
+		Webhook:  suite.server.URL + "/template",
+		Template: `{"title": {{ json .Summary }}, "count": {{ len .Remediations }}, "first": {{ json (index .Remediations 0).Name }}}`,
+	})
+	notifier.Add(remediation("foo", "default", ""))
+	notifier.Flush("CompletedPodDeleter")
+
+	assert.DeepEqual(suite.t, suite.received("/template"), []string{`{"title": "CompletedPodDeleter: 1 delete", "count": 1, "first": "foo"}`})
+}
+
+func (suite *TestNotifySuite) TestFailsWithInvalidTemplate() {
+	_, err := notify.NewNotifier(suite.logger, policy.Notifications{Routes: []policy.NotificationRoute{
+		{Name: "template", Webhook: suite.server.URL, Template: "{{ .Summary"},
+	}})
+	assert.ErrorContains(suite.t, err, "route template: invalid template")
+}
+
+func (suite *TestNotifySuite) TestExpandsEnvironmentVariablesInUrls() {
+	suite.t.Setenv("NOTIFY_TEST_URL", suite.server.URL)
+	notifier := suite.newNotifier(policy.NotificationRoute{Name: "slack", Slack: "$NOTIFY_TEST_URL/slack"})
+	notifier.Add(remediation("foo", "default", ""))
+	notifier.Flush("CompletedPodDeleter")
+
+	assert.Equal(suite.t, len(suite.received("/slack")), 1)
+}
+
+func (suite *TestNotifySuite) TestKeepsSendingToOtherRoutesWhenOneFails() {
+	notifier := suite.newNotifier(
+		policy.NotificationRoute{Name: "broken", Webhook: suite.server.URL + "/broken"},
+		policy.NotificationRoute{Name: "all", Webhook: suite.server.URL + "/all"},
+	)
+	notifier.Add(remediation("foo", "default", ""))
+	notifier.Flush("CompletedPodDeleter")
+
+	assert.Equal(suite.t, len(suite.received("/broken")), 1)
+	assert.Equal(suite.t, len(suite.received("/all")), 1)
+}
+
+func (suite *TestNotifySuite) TestSendsWhatIsLeftWhenStopping() {
+	notifier := suite.newNotifier(policy.NotificationRoute{Name: "all", Webhook: suite.server.URL + "/all"})
+	notifier.Add(remediation("foo", "default", ""))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	notifier.Run(ctx, &wg)
+
+	assert.Equal(suite.t, len(suite.received("/all")), 1)
+}
+
+func (suite *TestNotifySuite) TestHasTheTeamLabel() {
+	notifier := suite.newNotifier(policy.NotificationRoute{Name: "all", Webhook: suite.server.URL + "/all"})
+	assert.Equal(suite.t, notifier.TeamLabel(), "team")
+}
+
+func (suite *TestNotifySuite) TestSendsBatchesEveryBatchInterval() {
+	notifier, err := notify.NewNotifier(suite.logger, policy.Notifications{
+		BatchInterval: 10 * time.Millisecond,
+		Routes:        []policy.NotificationRoute{{Name: "all", Webhook: suite.server.URL + "/all"}},
+	})
+	assert.NilError(suite.t, err)
+	notifier.Add(remediation("foo", "default", ""))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go notifier.Run(ctx, &wg)
+	defer wg.Wait()
+	defer cancel()
+
+	for len(suite.received("/all")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (suite *TestNotifySuite) TestKeepsGoingWhenWebhooksAreUnreachable() {
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	notifier := suite.newNotifier(
+		policy.NotificationRoute{Name: "unreachable", Webhook: unreachable.URL + "/all"},
+		policy.NotificationRoute{Name: "all", Webhook: suite.server.URL + "/all"},
+	)
+	notifier.Add(remediation("foo", "default", ""))
+	notifier.Flush("CompletedPodDeleter")
+
+	assert.Equal(suite.t, len(suite.received("/all")), 1)
+}
@@ -0,0 +1,230 @@
+package policy
+
+import (
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemediationPolicies are namespaced custom resources, see kubernetes/remediationpolicy-crd.yaml
+var RemediationPolicyResource = schema.GroupVersionResource{
+	Group:    "kube-remediator.io",
+	Version:  "v1alpha1",
+	Resource: "remediationpolicies",
+}
+
+// lets app teams tune remediators for their namespace, settings that are not set fall back to the cluster defaults
+type RemediationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RemediationPolicySpec   `json:"spec,omitempty"`
+	Status RemediationPolicyStatus `json:"status,omitempty"`
+}
+
+type RemediationPolicySpec struct {
+	Remediators map[string]NamespaceRemediatorSettings `json:"remediators,omitempty"`
+	// pods matching this selector are never touched by any remediator
+	OptOutSelector *metav1.LabelSelector `json:"optOutSelector,omitempty"`
+}
+
+type NamespaceRemediatorSettings struct {
+	Enabled          *bool            `json:"enabled,omitempty"`          // false stops the remediator in this namespace
+	FailureThreshold *int32           `json:"failureThreshold,omitempty"` // restarts before CrashLoopBackOffRescheduler acts
+	MinAge           *metav1.Duration `json:"minAge,omitempty"`           // how old pods or claims need to be before acting
+}
+
+type RemediationPolicyStatus struct {
+	Accepted           bool               `json:"accepted"`
+	Message            string             `json:"message,omitempty"` // why it was not accepted
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Remediations       map[string]int64   `json:"remediations,omitempty"` // per remediator, since the policy was created
+	LastRemediation    *RemediationRecord `json:"lastRemediation,omitempty"`
+}
+
+type RemediationRecord struct {
+	Time       metav1.Time `json:"time"`
+	Remediator string      `json:"remediator"`
+	Action     string      `json:"action"`
+	Kind       string      `json:"kind"`
+	Name       string      `json:"name"`
+	Reason     string      `json:"reason,omitempty"`
+}
+
+// catches mistakes before the policy is accepted, known tells which remediator names exist
+func (p RemediationPolicy) Validate(known func(string) bool) error {
+	for name, settings := range p.Spec.Remediators {
+		if !known(name) {
+			return fmt.Errorf("unknown remediator %q", name)
+		}
+		if settings.FailureThreshold != nil && *settings.FailureThreshold <= 0 {
+			return fmt.Errorf("%s: failureThreshold needs to be positive", name)
+		}
+		if settings.MinAge != nil && settings.MinAge.Duration < 0 {
+			return fmt.Errorf("%s: minAge can not be negative", name)
+		}
+	}
+	if p.Spec.OptOutSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(p.Spec.OptOutSelector); err != nil {
+			return fmt.Errorf("optOutSelector: %w", err)
+		}
+	}
+	return nil
+}
+
+// NamespacePolicies holds the accepted RemediationPolicy of every namespace.
+// A nil *NamespacePolicies is valid and always returns the cluster defaults.
+type NamespacePolicies struct {
+	lock         sync.RWMutex
+	policies     map[string]namespacePolicy
+	remediations map[string]RemediationPolicyStatus // counts per namespace, kept across updates of the policy
+	changed      func(namespace string)             // called when the status needs to be written
+}
+
+type namespacePolicy struct {
+	name   string
+	spec   RemediationPolicySpec
+	optOut labels.Selector
+}
+
+func NewNamespacePolicies(changed func(namespace string)) *NamespacePolicies {
+	return &NamespacePolicies{
+		policies:     map[string]namespacePolicy{},
+		remediations: map[string]RemediationPolicyStatus{},
+		changed:      changed,
+	}
+}
+
+// Set makes the policy the accepted one of its namespace, the counts of its status are kept when they are higher than ours
+func (n *NamespacePolicies) Set(policy RemediationPolicy) {
+	optOut := labels.Nothing()
+	if policy.Spec.OptOutSelector != nil {
+		optOut, _ = metav1.LabelSelectorAsSelector(policy.Spec.OptOutSelector) // validated before
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	namespace := policy.ObjectMeta.Namespace
+	if n.policies[namespace].name != policy.ObjectMeta.Name {
+		delete(n.remediations, namespace) // counts belong to the policy that was accepted before
+	}
+	n.policies[namespace] = namespacePolicy{name: policy.ObjectMeta.Name, spec: policy.Spec, optOut: optOut}
+	remediations := n.remediations[namespace]
+	if remediations.Remediations == nil {
+		remediations.Remediations = map[string]int64{}
+	}
+	for remediator, count := range policy.Status.Remediations {
+		if count > remediations.Remediations[remediator] {
+			remediations.Remediations[remediator] = count
+		}
+	}
+	if remediations.LastRemediation == nil {
+		remediations.LastRemediation = policy.Status.LastRemediation
+	}
+	n.remediations[namespace] = remediations
+}
+
+func (n *NamespacePolicies) Remove(namespace string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.policies, namespace)
+	delete(n.remediations, namespace)
+}
+
+// name of the accepted policy of the namespace, empty when there is none
+func (n *NamespacePolicies) acceptedName(namespace string) string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.policies[namespace].name
+}
+
+// remediations caused in the namespace, as they are reported in the status
+func (n *NamespacePolicies) remediationStatus(namespace string) (map[string]int64, *RemediationRecord) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	status := n.remediations[namespace]
+	counts := map[string]int64{}
+	for remediator, count := range status.Remediations {
+		counts[remediator] = count
+	}
+	return counts, status.LastRemediation
+}
+
+func (n *NamespacePolicies) settings(remediator string, namespace string) (NamespaceRemediatorSettings, bool) {
+	if n == nil {
+		return NamespaceRemediatorSettings{}, false
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	policy, found := n.policies[namespace]
+	if !found {
+		return NamespaceRemediatorSettings{}, false
+	}
+	// case-insensitive like the cluster policy
+	for name, settings := range policy.spec.Remediators {
+		if strings.EqualFold(name, remediator) {
+			return settings, true
+		}
+	}
+	return NamespaceRemediatorSettings{}, true
+}
+
+func (n *NamespacePolicies) IsDisabled(remediator string, namespace string) bool {
+	settings, _ := n.settings(remediator, namespace)
+	return settings.Enabled != nil && !*settings.Enabled
+}
+
+func (n *NamespacePolicies) FailureThreshold(remediator string, namespace string, clusterDefault int32) int32 {
+	if settings, _ := n.settings(remediator, namespace); settings.FailureThreshold != nil {
+		return *settings.FailureThreshold
+	}
+	return clusterDefault
+}
+
+func (n *NamespacePolicies) MinAge(remediator string, namespace string, clusterDefault time.Duration) time.Duration {
+	if settings, _ := n.settings(remediator, namespace); settings.MinAge != nil {
+		return settings.MinAge.Duration
+	}
+	return clusterDefault
+}
+
+// objects the policy of their namespace protects from all remediators
+func (n *NamespacePolicies) IsOptedOut(object metav1.Object) bool {
+	if n == nil {
+		return false
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	policy, found := n.policies[object.GetNamespace()]
+	return found && policy.optOut.Matches(labels.Set(object.GetLabels()))
+}
+
+// counts a remediation in the status of the policy of the namespace, does nothing without a policy
+func (n *NamespacePolicies) RecordRemediation(namespace string, record RemediationRecord) {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	if _, found := n.policies[namespace]; !found {
+		n.lock.Unlock()
+		return
+	}
+	record.Time = metav1.NewTime(record.Time.Truncate(time.Second)) // what survives a round trip through the api
+	status := n.remediations[namespace]
+	status.Remediations[record.Remediator]++
+	status.LastRemediation = &record
+	n.remediations[namespace] = status
+	n.lock.Unlock()
+
+	if n.changed != nil {
+		n.changed(namespace)
+	}
+}
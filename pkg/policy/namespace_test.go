@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func remediationPolicy(namespace string, spec RemediationPolicySpec) RemediationPolicy {
+	return RemediationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: namespace},
+		Spec:       spec,
+	}
+}
+
+func knownRemediators(name string) bool {
+	return name == "CrashLoopBackOffRescheduler" || name == "CompletedPodDeleter"
+}
+
+func TestNilNamespacePoliciesUseClusterDefaults(t *testing.T) {
+	var policies *NamespacePolicies
+	assert.False(t, policies.IsDisabled("CompletedPodDeleter", "default"))
+	assert.Equal(t, int32(5), policies.FailureThreshold("CrashLoopBackOffRescheduler", "default", 5))
+	assert.Equal(t, time.Hour, policies.MinAge("CompletedPodDeleter", "default", time.Hour))
+	assert.False(t, policies.IsOptedOut(&metav1.ObjectMeta{Namespace: "default"}))
+	policies.RecordRemediation("default", RemediationRecord{}) // does not panic
+}
+
+func TestNamespacePoliciesLayerOverClusterDefaults(t *testing.T) {
+	disabled := false
+	threshold := int32(10)
+	policies := NewNamespacePolicies(nil)
+	policies.Set(remediationPolicy("team", RemediationPolicySpec{
+		Remediators: map[string]NamespaceRemediatorSettings{
+			"crashloopbackoffrescheduler": {FailureThreshold: &threshold},
+			"CompletedPodDeleter":         {Enabled: &disabled, MinAge: &metav1.Duration{Duration: 48 * time.Hour}},
+		},
+	}))
+
+	assert.Equal(t, int32(10), policies.FailureThreshold("CrashLoopBackOffRescheduler", "team", 5))
+	assert.Equal(t, int32(5), policies.FailureThreshold("CrashLoopBackOffRescheduler", "other", 5))
+	assert.Equal(t, 48*time.Hour, policies.MinAge("CompletedPodDeleter", "team", time.Hour))
+	assert.Equal(t, time.Hour, policies.MinAge("CrashLoopBackOffRescheduler", "team", time.Hour))
+	assert.True(t, policies.IsDisabled("CompletedPodDeleter", "team"))
+	assert.False(t, policies.IsDisabled("CrashLoopBackOffRescheduler", "team"))
+	assert.False(t, policies.IsDisabled("CompletedPodDeleter", "other"))
+	assert.Equal(t, time.Hour, policies.MinAge("OldPodDeleter", "team", time.Hour))
+
+	policies.Remove("team")
+	assert.False(t, policies.IsDisabled("CompletedPodDeleter", "team"))
+}
+
+func TestNamespacePoliciesOptOut(t *testing.T) {
+	policies := NewNamespacePolicies(nil)
+	policies.Set(remediationPolicy("team", RemediationPolicySpec{
+		OptOutSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+	}))
+	policies.Set(remediationPolicy("other", RemediationPolicySpec{}))
+
+	assert.True(t, policies.IsOptedOut(&metav1.ObjectMeta{Namespace: "team", Labels: map[string]string{"app": "db"}}))
+	assert.False(t, policies.IsOptedOut(&metav1.ObjectMeta{Namespace: "team", Labels: map[string]string{"app": "web"}}))
+	assert.False(t, policies.IsOptedOut(&metav1.ObjectMeta{Namespace: "other", Labels: map[string]string{"app": "db"}}))
+}
+
+func TestNamespacePoliciesCountRemediations(t *testing.T) {
+	var changed []string
+	policies := NewNamespacePolicies(func(namespace string) { changed = append(changed, namespace) })
+	policy := remediationPolicy("team", RemediationPolicySpec{})
+	policy.Status.Remediations = map[string]int64{"CompletedPodDeleter": 3}
+	policies.Set(policy)
+
+	policies.RecordRemediation("team", RemediationRecord{Remediator: "CompletedPodDeleter", Name: "a"})
+	policies.RecordRemediation("other", RemediationRecord{Remediator: "CompletedPodDeleter", Name: "b"})
+
+	counts, last := policies.remediationStatus("team")
+	assert.Equal(t, map[string]int64{"CompletedPodDeleter": 4}, counts)
+	assert.Equal(t, "a", last.Name)
+	assert.Equal(t, []string{"team"}, changed)
+
+	// a different policy starts counting from its own status
+	replacement := remediationPolicy("team", RemediationPolicySpec{})
+	replacement.ObjectMeta.Name = "replacement"
+	policies.Set(replacement)
+	counts, last = policies.remediationStatus("team")
+	assert.Empty(t, counts)
+	assert.Nil(t, last)
+}
+
+func TestRemediationPolicyValidate(t *testing.T) {
+	zero := int32(0)
+	assert.NoError(t, remediationPolicy("team", RemediationPolicySpec{
+		Remediators: map[string]NamespaceRemediatorSettings{"CompletedPodDeleter": {}},
+	}).Validate(knownRemediators))
+
+	assert.EqualError(t, remediationPolicy("team", RemediationPolicySpec{
+		Remediators: map[string]NamespaceRemediatorSettings{"Unknown": {}},
+	}).Validate(knownRemediators), `unknown remediator "Unknown"`)
+	assert.EqualError(t, remediationPolicy("team", RemediationPolicySpec{
+		Remediators: map[string]NamespaceRemediatorSettings{"CrashLoopBackOffRescheduler": {FailureThreshold: &zero}},
+	}).Validate(knownRemediators), "CrashLoopBackOffRescheduler: failureThreshold needs to be positive")
+	assert.EqualError(t, remediationPolicy("team", RemediationPolicySpec{
+		Remediators: map[string]NamespaceRemediatorSettings{"CompletedPodDeleter": {MinAge: &metav1.Duration{Duration: -time.Hour}}},
+	}).Validate(knownRemediators), "CompletedPodDeleter: minAge can not be negative")
+	assert.Error(t, remediationPolicy("team", RemediationPolicySpec{
+		OptOutSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Explode"}}},
+	}).Validate(knownRemediators))
+}
@@ -0,0 +1,167 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sort"
+	"sync"
+)
+
+// NamespacePolicyController keeps Policies in sync with the RemediationPolicies in the cluster
+// and reports in their status if they were accepted and what they caused.
+type NamespacePolicyController struct {
+	Policies *NamespacePolicies
+
+	logger       *zap.Logger
+	informer     cache.SharedIndexInformer // of RemediationPolicyResource, indexed by namespace
+	updateStatus func(*unstructured.Unstructured) error
+	known        func(string) bool // remediator names that can be configured
+	queue        workqueue.Interface
+	registration cache.ResourceEventHandlerRegistration
+}
+
+func NewNamespacePolicyController(
+	logger *zap.Logger,
+	informer cache.SharedIndexInformer,
+	updateStatus func(*unstructured.Unstructured) error,
+	known func(string) bool,
+) (*NamespacePolicyController, error) {
+	c := &NamespacePolicyController{
+		logger:       logger,
+		informer:     informer,
+		updateStatus: updateStatus,
+		known:        known,
+		queue:        workqueue.New(),
+	}
+	c.Policies = NewNamespacePolicies(func(namespace string) { c.queue.Add(namespace) })
+
+	// policies are applied right away so remediators see them as soon as the informer synced,
+	// status is written in the background
+	var err error
+	c.registration, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.sync(obj) },
+		UpdateFunc: func(_, obj interface{}) { c.sync(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			c.sync(obj)
+		},
+	})
+	return c, err
+}
+
+// true once all policies that existed on start are applied, remediators should not start before
+func (c *NamespacePolicyController) HasSynced() bool {
+	return c.registration.HasSynced()
+}
+
+func (c *NamespacePolicyController) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	go c.informer.Run(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+
+	for {
+		namespace, shutdown := c.queue.Get()
+		if shutdown {
+			return
+		}
+		c.writeStatus(namespace.(string))
+		c.queue.Done(namespace)
+	}
+}
+
+func (c *NamespacePolicyController) sync(obj interface{}) {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return // untested section
+	}
+	c.syncNamespace(object.GetNamespace())
+}
+
+// the first valid policy by name is accepted, so adding a second one does not change what applies
+func (c *NamespacePolicyController) syncNamespace(namespace string) {
+	accepted := false
+	for _, object := range c.objects(namespace) {
+		if policy, err := c.check(object); err == nil {
+			c.Policies.Set(policy)
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		c.Policies.Remove(namespace)
+	}
+	c.queue.Add(namespace)
+}
+
+// policies of the namespace sorted by name
+func (c *NamespacePolicyController) objects(namespace string) []*unstructured.Unstructured {
+	items, err := c.informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		c.logger.Error("Error listing remediation policies", zap.Error(err)) // untested section
+		return nil
+	}
+	var objects []*unstructured.Unstructured
+	for _, item := range items {
+		objects = append(objects, item.(*unstructured.Unstructured))
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].GetName() < objects[j].GetName() })
+	return objects
+}
+
+func (c *NamespacePolicyController) check(object *unstructured.Unstructured) (RemediationPolicy, error) {
+	var policy RemediationPolicy
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &policy); err != nil {
+		return policy, fmt.Errorf("invalid spec: %w", err)
+	}
+	return policy, policy.Validate(c.known)
+}
+
+func (c *NamespacePolicyController) writeStatus(namespace string) {
+	acceptedName := c.Policies.acceptedName(namespace)
+	for _, object := range c.objects(namespace) {
+		policy, err := c.check(object)
+
+		status := policy.Status
+		status.ObservedGeneration = object.GetGeneration()
+		if object.GetName() == acceptedName {
+			status.Accepted = true
+			status.Message = ""
+			status.Remediations, status.LastRemediation = c.Policies.remediationStatus(namespace)
+		} else {
+			status.Accepted = false
+			if err != nil {
+				status.Message = err.Error()
+			} else {
+				status.Message = fmt.Sprintf("namespace already has RemediationPolicy %s", acceptedName)
+			}
+		}
+		if equality.Semantic.DeepEqual(status, policy.Status) {
+			continue // writing the same status again would trigger another update
+		}
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+		if err != nil {
+			c.logger.Error("Error converting remediation policy status", zap.Error(err)) // untested section
+			continue
+		}
+		updated := object.DeepCopy()
+		updated.Object["status"] = content
+		if err := c.updateStatus(updated); err != nil {
+			// retried with the next change, or when the informer re-delivers the policy
+			c.logger.Warn("Error updating remediation policy status",
+				zap.String("name", object.GetName()), zap.String("namespace", namespace), zap.Error(err))
+		}
+	}
+}
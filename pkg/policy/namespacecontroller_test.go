@@ -0,0 +1,200 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func remediationPolicyObject(t *testing.T, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kube-remediator.io/v1alpha1",
+		"kind":       "RemediationPolicy",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "team"},
+		"spec":       spec,
+	}}
+	return object
+}
+
+// runs a controller against a fake api until the test ends
+func startNamespacePolicyController(t *testing.T, objects ...runtime.Object) (*NamespacePolicyController, *dynamicfake.FakeDynamicClient) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{RemediationPolicyResource: "RemediationPolicyList"}, objects...)
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	informer := dynamicinformer.NewFilteredDynamicInformer(client, RemediationPolicyResource, "", 0, indexers, nil).Informer()
+
+	logger, _ := zap.NewDevelopment()
+	controller, err := NewNamespacePolicyController(logger, informer,
+		func(object *unstructured.Unstructured) error {
+			_, err := client.Resource(RemediationPolicyResource).Namespace(object.GetNamespace()).
+				UpdateStatus(context.Background(), object, metav1.UpdateOptions{})
+			return err
+		},
+		knownRemediators,
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go controller.Run(ctx, &wg)
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), controller.HasSynced))
+	return controller, client
+}
+
+func eventualStatus(t *testing.T, client *dynamicfake.FakeDynamicClient, name string, check func(RemediationPolicyStatus) bool) RemediationPolicyStatus {
+	var status RemediationPolicyStatus
+	assert.Eventually(t, func() bool {
+		object, err := client.Resource(RemediationPolicyResource).Namespace("team").Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		var policy RemediationPolicy
+		require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &policy))
+		status = policy.Status
+		return check(status)
+	}, 2*time.Second, 10*time.Millisecond)
+	return status
+}
+
+func TestNamespacePolicyControllerAcceptsValidPolicies(t *testing.T) {
+	controller, client := startNamespacePolicyController(t, remediationPolicyObject(t, "a", map[string]interface{}{
+		"remediators": map[string]interface{}{"CrashLoopBackOffRescheduler": map[string]interface{}{"failureThreshold": int64(10)}},
+	}))
+
+	// applied before remediators start
+	assert.Equal(t, int32(10), controller.Policies.FailureThreshold("CrashLoopBackOffRescheduler", "team", 5))
+	eventualStatus(t, client, "a", func(status RemediationPolicyStatus) bool { return status.Accepted })
+}
+
+func TestNamespacePolicyControllerRejectsInvalidAndSecondPolicies(t *testing.T) {
+	_, client := startNamespacePolicyController(t,
+		remediationPolicyObject(t, "a-invalid", map[string]interface{}{
+			"remediators": map[string]interface{}{"Unknown": map[string]interface{}{}},
+		}),
+		remediationPolicyObject(t, "b", map[string]interface{}{}),
+		remediationPolicyObject(t, "c", map[string]interface{}{}),
+	)
+
+	status := eventualStatus(t, client, "a-invalid", func(status RemediationPolicyStatus) bool { return status.Message != "" })
+	assert.False(t, status.Accepted)
+	assert.Equal(t, `unknown remediator "Unknown"`, status.Message)
+	eventualStatus(t, client, "b", func(status RemediationPolicyStatus) bool { return status.Accepted })
+	status = eventualStatus(t, client, "c", func(status RemediationPolicyStatus) bool { return status.Message != "" })
+	assert.Equal(t, "namespace already has RemediationPolicy b", status.Message)
+}
+
+func TestNamespacePolicyControllerReportsRemediations(t *testing.T) {
+	controller, client := startNamespacePolicyController(t, remediationPolicyObject(t, "a", map[string]interface{}{}))
+	eventualStatus(t, client, "a", func(status RemediationPolicyStatus) bool { return status.Accepted })
+
+	controller.Policies.RecordRemediation("team", RemediationRecord{
+		Time:       metav1.Now(),
+		Remediator: "CompletedPodDeleter",
+		Action:     "delete",
+		Kind:       "Pod",
+		Name:       "job-1",
+	})
+
+	status := eventualStatus(t, client, "a", func(status RemediationPolicyStatus) bool { return status.Remediations["CompletedPodDeleter"] == 1 })
+	assert.Equal(t, "job-1", status.LastRemediation.Name)
+}
+
+func TestNamespacePolicyControllerRemovesDeletedPolicies(t *testing.T) {
+	controller, client := startNamespacePolicyController(t, remediationPolicyObject(t, "a", map[string]interface{}{
+		"remediators": map[string]interface{}{"CompletedPodDeleter": map[string]interface{}{"enabled": false}},
+	}))
+	assert.True(t, controller.Policies.IsDisabled("CompletedPodDeleter", "team"))
+
+	err := client.Resource(RemediationPolicyResource).Namespace("team").Delete(context.Background(), "a", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return !controller.Policies.IsDisabled("CompletedPodDeleter", "team") },
+		2*time.Second, 10*time.Millisecond)
+}
+
+func TestNamespacePolicyControllerRejectsPoliciesWithInvalidSpecs(t *testing.T) {
+	_, client := startNamespacePolicyController(t, remediationPolicyObject(t, "a", map[string]interface{}{
+		"remediators": "CompletedPodDeleter",
+	}))
+
+	// the spec can not be read into a RemediationPolicy, so neither can eventualStatus
+	assert.Eventually(t, func() bool {
+		object, err := client.Resource(RemediationPolicyResource).Namespace("team").Get(context.Background(), "a", metav1.GetOptions{})
+		require.NoError(t, err)
+		message, _, _ := unstructured.NestedString(object.Object, "status", "message")
+		return strings.HasPrefix(message, "invalid spec: ")
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestNamespacePolicyControllerAppliesPoliciesWhenStatusCanNotBeWritten(t *testing.T) {
+	controller, client := startNamespacePolicyController(t)
+	var lock sync.Mutex
+	updates := 0
+	client.PrependReactor("update", "remediationpolicies", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		updates++
+		return true, nil, errors.New("Foo")
+	})
+
+	_, err := client.Resource(RemediationPolicyResource).Namespace("team").Create(context.Background(),
+		remediationPolicyObject(t, "a", map[string]interface{}{
+			"remediators": map[string]interface{}{"CompletedPodDeleter": map[string]interface{}{"enabled": false}},
+		}), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return updates > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, controller.Policies.IsDisabled("CompletedPodDeleter", "team"))
+}
+
+// an informer that hands out its event handler, so tests can deliver what only happens on relists
+type handlerInformer struct {
+	cache.SharedIndexInformer
+	handler cache.ResourceEventHandler
+}
+
+func (i *handlerInformer) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	i.handler = handler
+	return i.SharedIndexInformer.AddEventHandler(handler)
+}
+
+func TestNamespacePolicyControllerRemovesPoliciesDeletedWhileDisconnected(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{RemediationPolicyResource: "RemediationPolicyList"})
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	informer := &handlerInformer{SharedIndexInformer: dynamicinformer.NewFilteredDynamicInformer(client, RemediationPolicyResource, "", 0, indexers, nil).Informer()}
+	logger, _ := zap.NewDevelopment()
+	controller, err := NewNamespacePolicyController(logger, informer, nil, knownRemediators)
+	require.NoError(t, err)
+	object := remediationPolicyObject(t, "a", map[string]interface{}{
+		"remediators": map[string]interface{}{"CompletedPodDeleter": map[string]interface{}{"enabled": false}},
+	})
+	require.NoError(t, informer.GetIndexer().Add(object))
+	informer.handler.OnAdd(object, false)
+	require.True(t, controller.Policies.IsDisabled("CompletedPodDeleter", "team"))
+
+	require.NoError(t, informer.GetIndexer().Delete(object))
+	informer.handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "team/a", Obj: object})
+
+	assert.False(t, controller.Policies.IsDisabled("CompletedPodDeleter", "team"))
+}
@@ -1,9 +1,20 @@
 package policy
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"time"
 )
 
 const (
@@ -11,15 +22,131 @@ const (
 	ConfigPath = "config"
 )
 
+// how remediators get rid of pods
+const (
+	ActionDelete = "delete"
+	ActionEvict  = "evict" // respects PodDisruptionBudgets
+)
+
 type RemediatorPolicy struct {
-	DisabledRemediators []string `mapstructure:"disabled_remediators,omitempty"`
+	DisabledRemediators []string                       `mapstructure:"disabled_remediators,omitempty"`
+	DryRun              bool                           `mapstructure:"dry_run"`
+	Remediators         map[string]RemediatorOverrides `mapstructure:"remediators,omitempty"`
+	LeaderElection      LeaderElection                 `mapstructure:"leader_election"`
+	Budget              Budget                         `mapstructure:"budget"` // shared by all remediators
+	Action              string                         `mapstructure:"action"`
+	// evictions blocked by a PodDisruptionBudget are retried later, unless this deletes the pod instead
+	DeleteWhenEvictionBlocked bool    `mapstructure:"delete_when_eviction_blocked"`
+	History                   History `mapstructure:"history"` // only read on start
+	// watch RemediationPolicies so namespaces can tune remediators, needs the CRD, only read on start
+	NamespacePolicies bool    `mapstructure:"namespace_policies"`
+	Scope             Scope   `mapstructure:"scope"`   // what all remediators may act on
+	Windows           Windows `mapstructure:"windows"` // when all remediators may act
+	// every remediator gets its own, so it only counts its own candidates
+	CircuitBreaker CircuitBreaker `mapstructure:"circuit_breaker"`
+	RestartOwner   RestartOwner   `mapstructure:"restart_owner"`
+	Notifications  Notifications  `mapstructure:"notifications"` // only read on start
+	Approval       Approval       `mapstructure:"approval"`
+}
+
+// limits what remediators may act on, everything outside is left alone
+type Scope struct {
+	Namespaces             []string `mapstructure:"namespaces"`         // globs, empty allows all
+	ExcludeNamespaces      []string `mapstructure:"exclude_namespaces"` // globs, win over namespaces
+	PodSelector            string   `mapstructure:"pod_selector"`       // label selector pods need to match
+	NamespaceSelector      string   `mapstructure:"namespace_selector"` // label selector namespaces need to match
+	ExcludePriorityClasses []string `mapstructure:"exclude_priority_classes"`
+	IncludeProtected       bool     `mapstructure:"include_protected"` // drops ProtectedScope, only read from the global scope
+}
+
+// left alone in addition to any configured scope, unless the global scope sets include_protected
+var ProtectedScope = Scope{
+	ExcludeNamespaces:      []string{"kube-system"},
+	ExcludePriorityClasses: []string{"system-cluster-critical", "system-node-critical"},
+}
+
+// pauses remediation where too many candidates pile up within the window,
+// since then the cause is usually not the pods themselves but a bad config push or a broken node.
+// Remediation resumes when fewer candidates are seen again, 0 turns a limit off.
+type CircuitBreaker struct {
+	Window       time.Duration `mapstructure:"window"`
+	MaxCluster   int           `mapstructure:"max_cluster"`   // pauses everything
+	MaxNamespace int           `mapstructure:"max_namespace"` // pauses the namespace
+	MaxShared    int           `mapstructure:"max_shared"`    // pauses pods sharing the image, ConfigMap or node
+}
+
+// restarts Deployments, StatefulSets and DaemonSets like kubectl rollout restart instead of acting on their pods one by one,
+// when enough of their pods need remediation, so the rollout strategy decides how fast pods are replaced
+type RestartOwner struct {
+	Threshold float64       `mapstructure:"threshold"` // fraction of the owner's pods, restarts above it, 0 turns it off
+	Cooldown  time.Duration `mapstructure:"cooldown"`  // pods of restarted owners are left alone for this long
+}
+
+// recent remediations served on /remediations
+type History struct {
+	Size int    `mapstructure:"size"` // how many remediations are kept, 0 keeps none
+	Path string `mapstructure:"path"` // file to keep them across restarts, empty keeps them in memory only
+}
+
+// proposes actions instead of taking them, they wait for a human to approve them on /approvals or with an annotation
+type Approval struct {
+	Required   bool          `mapstructure:"required"`
+	Namespaces []string      `mapstructure:"namespaces"` // globs, empty requires approval in all namespaces
+	Expiry     time.Duration `mapstructure:"expiry"`     // proposals that were not acted on are dropped after this
+}
+
+// sends summaries of what remediators did to Slack and webhooks, every route gets the remediations matching it
+type Notifications struct {
+	TeamLabel string `mapstructure:"team_label"` // label on pods or their controlling owners that routes match teams with
+	// remediators following pod updates send what they did this often, periodic ones after every run
+	BatchInterval time.Duration       `mapstructure:"batch_interval"`
+	Routes        []NotificationRoute `mapstructure:"routes"`
+}
+
+// where notifications go, urls can use environment variables like $SLACK_WEBHOOK_URL so they can come from a Secret
+type NotificationRoute struct {
+	Name       string   `mapstructure:"name"`
+	Teams      []string `mapstructure:"teams"`      // empty matches all, also those without a team
+	Namespaces []string `mapstructure:"namespaces"` // globs, empty matches all
+	Slack      string   `mapstructure:"slack"`      // incoming webhook url
+	Webhook    string   `mapstructure:"webhook"`    // url that gets a json POST
+	Template   string   `mapstructure:"template"`   // text/template of the webhook payload, defaults to the batch as json
+}
+
+// caps how many actions can be taken per window, spent actions are refilled gradually over the window
+type Budget struct {
+	MaxActions int           `mapstructure:"max_actions"` // 0 means unlimited
+	Window     time.Duration `mapstructure:"window"`
+}
+
+// only the leader runs remediators, other replicas wait to take over
+type LeaderElection struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	LeaseName      string        `mapstructure:"lease_name"`
+	LeaseNamespace string        `mapstructure:"lease_namespace"` // defaults to the namespace we run in
+	LeaseDuration  time.Duration `mapstructure:"lease_duration"`
+	RenewDeadline  time.Duration `mapstructure:"renew_deadline"`
+	RetryPeriod    time.Duration `mapstructure:"retry_period"`
+}
+
+// settings of a single remediator that take precedence over the global ones, unset fields fall back to global
+type RemediatorOverrides struct {
+	DryRun *bool   `mapstructure:"dry_run,omitempty"`
+	Budget *Budget `mapstructure:"budget,omitempty"` // in addition to the global budget
+	Action *string `mapstructure:"action,omitempty"`
+	Scope  *Scope  `mapstructure:"scope,omitempty"` // in addition to the global scope
+	// in addition to the global windows, so both need to be open
+	Windows *Windows `mapstructure:"windows,omitempty"`
+	// replaces the global one
+	CircuitBreaker *CircuitBreaker `mapstructure:"circuit_breaker,omitempty"`
+	RestartOwner   *RestartOwner   `mapstructure:"restart_owner,omitempty"` // replaces the global one
+	Approval       *Approval       `mapstructure:"approval,omitempty"`      // replaces the global one
+
+	DeleteWhenEvictionBlocked *bool `mapstructure:"delete_when_eviction_blocked,omitempty"`
 }
 
 func LoadRemediatorPolicy() RemediatorPolicy {
-	viper.SetConfigName(ConfigName)
-	viper.SetConfigType("json")
-	viper.AddConfigPath(ConfigPath)
-	viper.AutomaticEnv()
+	configure()
 	viper.ReadInConfig()
 
 	policy := RemediatorPolicy{}
@@ -29,6 +156,105 @@ func LoadRemediatorPolicy() RemediatorPolicy {
 	return policy
 }
 
+// like LoadRemediatorPolicy, but fails when the config file is broken instead of ignoring it.
+// Unknown fields and values of the wrong type are errors too, errors name the file.
+func ReadRemediatorPolicy() (RemediatorPolicy, error) {
+	configure()
+	policy := RemediatorPolicy{}
+	if err := viper.ReadInConfig(); err != nil {
+		if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound {
+			return policy, fmt.Errorf("%s: %w", ConfigFile(), err)
+		}
+	}
+	if err := viper.UnmarshalExact(&policy); err != nil {
+		return policy, fmt.Errorf("%s: %w", ConfigFile(), err)
+	}
+	if file := viper.ConfigFileUsed(); file != "" {
+		if err := checkTypes(file); err != nil {
+			return policy, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if err := policy.Validate(); err != nil {
+		return policy, fmt.Errorf("%s: %w", ConfigFile(), err)
+	}
+	return policy, nil
+}
+
+// viper converts values so env vars like DRY_RUN=true work, which would also take "1" for a bool or 15 for 15ns
+// from the file, so the file is decoded again without converting anything
+func checkTypes(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err // untested section
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return err // untested section
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{DecodeHook: strictValue, Result: &RemediatorPolicy{}})
+	if err != nil {
+		return err // untested section
+	}
+	return decoder.Decode(fields)
+}
+
+// durations need to be strings like 15s and whole numbers whole, everything else is left to the decoder
+func strictValue(from reflect.Value, to reflect.Value) (interface{}, error) {
+	value := from.Interface()
+	if to.Type() == reflect.TypeOf(time.Duration(0)) {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("needs to be a duration like 15s, got %v", value)
+		}
+		return time.ParseDuration(text)
+	}
+	if number, ok := value.(float64); ok && to.Kind() == reflect.Int && number != math.Trunc(number) {
+		return nil, fmt.Errorf("needs to be a whole number, got %v", number)
+	}
+	return value, nil
+}
+
+// ConfigFile is the policy file that was read, or where it is looked for first
+func ConfigFile() string {
+	if file := viper.ConfigFileUsed(); file != "" {
+		return file
+	}
+	return filepath.Join(ConfigPath, ConfigName+".json")
+}
+
+func configure() {
+	viper.SetConfigName(ConfigName)
+	viper.SetConfigType("json")
+	viper.AddConfigPath(ConfigPath)
+	// defaults make env vars like DRY_RUN or LEADER_ELECTION_ENABLED work without a config file
+	viper.SetDefault("dry_run", false)
+	viper.SetDefault("leader_election.enabled", false)
+	viper.SetDefault("leader_election.lease_name", "kube-remediator")
+	viper.SetDefault("leader_election.lease_namespace", "")
+	viper.SetDefault("leader_election.lease_duration", 15*time.Second)
+	viper.SetDefault("leader_election.renew_deadline", 10*time.Second)
+	viper.SetDefault("leader_election.retry_period", 2*time.Second)
+	viper.SetDefault("budget.max_actions", 0)
+	viper.SetDefault("budget.window", 10*time.Minute)
+	viper.SetDefault("action", ActionDelete)
+	viper.SetDefault("delete_when_eviction_blocked", false)
+	viper.SetDefault("history.size", 1000)
+	viper.SetDefault("history.path", "")
+	viper.SetDefault("namespace_policies", false)
+	viper.SetDefault("circuit_breaker.window", 10*time.Minute)
+	viper.SetDefault("circuit_breaker.max_cluster", 0)
+	viper.SetDefault("circuit_breaker.max_namespace", 0)
+	viper.SetDefault("circuit_breaker.max_shared", 0)
+	viper.SetDefault("restart_owner.threshold", 0)
+	viper.SetDefault("restart_owner.cooldown", 10*time.Minute)
+	viper.SetDefault("approval.required", false)
+	viper.SetDefault("approval.expiry", 24*time.Hour)
+	viper.SetDefault("notifications.team_label", "team")
+	viper.SetDefault("notifications.batch_interval", time.Minute)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+}
+
 func (p RemediatorPolicy) IsDisabled(remediator string) bool {
 	if p.DisabledRemediators != nil {
 		for _, disabledRemediator := range p.DisabledRemediators {
@@ -39,3 +265,327 @@ func (p RemediatorPolicy) IsDisabled(remediator string) bool {
 	}
 	return false
 }
+
+func (p RemediatorPolicy) IsDryRun(remediator string) bool {
+	if overrides := p.overridesFor(remediator); overrides.DryRun != nil {
+		return *overrides.DryRun
+	}
+	return p.DryRun
+}
+
+// budget of a single remediator, unlimited unless configured
+func (p RemediatorPolicy) BudgetFor(remediator string) Budget {
+	if overrides := p.overridesFor(remediator); overrides.Budget != nil {
+		return *overrides.Budget
+	}
+	return Budget{}
+}
+
+// scopes an object needs to be in before the remediator acts on it
+func (p RemediatorPolicy) ScopesFor(remediator string) []Scope {
+	scopes := []Scope{p.Scope}
+	if !p.Scope.IncludeProtected {
+		scopes = append([]Scope{ProtectedScope}, scopes...)
+	}
+	if overrides := p.overridesFor(remediator); overrides.Scope != nil {
+		scopes = append(scopes, *overrides.Scope)
+	}
+	return scopes
+}
+
+// windows that all need to be open before the remediator acts
+func (p RemediatorPolicy) WindowsFor(remediator string) []Windows {
+	windows := []Windows{p.Windows}
+	if overrides := p.overridesFor(remediator); overrides.Windows != nil {
+		windows = append(windows, *overrides.Windows)
+	}
+	return windows
+}
+
+func (p RemediatorPolicy) ActionFor(remediator string) string {
+	if overrides := p.overridesFor(remediator); overrides.Action != nil {
+		return *overrides.Action
+	}
+	if p.Action == "" {
+		return ActionDelete
+	}
+	return p.Action
+}
+
+func (p RemediatorPolicy) CircuitBreakerFor(remediator string) CircuitBreaker {
+	if overrides := p.overridesFor(remediator); overrides.CircuitBreaker != nil {
+		return *overrides.CircuitBreaker
+	}
+	return p.CircuitBreaker
+}
+
+func (p RemediatorPolicy) RestartOwnerFor(remediator string) RestartOwner {
+	if overrides := p.overridesFor(remediator); overrides.RestartOwner != nil {
+		return *overrides.RestartOwner
+	}
+	return p.RestartOwner
+}
+
+func (p RemediatorPolicy) ApprovalFor(remediator string) Approval {
+	if overrides := p.overridesFor(remediator); overrides.Approval != nil {
+		return *overrides.Approval
+	}
+	return p.Approval
+}
+
+func (p RemediatorPolicy) DeletesWhenEvictionBlocked(remediator string) bool {
+	if overrides := p.overridesFor(remediator); overrides.DeleteWhenEvictionBlocked != nil {
+		return *overrides.DeleteWhenEvictionBlocked
+	}
+	return p.DeleteWhenEvictionBlocked
+}
+
+// catches mistakes that would otherwise make remediators do something unexpected
+func (p RemediatorPolicy) Validate() error {
+	actions := []string{p.Action}
+	for _, overrides := range p.Remediators {
+		if overrides.Action != nil {
+			actions = append(actions, *overrides.Action)
+		}
+	}
+	for _, action := range actions {
+		if action != "" && action != ActionDelete && action != ActionEvict {
+			return fmt.Errorf("unknown action %q, use %q or %q", action, ActionDelete, ActionEvict)
+		}
+	}
+	if p.History.Size < 0 {
+		return fmt.Errorf("history size %d can not be negative", p.History.Size)
+	}
+	if err := p.Budget.Validate(); err != nil {
+		return fmt.Errorf("budget: %w", err)
+	}
+	if namespace := p.LeaderElection.LeaseNamespace; namespace != "" {
+		if err := ValidateNamespace(namespace); err != nil {
+			return fmt.Errorf("leader_election: lease_namespace: %w", err)
+		}
+	}
+	if err := p.Scope.Validate(); err != nil {
+		return fmt.Errorf("scope: %w", err)
+	}
+	if err := p.Windows.Validate(); err != nil {
+		return fmt.Errorf("windows: %w", err)
+	}
+	if err := p.CircuitBreaker.Validate(); err != nil {
+		return fmt.Errorf("circuit_breaker: %w", err)
+	}
+	if err := p.RestartOwner.Validate(); err != nil {
+		return fmt.Errorf("restart_owner: %w", err)
+	}
+	if err := p.Approval.Validate(); err != nil {
+		return fmt.Errorf("approval: %w", err)
+	}
+	if err := p.Notifications.Validate(); err != nil {
+		return fmt.Errorf("notifications: %w", err)
+	}
+	for name, overrides := range p.Remediators {
+		if overrides.Budget != nil {
+			if err := overrides.Budget.Validate(); err != nil {
+				return fmt.Errorf("%s budget: %w", name, err)
+			}
+		}
+		if overrides.Scope != nil {
+			if err := overrides.Scope.Validate(); err != nil {
+				return fmt.Errorf("%s scope: %w", name, err)
+			}
+		}
+		if overrides.Windows != nil {
+			if err := overrides.Windows.Validate(); err != nil {
+				return fmt.Errorf("%s windows: %w", name, err)
+			}
+		}
+		if overrides.CircuitBreaker != nil {
+			if err := overrides.CircuitBreaker.Validate(); err != nil {
+				return fmt.Errorf("%s circuit_breaker: %w", name, err)
+			}
+		}
+		if overrides.RestartOwner != nil {
+			if err := overrides.RestartOwner.Validate(); err != nil {
+				return fmt.Errorf("%s restart_owner: %w", name, err)
+			}
+		}
+		if overrides.Approval != nil {
+			if err := overrides.Approval.Validate(); err != nil {
+				return fmt.Errorf("%s approval: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// remediator names in disabled_remediators and remediators need to be known, so typos do not silently do nothing
+func (p RemediatorPolicy) ValidateRemediators(known func(string) bool) error {
+	for _, name := range p.DisabledRemediators {
+		if !known(name) {
+			return fmt.Errorf("disabled_remediators: unknown remediator %q", name)
+		}
+	}
+	for name := range p.Remediators {
+		if !known(name) {
+			return fmt.Errorf("remediators: unknown remediator %q", name)
+		}
+	}
+	return nil
+}
+
+func (s Scope) Validate() error {
+	for _, pattern := range append(append([]string{}, s.Namespaces...), s.ExcludeNamespaces...) {
+		if err := validateNamespacePattern(pattern); err != nil {
+			return err
+		}
+	}
+	if _, err := labels.Parse(s.PodSelector); err != nil {
+		return fmt.Errorf("invalid pod_selector: %w", err)
+	}
+	if _, err := labels.Parse(s.NamespaceSelector); err != nil {
+		return fmt.Errorf("invalid namespace_selector: %w", err)
+	}
+	return nil
+}
+
+func (c CircuitBreaker) Validate() error {
+	if c.MaxCluster < 0 || c.MaxNamespace < 0 || c.MaxShared < 0 {
+		return fmt.Errorf("limits can not be negative")
+	}
+	if !c.IsDisabled() && c.Window <= 0 {
+		return fmt.Errorf("window needs to be positive, got %s", c.Window)
+	}
+	return nil
+}
+
+func (c CircuitBreaker) IsDisabled() bool {
+	return c.MaxCluster == 0 && c.MaxNamespace == 0 && c.MaxShared == 0
+}
+
+func (r RestartOwner) Validate() error {
+	if r.Threshold < 0 || r.Threshold >= 1 {
+		return fmt.Errorf("threshold needs to be at least 0 and below 1, got %v", r.Threshold)
+	}
+	if r.Threshold > 0 && r.Cooldown <= 0 {
+		return fmt.Errorf("cooldown needs to be positive, got %s", r.Cooldown)
+	}
+	return nil
+}
+
+func (a Approval) Validate() error {
+	if a.Required && a.Expiry <= 0 {
+		return fmt.Errorf("expiry needs to be positive, got %s", a.Expiry)
+	}
+	for _, pattern := range a.Namespaces {
+		if err := validateNamespacePattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// if actions in the namespace need approval
+func (a Approval) IsRequired(namespace string) bool {
+	if !a.Required {
+		return false
+	}
+	if len(a.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range a.Namespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (n Notifications) Validate() error {
+	if len(n.Routes) > 0 && n.BatchInterval <= 0 {
+		return fmt.Errorf("batch_interval needs to be positive, got %s", n.BatchInterval)
+	}
+	names := map[string]bool{}
+	for _, route := range n.Routes {
+		if route.Name == "" {
+			return fmt.Errorf("routes need a name")
+		}
+		if names[route.Name] {
+			return fmt.Errorf("route %s is defined twice", route.Name)
+		}
+		names[route.Name] = true
+		if (route.Slack == "") == (route.Webhook == "") {
+			return fmt.Errorf("route %s needs either slack or webhook", route.Name)
+		}
+		if route.Template != "" && route.Webhook == "" {
+			return fmt.Errorf("route %s: template only applies to webhook", route.Name)
+		}
+		for _, pattern := range route.Namespaces {
+			if err := validateNamespacePattern(pattern); err != nil {
+				return fmt.Errorf("route %s: %w", route.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (b Budget) Validate() error {
+	if b.MaxActions < 0 {
+		return fmt.Errorf("max_actions can not be negative, got %d", b.MaxActions)
+	}
+	if b.Window < 0 {
+		return fmt.Errorf("window can not be negative, got %s", b.Window)
+	}
+	return nil
+}
+
+func (b Budget) IsUnlimited() bool {
+	return b.MaxActions <= 0 || b.Window <= 0
+}
+
+// viper lower-cases map keys, so remediator names are matched case-insensitive like in IsDisabled
+func (p RemediatorPolicy) overridesFor(remediator string) RemediatorOverrides {
+	for name, overrides := range p.Remediators {
+		if strings.EqualFold(name, remediator) {
+			return overrides
+		}
+	}
+	return RemediatorOverrides{}
+}
+
+// ValidateNamespace checks namespaces are DNS labels like team-a
+func ValidateNamespace(namespace string) error {
+	if problems := validation.IsDNS1123Label(namespace); len(problems) > 0 {
+		return fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// globs like team-* need to be valid, patterns without wildcards need to be valid namespaces since they could never match
+func validateNamespacePattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+	}
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return ValidateNamespace(pattern)
+	}
+	return nil
+}
+
+// sections that differ from the running policy but are only read on start, so they need a restart to apply
+func (p RemediatorPolicy) NeedsRestart(running RemediatorPolicy) []string {
+	sections := []struct {
+		name              string
+		reloaded, current interface{}
+	}{
+		{"leader_election", p.LeaderElection, running.LeaderElection},
+		{"history", p.History, running.History},
+		{"namespace_policies", p.NamespacePolicies, running.NamespacePolicies},
+		{"notifications", p.Notifications, running.Notifications},
+	}
+	var changed []string
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.reloaded, section.current) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
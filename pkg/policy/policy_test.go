@@ -4,14 +4,17 @@ import (
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
 	OldPodDeleterRemediator = "OldPodDeleter"
 	DisabledRemediatorsKey  = "disabled_remediators"
 	DisabledEnvironmentVar  = "DISABLED_REMEDIATORS"
+	DryRunEnvironmentVar    = "DRY_RUN"
 )
 
 func init() {
@@ -51,6 +54,117 @@ func TestLoadRemediatorsUsesEnvVar(t *testing.T) {
 		"remediator should be disabled when set via env variable %s", DisabledEnvironmentVar)
 }
 
+func TestIsDryRunDefaultsToFalse(t *testing.T) {
+	policy := RemediatorPolicy{}
+	assert.Falsef(t, policy.IsDryRun(OldPodDeleterRemediator), "remediators should not be in dry-run by default")
+}
+
+func TestIsDryRunUsesGlobalSetting(t *testing.T) {
+	policy := RemediatorPolicy{DryRun: true}
+	assert.Truef(t, policy.IsDryRun(OldPodDeleterRemediator), "remediators should be in dry-run when enabled globally")
+}
+
+func TestIsDryRunPrefersRemediatorOverride(t *testing.T) {
+	disabled := false
+	policy := RemediatorPolicy{
+		DryRun:      true,
+		Remediators: map[string]RemediatorOverrides{strings.ToLower(OldPodDeleterRemediator): {DryRun: &disabled}},
+	}
+	assert.Falsef(t, policy.IsDryRun(OldPodDeleterRemediator), "remediator override should win over global dry-run")
+	assert.Truef(t, policy.IsDryRun("CompletedPodDeleter"), "other remediators should keep global dry-run")
+}
+
+func TestLoadRemediatorsUsesDryRunEnvVar(t *testing.T) {
+	os.Setenv(DryRunEnvironmentVar, "true")
+	defer os.Unsetenv(DryRunEnvironmentVar)
+
+	policy := LoadRemediatorPolicy()
+	assert.Truef(t, policy.IsDryRun(OldPodDeleterRemediator),
+		"remediators should be in dry-run when set via env variable %s", DryRunEnvironmentVar)
+}
+
+func TestBudgetForIsUnlimitedByDefault(t *testing.T) {
+	policy := RemediatorPolicy{Budget: Budget{MaxActions: 20, Window: 10 * time.Minute}}
+	assert.Truef(t, policy.BudgetFor(OldPodDeleterRemediator).IsUnlimited(), "global budget should not apply per remediator")
+}
+
+func TestBudgetForUsesRemediatorOverride(t *testing.T) {
+	budget := Budget{MaxActions: 5, Window: time.Hour}
+	policy := RemediatorPolicy{
+		Remediators: map[string]RemediatorOverrides{strings.ToLower(OldPodDeleterRemediator): {Budget: &budget}},
+	}
+	assert.Equal(t, budget, policy.BudgetFor(OldPodDeleterRemediator))
+	assert.False(t, policy.BudgetFor(OldPodDeleterRemediator).IsUnlimited())
+}
+
+func TestActionForDefaultsToDelete(t *testing.T) {
+	policy := RemediatorPolicy{}
+	assert.Equal(t, ActionDelete, policy.ActionFor(OldPodDeleterRemediator))
+}
+
+func TestActionForUsesRemediatorOverride(t *testing.T) {
+	evict := ActionEvict
+	policy := RemediatorPolicy{
+		Action:      ActionDelete,
+		Remediators: map[string]RemediatorOverrides{strings.ToLower(OldPodDeleterRemediator): {Action: &evict}},
+	}
+	assert.Equal(t, ActionEvict, policy.ActionFor(OldPodDeleterRemediator))
+	assert.Equal(t, ActionDelete, policy.ActionFor("CompletedPodDeleter"))
+}
+
+func TestDeletesWhenEvictionBlockedUsesRemediatorOverride(t *testing.T) {
+	deletes := true
+	policy := RemediatorPolicy{
+		Remediators: map[string]RemediatorOverrides{strings.ToLower(OldPodDeleterRemediator): {DeleteWhenEvictionBlocked: &deletes}},
+	}
+	assert.True(t, policy.DeletesWhenEvictionBlocked(OldPodDeleterRemediator))
+	assert.False(t, policy.DeletesWhenEvictionBlocked("CompletedPodDeleter"))
+}
+
+func TestValidateRejectsUnknownAction(t *testing.T) {
+	unknown := "explode"
+	policy := RemediatorPolicy{
+		Remediators: map[string]RemediatorOverrides{strings.ToLower(OldPodDeleterRemediator): {Action: &unknown}},
+	}
+	assert.Error(t, policy.Validate())
+	assert.NoError(t, RemediatorPolicy{Action: ActionEvict}.Validate())
+}
+
+func useConfig(t *testing.T, content string) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, ConfigName+".json"), []byte(content), 0644)
+	assert.NoError(t, err)
+
+	viper.Reset()
+	viper.AddConfigPath(dir)
+	t.Cleanup(func() {
+		viper.Reset()
+		viper.AddConfigPath("../../config")
+	})
+}
+
+func TestReadRemediatorPolicyReadsConfig(t *testing.T) {
+	useConfig(t, `{"disabled_remediators": ["OldPodDeleter"]}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.True(t, policy.IsDisabled(OldPodDeleterRemediator))
+}
+
+func TestReadRemediatorPolicyFailsOnBrokenConfig(t *testing.T) {
+	useConfig(t, `{"disabled_remediators": [`)
+
+	_, err := ReadRemediatorPolicy()
+	assert.Error(t, err)
+}
+
+func TestReadRemediatorPolicyFailsOnInvalidConfig(t *testing.T) {
+	useConfig(t, `{"action": "explode"}`)
+
+	_, err := ReadRemediatorPolicy()
+	assert.Error(t, err)
+}
+
 func TestLoadRemediatorsAllowsMissingConfigFile(t *testing.T) {
 	viper.Reset()
 
@@ -58,3 +172,267 @@ func TestLoadRemediatorsAllowsMissingConfigFile(t *testing.T) {
 	assert.NotNil(t, policy, "Policy should be initialized even when config file is not defined")
 	assert.Empty(t, policy.DisabledRemediators, "should not have any disabled remediators")
 }
+
+func TestReadRemediatorPolicyDefaultsHistory(t *testing.T) {
+	useConfig(t, `{}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, History{Size: 1000}, policy.History)
+}
+
+func TestValidateRejectsNegativeHistorySize(t *testing.T) {
+	assert.Error(t, RemediatorPolicy{History: History{Size: -1}}.Validate())
+}
+
+func TestReadRemediatorPolicyProtectsSystemByDefault(t *testing.T) {
+	useConfig(t, `{}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []Scope{ProtectedScope, {}}, policy.ScopesFor("CompletedPodDeleter"))
+}
+
+func TestReadRemediatorPolicyKeepsProtectingSystemWithExcludes(t *testing.T) {
+	useConfig(t, `{"scope": {"exclude_namespaces": ["*-prod"], "exclude_priority_classes": ["critical"]}}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []Scope{ProtectedScope, {
+		ExcludeNamespaces:      []string{"*-prod"},
+		ExcludePriorityClasses: []string{"critical"},
+	}}, policy.ScopesFor("CompletedPodDeleter"))
+}
+
+func TestReadRemediatorPolicyIncludesProtectedWhenAsked(t *testing.T) {
+	useConfig(t, `{"scope": {"include_protected": true}}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []Scope{{IncludeProtected: true}}, policy.ScopesFor("CompletedPodDeleter"))
+}
+
+func TestReadRemediatorPolicyReadsScopes(t *testing.T) {
+	useConfig(t, `{
+		"scope": {"namespaces": ["team-*"], "pod_selector": "app!=db"},
+		"remediators": {"CompletedPodDeleter": {"scope": {"namespace_selector": "env=dev"}}}
+	}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []Scope{ProtectedScope, {
+		Namespaces:  []string{"team-*"},
+		PodSelector: "app!=db",
+	}, {
+		NamespaceSelector: "env=dev",
+	}}, policy.ScopesFor("CompletedPodDeleter"))
+	assert.Len(t, policy.ScopesFor(OldPodDeleterRemediator), 2)
+}
+
+func TestValidateRejectsInvalidScopes(t *testing.T) {
+	assert.Error(t, RemediatorPolicy{Scope: Scope{ExcludeNamespaces: []string{"team-["}}}.Validate())
+	assert.Error(t, RemediatorPolicy{Scope: Scope{PodSelector: "app in"}}.Validate())
+	assert.Error(t, RemediatorPolicy{Remediators: map[string]RemediatorOverrides{
+		"CompletedPodDeleter": {Scope: &Scope{NamespaceSelector: "=dev"}},
+	}}.Validate())
+	assert.NoError(t, RemediatorPolicy{Scope: Scope{Namespaces: []string{"team-*"}, PodSelector: "app"}}.Validate())
+}
+
+func TestReadRemediatorPolicyReadsCircuitBreakers(t *testing.T) {
+	useConfig(t, `{
+		"circuit_breaker": {"max_cluster": 100},
+		"remediators": {"CrashLoopBackOffRescheduler": {"circuit_breaker": {"window": "5m", "max_shared": 10}}}
+	}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitBreaker{Window: 10 * time.Minute, MaxCluster: 100}, policy.CircuitBreakerFor(OldPodDeleterRemediator))
+	assert.Equal(t, CircuitBreaker{Window: 5 * time.Minute, MaxShared: 10}, policy.CircuitBreakerFor("CrashLoopBackOffRescheduler"))
+}
+
+func TestCircuitBreakerIsDisabledByDefault(t *testing.T) {
+	useConfig(t, `{}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.True(t, policy.CircuitBreakerFor(OldPodDeleterRemediator).IsDisabled())
+}
+
+func TestValidateRejectsInvalidCircuitBreakers(t *testing.T) {
+	assert.Error(t, RemediatorPolicy{CircuitBreaker: CircuitBreaker{MaxNamespace: -1}}.Validate())
+	assert.Error(t, RemediatorPolicy{Remediators: map[string]RemediatorOverrides{
+		"CrashLoopBackOffRescheduler": {CircuitBreaker: &CircuitBreaker{MaxShared: 10}},
+	}}.Validate())
+	assert.NoError(t, RemediatorPolicy{CircuitBreaker: CircuitBreaker{MaxCluster: 100, Window: time.Minute}}.Validate())
+}
+
+func TestReadRemediatorPolicyReadsRestartOwner(t *testing.T) {
+	useConfig(t, `{"remediators": {"CrashLoopBackOffRescheduler": {"restart_owner": {"threshold": 0.5, "cooldown": "15m"}}}}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, RestartOwner{Threshold: 0.5, Cooldown: 15 * time.Minute}, policy.RestartOwnerFor("CrashLoopBackOffRescheduler"))
+	assert.Equal(t, RestartOwner{Cooldown: 10 * time.Minute}, policy.RestartOwnerFor(OldPodDeleterRemediator))
+}
+
+func TestValidateRejectsInvalidRestartOwner(t *testing.T) {
+	assert.Error(t, RemediatorPolicy{RestartOwner: RestartOwner{Threshold: 1, Cooldown: time.Minute}}.Validate())
+	assert.Error(t, RemediatorPolicy{Remediators: map[string]RemediatorOverrides{
+		"CrashLoopBackOffRescheduler": {RestartOwner: &RestartOwner{Threshold: 0.5}},
+	}}.Validate())
+	assert.NoError(t, RemediatorPolicy{RestartOwner: RestartOwner{Threshold: 0.5, Cooldown: time.Minute}}.Validate())
+}
+
+func TestReadRemediatorPolicyReadsNotifications(t *testing.T) {
+	useConfig(t, `{"notifications": {"routes": [
+		{"name": "payments", "teams": ["payments"], "slack": "https://hooks.slack.com/services/T/B/X"},
+		{"name": "audit", "namespaces": ["prod-*"], "webhook": "https://audit.example.com", "template": "{{ .Remediator }}"}
+	]}}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, "team", policy.Notifications.TeamLabel)
+	assert.Equal(t, time.Minute, policy.Notifications.BatchInterval)
+	assert.Equal(t, []NotificationRoute{
+		{Name: "payments", Teams: []string{"payments"}, Slack: "https://hooks.slack.com/services/T/B/X"},
+		{Name: "audit", Namespaces: []string{"prod-*"}, Webhook: "https://audit.example.com", Template: "{{ .Remediator }}"},
+	}, policy.Notifications.Routes)
+	assert.NoError(t, policy.Validate())
+}
+
+func TestValidateRejectsInvalidNotificationRoutes(t *testing.T) {
+	for _, routes := range [][]NotificationRoute{
+		{{Slack: "https://hooks.slack.com"}},
+		{{Name: "a", Slack: "https://hooks.slack.com"}, {Name: "a", Webhook: "https://example.com"}},
+		{{Name: "a"}},
+		{{Name: "a", Slack: "https://hooks.slack.com", Webhook: "https://example.com"}},
+		{{Name: "a", Slack: "https://hooks.slack.com", Template: "{{ . }}"}},
+		{{Name: "a", Slack: "https://hooks.slack.com", Namespaces: []string{"["}}},
+	} {
+		assert.Error(t, RemediatorPolicy{Notifications: Notifications{BatchInterval: time.Minute, Routes: routes}}.Validate(), "%v", routes)
+	}
+}
+
+func TestValidateRejectsNotificationRoutesWithoutBatchInterval(t *testing.T) {
+	routes := []NotificationRoute{{Name: "a", Slack: "https://hooks.slack.com"}}
+	assert.Error(t, RemediatorPolicy{Notifications: Notifications{Routes: routes}}.Validate())
+}
+
+func TestReadRemediatorPolicyReadsApproval(t *testing.T) {
+	useConfig(t, `{"remediators": {"CrashLoopBackOffRescheduler": {"approval": {"required": true, "namespaces": ["prod-*"], "expiry": "2h"}}}}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	approval := policy.ApprovalFor("CrashLoopBackOffRescheduler")
+	assert.Equal(t, Approval{Required: true, Namespaces: []string{"prod-*"}, Expiry: 2 * time.Hour}, approval)
+	assert.True(t, approval.IsRequired("prod-payments"))
+	assert.False(t, approval.IsRequired("staging"))
+	assert.False(t, policy.ApprovalFor(OldPodDeleterRemediator).IsRequired("prod-payments"))
+}
+
+func TestValidateRejectsInvalidApproval(t *testing.T) {
+	assert.Error(t, RemediatorPolicy{Approval: Approval{Required: true}}.Validate())
+	assert.Error(t, RemediatorPolicy{Approval: Approval{Namespaces: []string{"["}}}.Validate())
+	assert.Error(t, RemediatorPolicy{Remediators: map[string]RemediatorOverrides{
+		strings.ToLower(OldPodDeleterRemediator): {Approval: &Approval{Required: true}},
+	}}.Validate())
+	assert.True(t, Approval{Required: true, Expiry: time.Hour}.IsRequired("default"))
+}
+
+func TestConfigFileIsTheFileThatWasRead(t *testing.T) {
+	useConfig(t, `{}`)
+	assert.Equal(t, filepath.Join(ConfigPath, ConfigName+".json"), ConfigFile())
+
+	_, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.NotEqual(t, filepath.Join(ConfigPath, ConfigName+".json"), ConfigFile())
+	assert.Equal(t, ConfigName+".json", filepath.Base(ConfigFile()))
+}
+
+func TestReadRemediatorPolicyRejectsUnknownFields(t *testing.T) {
+	useConfig(t, `{"dry_rn": true}`)
+	_, err := ReadRemediatorPolicy()
+	assert.ErrorContains(t, err, "remediator_policy.json")
+	assert.ErrorContains(t, err, "dry_rn")
+
+	useConfig(t, `{"remediators": {"CompletedPodDeleter": {"budget": {"max_action": 5}}}}`)
+	_, err = ReadRemediatorPolicy()
+	assert.ErrorContains(t, err, "max_action")
+}
+
+func TestReadRemediatorPolicyRejectsWrongTypes(t *testing.T) {
+	useConfig(t, `{"budget": {"max_actions": "many"}}`)
+	_, err := ReadRemediatorPolicy()
+	assert.ErrorContains(t, err, "budget.max_actions")
+
+	useConfig(t, `{"circuit_breaker": {"window": "soon"}}`)
+	_, err = ReadRemediatorPolicy()
+	assert.ErrorContains(t, err, "circuit_breaker.window")
+}
+
+func TestReadRemediatorPolicyDoesNotConvertValuesFromTheFile(t *testing.T) {
+	for content, field := range map[string]string{
+		`{"dry_run": "1"}`:                                              "dry_run",
+		`{"history": {"size": true}}`:                                   "history.size",
+		`{"budget": {"max_actions": "20"}}`:                             "budget.max_actions",
+		`{"budget": {"max_actions": 2.5}}`:                              "budget.max_actions",
+		`{"leader_election": {"lease_duration": 15}}`:                   "leader_election.lease_duration",
+		`{"remediators": {"OldPodDeleter": {"dry_run": "true"}}}`:       "dry_run",
+		`{"remediators": {"OldPodDeleter": {"budget": {"window": 5}}}}`: "budget.window",
+	} {
+		useConfig(t, content)
+		_, err := ReadRemediatorPolicy()
+		assert.ErrorContains(t, err, field, content)
+		assert.ErrorContains(t, err, "remediator_policy.json", content)
+	}
+}
+
+func TestReadRemediatorPolicyConvertsEnvVars(t *testing.T) {
+	useConfig(t, `{"leader_election": {"lease_duration": "30s"}}`)
+	t.Setenv("DRY_RUN", "true")
+	t.Setenv("BUDGET_MAX_ACTIONS", "20")
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.True(t, policy.DryRun)
+	assert.Equal(t, 20, policy.Budget.MaxActions)
+	assert.Equal(t, 30*time.Second, policy.LeaderElection.LeaseDuration)
+}
+
+func TestValidateRejectsNegativeBudgets(t *testing.T) {
+	assert.Error(t, RemediatorPolicy{Budget: Budget{MaxActions: -1}}.Validate())
+	assert.Error(t, RemediatorPolicy{Remediators: map[string]RemediatorOverrides{
+		"CompletedPodDeleter": {Budget: &Budget{MaxActions: 5, Window: -time.Minute}},
+	}}.Validate())
+	assert.NoError(t, RemediatorPolicy{Budget: Budget{MaxActions: 5, Window: time.Minute}}.Validate())
+}
+
+func TestValidateRejectsInvalidNamespaces(t *testing.T) {
+	assert.ErrorContains(t, RemediatorPolicy{Scope: Scope{ExcludeNamespaces: []string{"Kube_System"}}}.Validate(), `invalid namespace "Kube_System"`)
+	assert.Error(t, RemediatorPolicy{Approval: Approval{Namespaces: []string{"prod_payments"}}}.Validate())
+	assert.Error(t, RemediatorPolicy{LeaderElection: LeaderElection{LeaseNamespace: "-system"}}.Validate())
+	assert.NoError(t, RemediatorPolicy{Scope: Scope{Namespaces: []string{"team-*", "prod-[ab]", "default"}}}.Validate())
+}
+
+func TestValidateRemediatorsRejectsUnknownNames(t *testing.T) {
+	known := func(name string) bool { return strings.EqualFold(name, OldPodDeleterRemediator) }
+
+	assert.NoError(t, RemediatorPolicy{
+		DisabledRemediators: []string{"oldpoddeleter"},
+		Remediators:         map[string]RemediatorOverrides{"oldpoddeleter": {}},
+	}.ValidateRemediators(known))
+	assert.ErrorContains(t, RemediatorPolicy{DisabledRemediators: []string{"OldPodDeletr"}}.ValidateRemediators(known),
+		`disabled_remediators: unknown remediator "OldPodDeletr"`)
+	assert.ErrorContains(t, RemediatorPolicy{Remediators: map[string]RemediatorOverrides{"crashloop": {}}}.ValidateRemediators(known),
+		`remediators: unknown remediator "crashloop"`)
+}
+
+func TestNeedsRestartListsChangedStartSections(t *testing.T) {
+	running := RemediatorPolicy{History: History{Size: 100}, Budget: Budget{MaxActions: 5}}
+
+	assert.Empty(t, RemediatorPolicy{History: History{Size: 100}, Budget: Budget{MaxActions: 10}}.NeedsRestart(running))
+	assert.Equal(t, []string{"leader_election", "history", "namespace_policies"}, RemediatorPolicy{
+		LeaderElection:    LeaderElection{Enabled: true},
+		NamespacePolicies: true,
+	}.NeedsRestart(running))
+}
@@ -0,0 +1,161 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// when remediators may act, actions outside are deferred until a window opens
+type Windows struct {
+	Timezone string   `mapstructure:"timezone"` // IANA name like Europe/Berlin, default UTC
+	Allowed  []Window `mapstructure:"allowed"`  // recurring, empty allows any time outside of freezes
+	Freezes  []Freeze `mapstructure:"freezes"`  // one-off periods without actions, win over allowed windows
+}
+
+// recurring window, an end before the start runs past midnight into the next day
+type Window struct {
+	Days  []string `mapstructure:"days"`  // mon, tue, ... of the start, empty means every day
+	Start string   `mapstructure:"start"` // 15:04
+	End   string   `mapstructure:"end"`
+}
+
+type Freeze struct {
+	Start  string `mapstructure:"start"` // 2006-01-02 or RFC3339, dates start at midnight
+	End    string `mapstructure:"end"`   // dates include the whole day
+	Reason string `mapstructure:"reason"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule is Windows parsed once, so checking it is cheap
+type Schedule struct {
+	location *time.Location
+	windows  []window
+	freezes  []freeze
+}
+
+type window struct {
+	days       [7]bool
+	start, end time.Duration // since midnight
+}
+
+type freeze struct {
+	start, end time.Time
+	reason     string
+}
+
+func NewSchedule(config Windows) (*Schedule, error) {
+	location, err := time.LoadLocation(config.Timezone) // "" is UTC
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %w", err)
+	}
+	schedule := &Schedule{location: location}
+
+	for i, allowed := range config.Allowed {
+		var w window
+		if w.start, err = parseClock(allowed.Start); err != nil {
+			return nil, fmt.Errorf("allowed window %d: start: %w", i, err)
+		}
+		if w.end, err = parseClock(allowed.End); err != nil {
+			return nil, fmt.Errorf("allowed window %d: end: %w", i, err)
+		}
+		for _, day := range allowed.Days {
+			weekday, found := weekdays[strings.ToLower(day)]
+			if !found {
+				return nil, fmt.Errorf("allowed window %d: unknown day %q, use mon, tue, wed, thu, fri, sat or sun", i, day)
+			}
+			w.days[weekday] = true
+		}
+		if len(allowed.Days) == 0 {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		schedule.windows = append(schedule.windows, w)
+	}
+
+	for i, config := range config.Freezes {
+		f := freeze{reason: config.Reason}
+		if f.start, err = parseDate(config.Start, location, false); err != nil {
+			return nil, fmt.Errorf("freeze %d: start: %w", i, err)
+		}
+		if f.end, err = parseDate(config.End, location, true); err != nil {
+			return nil, fmt.Errorf("freeze %d: end: %w", i, err)
+		}
+		if !f.end.After(f.start) {
+			return nil, fmt.Errorf("freeze %d: end needs to be after start", i)
+		}
+		schedule.freezes = append(schedule.freezes, f)
+	}
+	return schedule, nil
+}
+
+func (w Windows) Validate() error {
+	_, err := NewSchedule(w)
+	return err
+}
+
+// IsOpen tells if remediators may act at the time, reason says why not
+func (s *Schedule) IsOpen(now time.Time) (open bool, reason string) {
+	now = now.In(s.location)
+	for _, f := range s.freezes {
+		if !now.Before(f.start) && now.Before(f.end) {
+			if f.reason == "" {
+				return false, fmt.Sprintf("freeze until %s", f.end.Format(time.RFC3339))
+			}
+			return false, fmt.Sprintf("freeze until %s: %s", f.end.Format(time.RFC3339), f.reason)
+		}
+	}
+	if len(s.windows) == 0 {
+		return true, ""
+	}
+
+	// wall clock, so windows stay at the same local time when daylight saving time changes
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	today := now.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range s.windows {
+		switch {
+		case w.start < w.end:
+			open = w.days[today] && clock >= w.start && clock < w.end
+		case w.start > w.end: // runs past midnight
+			open = (w.days[today] && clock >= w.start) || (w.days[yesterday] && clock < w.end)
+		default: // the whole day
+			open = w.days[today]
+		}
+		if open {
+			return true, ""
+		}
+	}
+	return false, "outside of allowed windows"
+}
+
+func parseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use 15:04", value)
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// dates are in the location, end dates include the whole day
+func parseDate(value string, location *time.Location, end bool) (time.Time, error) {
+	if date, err := time.ParseInLocation("2006-01-02", value, location); err == nil {
+		if end {
+			return date.AddDate(0, 0, 1), nil
+		}
+		return date, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, use 2006-01-02 or RFC3339", value)
+	}
+	return timestamp, nil
+}
@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func schedule(t *testing.T, windows Windows) *Schedule {
+	schedule, err := NewSchedule(windows)
+	require.NoError(t, err)
+	return schedule
+}
+
+func isOpen(schedule *Schedule, at string) bool {
+	now, _ := time.Parse(time.RFC3339, at)
+	open, _ := schedule.IsOpen(now)
+	return open
+}
+
+func TestScheduleWithoutWindowsIsAlwaysOpen(t *testing.T) {
+	assert.True(t, isOpen(schedule(t, Windows{}), "2024-01-01T03:00:00Z"))
+}
+
+func TestScheduleOpensInAllowedWindows(t *testing.T) {
+	offHours := schedule(t, Windows{Allowed: []Window{
+		{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "20:00", End: "06:00"},
+		{Days: []string{"Sat", "sun"}, Start: "00:00", End: "00:00"},
+	}})
+
+	assert.False(t, isOpen(offHours, "2024-01-01T12:00:00Z"), "monday noon")
+	assert.True(t, isOpen(offHours, "2024-01-01T20:00:00Z"), "monday evening")
+	assert.True(t, isOpen(offHours, "2024-01-02T05:59:00Z"), "tuesday morning, window of monday")
+	assert.False(t, isOpen(offHours, "2024-01-02T06:00:00Z"), "end is not included")
+	assert.True(t, isOpen(offHours, "2024-01-06T12:00:00Z"), "saturday")
+	assert.True(t, isOpen(offHours, "2024-01-07T23:59:00Z"), "sunday night")
+	assert.False(t, isOpen(offHours, "2024-01-01T03:00:00Z"), "monday morning, window of sunday would be needed")
+}
+
+func TestScheduleUsesTimezone(t *testing.T) {
+	berlin := schedule(t, Windows{Timezone: "Europe/Berlin", Allowed: []Window{{Start: "09:00", End: "17:00"}}})
+
+	assert.True(t, isOpen(berlin, "2024-01-01T08:30:00Z"), "09:30 in winter")
+	assert.False(t, isOpen(berlin, "2024-07-01T15:30:00Z"), "17:30 in summer")
+	assert.True(t, isOpen(berlin, "2024-07-01T07:30:00Z"), "09:30 in summer")
+}
+
+func TestScheduleClosesDuringFreezes(t *testing.T) {
+	frozen := schedule(t, Windows{Timezone: "America/New_York", Freezes: []Freeze{
+		{Start: "2024-12-20", End: "2024-12-26", Reason: "holidays"},
+		{Start: "2024-11-05T12:00:00Z", End: "2024-11-05T14:00:00Z"},
+	}})
+
+	assert.False(t, isOpen(frozen, "2024-12-20T05:00:00Z"), "midnight in New York")
+	assert.True(t, isOpen(frozen, "2024-12-20T04:59:00Z"), "before midnight in New York")
+	assert.False(t, isOpen(frozen, "2024-12-27T04:59:00Z"), "end day is included")
+	assert.True(t, isOpen(frozen, "2024-12-27T05:00:00Z"))
+	assert.False(t, isOpen(frozen, "2024-11-05T13:00:00Z"))
+
+	now, _ := time.Parse(time.RFC3339, "2024-12-21T12:00:00Z")
+	_, reason := frozen.IsOpen(now)
+	assert.Equal(t, "freeze until 2024-12-27T00:00:00-05:00: holidays", reason)
+}
+
+func TestFreezesWinOverAllowedWindows(t *testing.T) {
+	frozen := schedule(t, Windows{
+		Allowed: []Window{{Start: "00:00", End: "00:00"}},
+		Freezes: []Freeze{{Start: "2024-01-01", End: "2024-01-01"}},
+	})
+	assert.False(t, isOpen(frozen, "2024-01-01T12:00:00Z"))
+	assert.True(t, isOpen(frozen, "2024-01-02T12:00:00Z"))
+}
+
+func TestWindowsValidate(t *testing.T) {
+	assert.NoError(t, Windows{}.Validate())
+	assert.Error(t, Windows{Timezone: "Mars/Olympus"}.Validate())
+	assert.Error(t, Windows{Allowed: []Window{{Start: "25:00", End: "06:00"}}}.Validate())
+	assert.Error(t, Windows{Allowed: []Window{{Start: "20:00", End: "6pm"}}}.Validate())
+	assert.Error(t, Windows{Allowed: []Window{{Days: []string{"monday"}, Start: "20:00", End: "06:00"}}}.Validate())
+	assert.Error(t, Windows{Freezes: []Freeze{{Start: "2024-12-26", End: "2024-12-20"}}}.Validate())
+	assert.Error(t, Windows{Freezes: []Freeze{{Start: "tomorrow", End: "2024-12-20"}}}.Validate())
+	assert.Error(t, Windows{Freezes: []Freeze{{Start: "2024-12-20", End: "next year"}}}.Validate())
+	assert.Error(t, RemediatorPolicy{Windows: Windows{Timezone: "Mars/Olympus"}}.Validate())
+	assert.Error(t, RemediatorPolicy{Remediators: map[string]RemediatorOverrides{
+		"OldPodDeleter": {Windows: &Windows{Timezone: "Mars/Olympus"}},
+	}}.Validate())
+}
+
+func TestReadRemediatorPolicyReadsWindows(t *testing.T) {
+	useConfig(t, `{
+		"windows": {"freezes": [{"start": "2024-12-20", "end": "2024-12-26", "reason": "holidays"}]},
+		"remediators": {"OldPodDeleter": {"windows": {"timezone": "Europe/Berlin", "allowed": [{"start": "20:00", "end": "06:00"}]}}}
+	}`)
+
+	policy, err := ReadRemediatorPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []Windows{
+		{Freezes: []Freeze{{Start: "2024-12-20", End: "2024-12-26", Reason: "holidays"}}},
+		{Timezone: "Europe/Berlin", Allowed: []Window{{Start: "20:00", End: "06:00"}}},
+	}, policy.WindowsFor(OldPodDeleterRemediator))
+}
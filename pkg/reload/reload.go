@@ -0,0 +1,80 @@
+package reload
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// a ConfigMap update is several events (new data dir, symlink swap, cleanup) so wait for it to settle
+var Debounce = 500 * time.Millisecond
+
+// Watch notifies when a file in one of the directories changes or on SIGHUP until ctx is done.
+// Directories are watched instead of files since mounted ConfigMaps replace files via symlinks.
+// Notifications are not queued, a pending notification covers all later changes.
+func Watch(ctx context.Context, wg *sync.WaitGroup, logger *zap.Logger, dirs ...string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	notify := func(reason string) {
+		logger.Info("Config changed", zap.String("reason", reason))
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	// nil channels block forever, so without a watcher we only reload on SIGHUP
+	var events chan fsnotify.Event
+	var errors chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error("Error watching config, only reloading on SIGHUP", zap.Error(err)) // untested section
+	} else {
+		for _, dir := range dirs {
+			if err := watcher.Add(dir); err != nil {
+				logger.Warn("Error watching config", zap.String("dir", dir), zap.Error(err))
+			}
+		}
+		events = watcher.Events
+		errors = watcher.Errors
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer wg.Done()
+		defer signal.Stop(hangup)
+		if watcher != nil {
+			defer watcher.Close()
+		}
+
+		settled := time.NewTimer(Debounce)
+		settled.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if ok {
+					logger.Debug("Config event", zap.String("event", event.String()))
+					settled.Reset(Debounce)
+				}
+			case err, ok := <-errors:
+				if ok { // untested section
+					logger.Warn("Error watching config", zap.Error(err)) // untested section
+				}
+			case <-settled.C:
+				notify("file changed")
+			case <-hangup:
+				notify("SIGHUP")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return changes
+}
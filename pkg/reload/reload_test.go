@@ -0,0 +1,107 @@
+package reload_test
+
+import (
+	"context"
+	"github.com/aksgithub/kube_remediator/pkg/reload"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type TestWatchSuite struct {
+	suite.Suite
+	logger *zap.Logger
+	dir    string
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+	t      *testing.T
+}
+
+func TestSuiteWatch(t *testing.T) {
+	suite.Run(t, &TestWatchSuite{t: t})
+}
+
+func (suite *TestWatchSuite) SetupTest() {
+	suite.logger, _ = zap.NewDevelopment()
+	suite.dir = suite.t.TempDir()
+	reload.Debounce = 10 * time.Millisecond
+	suite.ctx, suite.cancel = context.WithCancel(context.Background())
+}
+
+func (suite *TestWatchSuite) TearDownTest() {
+	suite.cancel()
+	suite.wg.Wait()
+}
+
+func (suite *TestWatchSuite) watch() <-chan struct{} {
+	suite.wg.Add(1)
+	return reload.Watch(suite.ctx, &suite.wg, suite.logger, suite.dir)
+}
+
+func (suite *TestWatchSuite) assertNotified(changes <-chan struct{}) {
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		suite.Fail("no change notification")
+	}
+}
+
+func (suite *TestWatchSuite) TestNotifiesWhenFileChanges() {
+	changes := suite.watch()
+	suite.NoError(os.WriteFile(filepath.Join(suite.dir, "policy.json"), []byte("{}"), 0644))
+	suite.assertNotified(changes)
+}
+
+func (suite *TestWatchSuite) TestCombinesBurstsOfChanges() {
+	changes := suite.watch()
+	for i := 0; i < 5; i++ {
+		suite.NoError(os.WriteFile(filepath.Join(suite.dir, "policy.json"), []byte("{}"), 0644))
+	}
+	suite.assertNotified(changes)
+
+	select {
+	case <-changes:
+		suite.Fail("notified more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (suite *TestWatchSuite) TestNotifiesOnSIGHUP() {
+	changes := suite.watch()
+	suite.NoError(syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	suite.assertNotified(changes)
+}
+
+func (suite *TestWatchSuite) TestIgnoresMissingDirectories() {
+	suite.dir = filepath.Join(suite.dir, "missing")
+	changes := suite.watch()
+	suite.NoError(syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	suite.assertNotified(changes)
+}
+
+func (suite *TestWatchSuite) TestKeepsASingleNotificationPending() {
+	var core zapcore.Core
+	var logs *observer.ObservedLogs
+	core, logs = observer.New(zap.InfoLevel)
+	suite.logger = zap.New(core)
+	changes := suite.watch()
+	suite.NoError(os.WriteFile(filepath.Join(suite.dir, "policy.json"), []byte("{}"), 0644))
+	suite.Eventually(func() bool { return logs.FilterMessage("Config changed").Len() == 1 }, 2*time.Second, time.Millisecond)
+	suite.NoError(syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	suite.Eventually(func() bool { return logs.FilterMessage("Config changed").Len() == 2 }, 2*time.Second, time.Millisecond)
+
+	suite.assertNotified(changes)
+	select {
+	case <-changes:
+		suite.Fail("notified more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,91 @@
+package remediator
+
+import (
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"strings"
+	"time"
+)
+
+// set to "approve" or "reject" on an object to decide on the remediations proposed for it
+const ApprovalAnnotation = optInPrefix + "approval"
+
+// the approved remediation of the object, "" with false when the action has to wait, proposing it when needed
+func (p *Base) checkApproval(object runtime.Object, accessor metav1.Object, kind string, action string, reason string) (string, bool) {
+	config := p.options.Approval
+	if p.options.Approvals == nil || !config.IsRequired(accessor.GetNamespace()) {
+		return "", true
+	}
+	proposal, created, err := p.options.Approvals.Propose(approval.Remediation{
+		Remediator: p.options.Name,
+		Action:     action,
+		Kind:       kind,
+		Namespace:  accessor.GetNamespace(),
+		Name:       accessor.GetName(),
+		UID:        accessor.GetUID(),
+		Reason:     reason,
+	}, config.Expiry)
+	if err != nil {
+		// acting without a recorded approval is never right, so it waits until proposals can be read again
+		p.logger.Error("Error proposing "+actionVerbs[action].doing+" "+kind+", waiting", append(objectInfo(accessor), zap.Error(err))...)
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultPending)
+		return "", false
+	}
+	logInfo := append(objectInfo(accessor), zap.String("id", proposal.ID), zap.String("reason", reason))
+	if created {
+		p.logger.Info("Approval required, proposing "+actionVerbs[action].doing+" "+kind, append(logInfo, zap.Time("expires", proposal.Expires))...)
+		p.recordRemediation(accessor, kind, action, metrics.ResultPending, reason)
+		p.recordEvent(object, v1.EventTypeNormal, EventReasonPending, fmt.Sprintf(
+			"%s needs approval before %s %s %s/%s: %s, approve with POST /approvals/%s/approve or annotation %s=approve until %s",
+			p.options.Name, strings.ToLower(actionVerbs[action].doing), kind, accessor.GetNamespace(), accessor.GetName(), reason,
+			proposal.ID, ApprovalAnnotation, proposal.Expires.UTC().Format(time.RFC3339)))
+	}
+
+	if decision := accessor.GetAnnotations()[ApprovalAnnotation]; proposal.State == approval.StatePending &&
+		(decision == "approve" || decision == "reject") {
+		if decided, err := p.options.Approvals.Decide(proposal.ID, decision == "approve", "annotation"); err == nil {
+			proposal = decided
+		}
+	}
+
+	switch proposal.State {
+	case approval.StateApproved:
+		p.logger.Info("Approved", append(logInfo, zap.String("by", proposal.DecidedBy))...)
+		return proposal.ID, true
+	case approval.StateRejected:
+		p.logger.Debug("Skipping rejected remediation", logInfo...)
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultRejected)
+	default:
+		p.logger.Debug("Waiting for approval", logInfo...)
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultPending)
+	}
+	return "", false
+}
+
+// acts on approvals right away, remediators that can check a single pod again set p.reconsider
+func (p *Base) subscribeToApprovals() func() {
+	if p.options.Approvals == nil || p.reconsider == nil {
+		return func() {}
+	}
+	pods := p.informers.Core().V1().Pods().Lister()
+	return p.options.Approvals.Subscribe(p.options.Name, func(remediation approval.Remediation) {
+		if remediation.Kind != "Pod" {
+			return // owners to restart are acted on when one of their pods comes up again
+		}
+		pod, err := pods.Pods(remediation.Namespace).Get(remediation.Name)
+		if err != nil || pod.UID != remediation.UID {
+			p.logger.Info("Approved pod is gone", zap.String("name", remediation.Name), zap.String("namespace", remediation.Namespace))
+			if err := p.options.Approvals.Complete(remediation.ID); err != nil {
+				p.logger.Error("Error completing approval", zap.String("id", remediation.ID), zap.Error(err))
+			}
+			return
+		}
+		// the remediator checks the pod still needs it and goes through mutate again, which takes the approval
+		p.reconsider(pod)
+	})
+}
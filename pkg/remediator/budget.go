@@ -0,0 +1,104 @@
+package remediator
+
+import (
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"math"
+	"sync"
+	"time"
+)
+
+// budgets are shared between remediators, taking from several at once has to be atomic
+var budgetLock sync.Mutex
+
+// actions that neither delete nor restart anything, so they do not use up budgets meant to limit disruption
+var unbudgetedActions = map[string]bool{"annotate": true, "notify": true}
+
+// token bucket that allows MaxActions at once and refills one action every Window / MaxActions
+type Budget struct {
+	scope     string
+	config    policy.Budget
+	remaining float64
+	refilled  time.Time
+}
+
+// nil when unlimited, a nil Budget allows everything
+func NewBudget(scope string, config policy.Budget) *Budget {
+	if config.IsUnlimited() {
+		return nil
+	}
+	return &Budget{
+		scope:     scope,
+		config:    config,
+		remaining: float64(config.MaxActions),
+		refilled:  time.Now(),
+	}
+}
+
+// like NewBudget, but what was used of previous stays used, so restarting a remediator or changing the config
+// does not refill its budget. Previous is returned as is when the config did not change.
+func carryOverBudget(previous *Budget, scope string, config policy.Budget) *Budget {
+	budget := NewBudget(scope, config)
+	if previous == nil || budget == nil {
+		return budget
+	}
+	budgetLock.Lock()
+	defer budgetLock.Unlock()
+
+	if previous.config == config {
+		return previous
+	}
+	previous.refill(budget.refilled)
+	used := float64(previous.config.MaxActions) - previous.remaining
+	budget.remaining = math.Max(0, budget.remaining-used)
+	return budget
+}
+
+func (b *Budget) refill(now time.Time) {
+	perAction := b.config.Window / time.Duration(b.config.MaxActions)
+	b.remaining = math.Min(float64(b.config.MaxActions), b.remaining+float64(now.Sub(b.refilled))/float64(perAction))
+	b.refilled = now
+}
+
+// takes one action from every budget or from none of them, returns the exhausted budget
+func takeBudget(budgets []*Budget) (*Budget, bool) {
+	budgetLock.Lock()
+	defer budgetLock.Unlock()
+
+	now := time.Now()
+	var exhausted *Budget
+	for _, budget := range budgets {
+		if budget == nil {
+			continue
+		}
+		budget.refill(now)
+		if exhausted == nil && budget.remaining < 1 {
+			exhausted = budget
+		}
+	}
+
+	for _, budget := range budgets {
+		if budget == nil {
+			continue
+		}
+		if exhausted == nil {
+			budget.remaining--
+		}
+		metrics.SetBudgetRemaining(budget.scope, budget.remaining)
+	}
+	return exhausted, exhausted == nil
+}
+
+// gives back an action that was taken but did not change anything
+func refundBudget(budgets []*Budget) {
+	budgetLock.Lock()
+	defer budgetLock.Unlock()
+
+	for _, budget := range budgets {
+		if budget == nil {
+			continue
+		}
+		budget.remaining = math.Min(float64(budget.config.MaxActions), budget.remaining+1)
+		metrics.SetBudgetRemaining(budget.scope, budget.remaining)
+	}
+}
@@ -0,0 +1,33 @@
+package remediator
+
+import (
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+func TestCarryOverKeepsBudgetsWithTheSameConfig(t *testing.T) {
+	config := policy.Budget{MaxActions: 2, Window: time.Hour}
+	budget := NewBudget("A", config)
+	assert.Assert(t, carryOverBudget(budget, "A", config) == budget)
+}
+
+func TestCarryOverKeepsWhatWasUsed(t *testing.T) {
+	budget := NewBudget("A", policy.Budget{MaxActions: 2, Window: 1000 * time.Hour})
+	_, ok := takeBudget([]*Budget{budget})
+	assert.Assert(t, ok)
+
+	bigger := carryOverBudget(budget, "A", policy.Budget{MaxActions: 5, Window: 1000 * time.Hour})
+	assert.Assert(t, bigger != budget)
+	assert.Assert(t, bigger.remaining >= 4 && bigger.remaining < 4.01)
+
+	smaller := carryOverBudget(bigger, "A", policy.Budget{MaxActions: 1, Window: 1000 * time.Hour})
+	assert.Assert(t, smaller.remaining < 0.01) // refilled a little since
+}
+
+func TestCarryOverStartsFullWithoutPreviousLimit(t *testing.T) {
+	budget := carryOverBudget(nil, "A", policy.Budget{MaxActions: 2, Window: time.Hour})
+	assert.Equal(t, budget.remaining, 2.0)
+	assert.Assert(t, carryOverBudget(budget, "A", policy.Budget{}) == nil)
+}
@@ -0,0 +1,234 @@
+package remediator
+
+import (
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often open circuit breakers check if they can close, since no candidates arrive once a storm is over
+var CircuitBreakerInterval = time.Minute
+
+// counts the candidates of a remediator in every scope they are in and pauses scopes with too many of them,
+// for example when a bad config push makes all pods of an image crash, deleting them over and over only adds churn.
+// A scope opens with more candidates than its limit in the window and closes again at half of it.
+type circuitBreaker struct {
+	config     policy.CircuitBreaker
+	lock       sync.Mutex
+	candidates map[string]candidate
+	open       map[string]bool
+}
+
+type candidate struct {
+	seen   time.Time
+	scopes []string
+}
+
+// scope that opened or closed, with the candidates it had then
+type breakerChange struct {
+	scope      string
+	candidates int
+	open       bool
+}
+
+// nil when disabled, a nil circuitBreaker never opens
+func newCircuitBreaker(config policy.CircuitBreaker) *circuitBreaker {
+	if config.IsDisabled() {
+		return nil
+	}
+	return &circuitBreaker{
+		config:     config,
+		candidates: map[string]candidate{},
+		open:       map[string]bool{},
+	}
+}
+
+// records the object as candidate and returns the open scope it is in, "" when it may be remediated
+func (b *circuitBreaker) observe(object metav1.Object, now time.Time) (string, []breakerChange) {
+	if b == nil {
+		return "", nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	scopes := breakerScopes(object)
+	key := string(object.GetUID())
+	if key == "" {
+		key = object.GetNamespace() + "/" + object.GetName()
+	}
+	// scopes whose storm is over close before the candidate counts, so it is not held back by the previous storm
+	changes := b.update(now)
+	b.candidates[key] = candidate{seen: now, scopes: scopes}
+	changes = append(changes, b.update(now)...)
+	for _, scope := range scopes {
+		if b.open[scope] {
+			return scope, changes
+		}
+	}
+	return "", changes
+}
+
+// forgets candidates that were not seen within the window and opens or closes scopes, needs the lock
+func (b *circuitBreaker) update(now time.Time) []breakerChange {
+	counts := map[string]int{}
+	for key, candidate := range b.candidates {
+		if now.Sub(candidate.seen) > b.config.Window {
+			delete(b.candidates, key)
+			continue
+		}
+		for _, scope := range candidate.scopes {
+			counts[scope]++
+		}
+	}
+
+	var changes []breakerChange
+	for scope, count := range counts {
+		if limit := b.limit(scope); !b.open[scope] && limit > 0 && count > limit {
+			b.open[scope] = true
+			changes = append(changes, breakerChange{scope: scope, candidates: count, open: true})
+		}
+	}
+	for scope := range b.open {
+		if counts[scope] <= b.limit(scope)/2 {
+			delete(b.open, scope)
+			changes = append(changes, breakerChange{scope: scope, candidates: counts[scope], open: false})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].scope < changes[j].scope })
+	return changes
+}
+
+// closes scopes once their storm is over, even when no candidates arrive anymore
+func (b *circuitBreaker) check(now time.Time) []breakerChange {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.update(now)
+}
+
+// scopes that are still open, for cleaning up their metrics
+func (b *circuitBreaker) openScopes() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var scopes []string
+	for scope := range b.open {
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+func (b *circuitBreaker) limit(scope string) int {
+	switch {
+	case scope == "cluster":
+		return b.config.MaxCluster
+	case strings.HasPrefix(scope, "namespace/"):
+		return b.config.MaxNamespace
+	default:
+		return b.config.MaxShared
+	}
+}
+
+// cluster, namespace/<namespace> and for pods also image/<image>, configmap/<namespace>/<name> and node/<node>
+func breakerScopes(object metav1.Object) []string {
+	scopes := []string{"cluster", "namespace/" + object.GetNamespace()}
+	pod, ok := object.(*v1.Pod)
+	if !ok {
+		return scopes
+	}
+
+	seen := map[string]bool{}
+	add := func(scope string) {
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	configMap := func(name string) { add("configmap/" + pod.ObjectMeta.Namespace + "/" + name) }
+	for _, container := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		add("image/" + container.Image)
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				configMap(envFrom.ConfigMapRef.Name)
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
+				configMap(env.ValueFrom.ConfigMapKeyRef.Name)
+			}
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.ConfigMap != nil {
+			configMap(volume.ConfigMap.Name)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					configMap(source.ConfigMap.Name)
+				}
+			}
+		}
+	}
+	if pod.Spec.NodeName != "" {
+		add("node/" + pod.Spec.NodeName)
+	}
+	return scopes
+}
+
+// false while the circuit breaker holds back the object, candidates that trip it get a warning event
+func (p *Base) passesCircuitBreaker(object runtime.Object, accessor metav1.Object) bool {
+	scope, changes := p.breaker.observe(accessor, time.Now())
+	for _, change := range changes {
+		p.reportBreakerChange(change)
+		if change.open {
+			p.recordEvent(object, v1.EventTypeWarning, EventReasonCircuitBreakerOpen, fmt.Sprintf(
+				"%s paused remediation of %s: %d candidates within %s, remediation resumes once they are back to %d",
+				p.options.Name, change.scope, change.candidates, p.options.CircuitBreaker.Window, p.breaker.limit(change.scope)/2))
+		}
+	}
+	return scope == ""
+}
+
+// reports circuit breakers that closed, until stop is closed
+func (p *Base) watchCircuitBreaker(stop <-chan struct{}) {
+	if p.breaker == nil {
+		return
+	}
+	ticker := time.NewTicker(CircuitBreakerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, change := range p.breaker.check(time.Now()) {
+				p.reportBreakerChange(change)
+			}
+		case <-stop:
+			for _, scope := range p.breaker.openScopes() {
+				metrics.DeleteCircuitBreakerOpen(p.options.Name, scope)
+			}
+			return
+		}
+	}
+}
+
+func (p *Base) reportBreakerChange(change breakerChange) {
+	logInfo := []zap.Field{
+		zap.String("scope", change.scope),
+		zap.Int("candidates", change.candidates),
+		zap.Duration("window", p.options.CircuitBreaker.Window),
+	}
+	if change.open {
+		p.logger.Error("Circuit breaker open, pausing remediation", logInfo...)
+		metrics.SetCircuitBreakerOpen(p.options.Name, change.scope)
+	} else {
+		p.logger.Info("Circuit breaker closed, resuming remediation", logInfo...)
+		metrics.DeleteCircuitBreakerOpen(p.options.Name, change.scope)
+	}
+}
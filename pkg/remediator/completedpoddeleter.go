@@ -2,38 +2,102 @@ package remediator
 
 import (
 	"context"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"sync"
 	"time"
 )
 
+var CompletedPodDeleterConfigFile = "config/completed_pod_deleter.json"
+
+var completedPodDeleterDefaults = withPeriodicDefaults(map[string]interface{}{
+	"minAge": "24h",
+})
+
 type CompletedPodDeleter struct {
 	Base
+	pods   coreinformers.PodInformer
+	period period
+	minAge time.Duration // namespaces can override it
+}
+
+func init() {
+	Register(Registration{
+		Name:       "CompletedPodDeleter",
+		New:        func() BaseIntf { return &CompletedPodDeleter{} },
+		Defaults:   completedPodDeleterDefaults,
+		ConfigFile: func() string { return CompletedPodDeleterConfigFile },
+		Rules:      append(podRules("list", "watch", "delete"), lastRunsRule),
+	})
+}
+
+func (p *CompletedPodDeleter) LoadConfig(logger *zap.Logger) error {
+	return readConfig(logger, CompletedPodDeleterConfigFile, completedPodDeleterDefaults, func(config *viper.Viper) (err error) {
+		if p.period, err = newPeriod(config); err != nil {
+			return err
+		}
+		if p.minAge, err = getDuration(config, "minAge"); err != nil {
+			return err
+		}
+		if p.minAge < 0 {
+			return fmt.Errorf("minAge can not be negative, got %q", config.GetString("minAge"))
+		}
+		return nil
+	})
+}
+
+func (p *CompletedPodDeleter) Setup(logger *zap.Logger, client k8s.ClientInterface) error {
+	if err := p.LoadConfig(logger); err != nil {
+		return err
+	}
+	if err := p.Base.Setup(logger, client); err != nil {
+		return err // untested section
+	}
+	p.pods = p.informers.Core().V1().Pods()
+	p.pods.Informer() // request it before the shared factory is started
+	p.reconsider = p.deleteIfCompleted
+	return nil
 }
 
 func (p *CompletedPodDeleter) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	p.reconcileEvery(ctx, p.deleteCompletedPods, 1*time.Hour)
+	if p.waitForCacheSync(ctx, p.pods) {
+		p.reconcileOnSchedule(ctx, p.deleteCompletedPods, p.period)
+	}
+}
+
+func (p *CompletedPodDeleter) periodic() {} // untested section
+
+func (p *CompletedPodDeleter) Reconcile() error {
+	return p.deleteCompletedPods()
 }
 
-func (p *CompletedPodDeleter) deleteCompletedPods() {
+func (p *CompletedPodDeleter) deleteCompletedPods() error {
 	p.logger.Info("Running")
 
 	// get completed pods
-	pods, err := p.client.GetPods("", metav1.ListOptions{FieldSelector: "status.phase=Succeeded"})
+	pods, err := p.pods.Lister().List(labels.Everything())
 	if err != nil {
-		p.logger.Error("Error getting pod list: ", zap.Error(err))
-		return
+		return fmt.Errorf("getting pod list: %w", err) // untested section
 	}
 
-	// delete those that are too old (could delete pods that ran a long time early, but good enough for now)
-	cutoff := time.Now().Add(-24 * time.Hour)
-	for _, pod := range pods.Items {
-		if pod.ObjectMeta.CreationTimestamp.Time.After(cutoff) {
-			continue
-		}
-		p.deletePod(pod)
+	for _, pod := range pods {
+		p.deleteIfCompleted(pod)
+	}
+	return nil
+}
+
+// deletes the pod when it is too old (could delete pods that ran a long time early, but good enough for now)
+func (p *CompletedPodDeleter) deleteIfCompleted(pod *v1.Pod) {
+	cutoff := time.Now().Add(-p.options.Namespaces.MinAge(p.options.Name, pod.ObjectMeta.Namespace, p.minAge))
+	if pod.Status.Phase != v1.PodSucceeded || pod.ObjectMeta.CreationTimestamp.Time.After(cutoff) {
+		return
 	}
+	p.removePod(*pod, fmt.Sprintf("Completed age=%s", time.Since(pod.ObjectMeta.CreationTimestamp.Time).Round(time.Minute)))
 }
@@ -2,15 +2,29 @@ package remediator_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
+	"github.com/aksgithub/kube_remediator/pkg/history"
 	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/notify"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
 	"github.com/aksgithub/kube_remediator/pkg/remediator"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
 	"gotest.tools/assert"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
@@ -22,6 +36,8 @@ type TestCompletedPodDeleterSuite struct {
 	mockController *gomock.Controller
 	mockClient     *mock_k8s.MockClientInterface
 	pods           []corev1.Pod
+	objects        []runtime.Object // namespaces and owners of the pods
+	options        remediator.Options
 	t              *testing.T
 }
 
@@ -30,9 +46,12 @@ func TestSuitecompletedPodDeleter(t *testing.T) {
 }
 
 func (suite *TestCompletedPodDeleterSuite) SetupTest() {
+	remediator.CompletedPodDeleterConfigFile = "../../config/completed_pod_deleter.json"
 	suite.logger, _ = zap.NewDevelopment()
 	suite.mockController = gomock.NewController(suite.t)
 	suite.mockClient = mock_k8s.NewMockClientInterface(suite.mockController)
+	suite.options = remediator.Options{Name: "CompletedPodDeleter"}
+	suite.objects = nil
 	suite.pods = []corev1.Pod{{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              "foo",
@@ -45,14 +64,34 @@ func (suite *TestCompletedPodDeleterSuite) SetupTest() {
 	}}
 }
 
+func (suite *TestCompletedPodDeleterSuite) addPod(name string) {
+	pod := suite.pods[0]
+	pod.ObjectMeta.Name = name
+	suite.pods = append(suite.pods, pod)
+}
+
 func (suite *TestCompletedPodDeleterSuite) TeardownTest() {
 	suite.mockController.Finish()
 }
 
+// factories with the current pods and objects, started with startInformers after Setup
+func (suite *TestCompletedPodDeleterSuite) newInformerFactories() (informers.SharedInformerFactory, metadatainformer.SharedInformerFactory) {
+	objects := suite.objects
+	for i := range suite.pods {
+		objects = append(objects, &suite.pods[i])
+	}
+	return newInformerFactories(objects...)
+}
+
 func (suite *TestCompletedPodDeleterSuite) run() {
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
 	completedPodDeleter := remediator.CompletedPodDeleter{}
+	completedPodDeleter.Configure(suite.options)
 	err := completedPodDeleter.Setup(suite.logger, suite.mockClient)
 	assert.Equal(suite.t, err, nil)
+	startInformers(suite.T(), factory, metadataFactory)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // cancel first so we can just run once and exit
@@ -64,24 +103,334 @@ func (suite *TestCompletedPodDeleterSuite) run() {
 }
 
 func (suite *TestCompletedPodDeleterSuite) TestDeleteCompletedPods() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
 	suite.run()
 }
 
 func (suite *TestCompletedPodDeleterSuite) TestKeepsNewPods() {
 	suite.pods[0].ObjectMeta.CreationTimestamp = metav1.NewTime(time.Now().Add(-23 * time.Hour))
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.run()
 }
 
-func (suite *TestCompletedPodDeleterSuite) TestDoesNotCrashWhenListFails() {
-	suite.mockClient.EXPECT().GetPods("").Return(nil, errors.New("Foo"))
+func (suite *TestCompletedPodDeleterSuite) TestKeepsRunningPods() {
+	suite.pods[0].Status.Phase = "Running"
 	suite.run()
 }
 
 func (suite *TestCompletedPodDeleterSuite) TestDoesNotCrashWhenDeleteFails() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(errors.New("Foo"))
 	suite.run()
 }
+
+func (suite *TestCompletedPodDeleterSuite) TestStopsDeletingWhenBudgetIsExhausted() {
+	suite.options.Budgets = []*remediator.Budget{
+		remediator.NewBudget("CompletedPodDeleter", policy.Budget{MaxActions: 1, Window: time.Hour}),
+	}
+	suite.addPod("bar")
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).Return(nil).Times(1)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestDoesNotSpendBudgetsWhenAnyIsExhausted() {
+	remediatorBudget := remediator.NewBudget("CompletedPodDeleter", policy.Budget{MaxActions: 2, Window: time.Hour})
+	globalBudget := remediator.NewBudget("global", policy.Budget{MaxActions: 1, Window: time.Hour})
+	suite.options.Budgets = []*remediator.Budget{remediatorBudget, globalBudget}
+	suite.addPod("bar")
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).Return(nil).Times(1)
+	suite.run()
+
+	// remediator budget still has 1 action left since the global one blocked the second delete
+	suite.options.Budgets = []*remediator.Budget{remediatorBudget}
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).Return(nil).Times(1)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestDoesNotSpendBudgetWhenDeleteFails() {
+	suite.options.Budgets = []*remediator.Budget{
+		remediator.NewBudget("CompletedPodDeleter", policy.Budget{MaxActions: 1, Window: time.Hour}),
+	}
+	suite.addPod("bar")
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).Return(errors.New("Foo")).Times(2)
+	suite.run()
+
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).Return(nil).Times(1)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestIgnoresBudgetInDryRun() {
+	suite.options.DryRun = true
+	suite.options.Budgets = []*remediator.Budget{
+		remediator.NewBudget("CompletedPodDeleter", policy.Budget{MaxActions: 1, Window: time.Hour}),
+	}
+	suite.addPod("bar")
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestBudgetRefillsOverTime() {
+	suite.options.Budgets = []*remediator.Budget{
+		remediator.NewBudget("CompletedPodDeleter", policy.Budget{MaxActions: 1, Window: 10 * time.Millisecond}),
+	}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil).Times(2)
+	suite.run()
+	time.Sleep(20 * time.Millisecond)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) namespacePolicy(settings policy.NamespaceRemediatorSettings) {
+	suite.options.Namespaces = policy.NewNamespacePolicies(nil)
+	suite.options.Namespaces.Set(policy.RemediationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"},
+		Spec: policy.RemediationPolicySpec{
+			Remediators: map[string]policy.NamespaceRemediatorSettings{"CompletedPodDeleter": settings},
+		},
+	})
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsPodsWhenDisabledForNamespace() {
+	disabled := false
+	suite.namespacePolicy(policy.NamespaceRemediatorSettings{Enabled: &disabled})
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestUsesMinAgeOfNamespace() {
+	suite.namespacePolicy(policy.NamespaceRemediatorSettings{MinAge: &metav1.Duration{Duration: 48 * time.Hour}})
+	suite.run()
+
+	suite.pods[0].ObjectMeta.CreationTimestamp = metav1.NewTime(time.Now().Add(-49 * time.Hour))
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsPodsOptedOutByNamespace() {
+	suite.options.Namespaces = policy.NewNamespacePolicies(nil)
+	suite.options.Namespaces.Set(policy.RemediationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"},
+		Spec: policy.RemediationPolicySpec{
+			OptOutSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+		},
+	})
+	suite.pods[0].ObjectMeta.Labels = map[string]string{"app": "db"}
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) ownedByCronJob(annotations map[string]string) {
+	isController := true
+	suite.pods[0].ObjectMeta.OwnerReferences = []metav1.OwnerReference{{Kind: "Job", Name: "backup-1", Controller: &isController}}
+	suite.objects = append(suite.objects,
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:            "backup-1",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: "backup", Controller: &isController}},
+		}},
+		&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default", Annotations: annotations}},
+	)
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsPodsOfOptedOutNamespaces() {
+	suite.objects = append(suite.objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "default",
+		Annotations: map[string]string{"kube-remediator/CompletedPodDeleter": "false"},
+	}})
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsPodsOfOptedOutOwners() {
+	suite.ownedByCronJob(map[string]string{"kube-remediator/CompletedPodDeleter": "false"})
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestOwnersOptInOverNamespace() {
+	suite.ownedByCronJob(map[string]string{"kube-remediator/CompletedPodDeleter": "true"})
+	suite.objects = append(suite.objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "default",
+		Labels: map[string]string{"kube-remediator/CompletedPodDeleter": "false"},
+	}})
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestPodOptOutOverOwner() {
+	suite.ownedByCronJob(map[string]string{"kube-remediator/CompletedPodDeleter": "true"})
+	suite.pods[0].ObjectMeta.Annotations = map[string]string{"kube-remediator/CompletedPodDeleter": "false"}
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsPodsInExcludedNamespaces() {
+	suite.options.Scopes = []policy.Scope{{ExcludeNamespaces: []string{"kube-*", "def*"}}}
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestOnlyDeletesPodsInIncludedNamespaces() {
+	suite.addPod("bar")
+	suite.pods[1].ObjectMeta.Namespace = "team-a"
+	suite.options.Scopes = []policy.Scope{{Namespaces: []string{"team-*"}}}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[1]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsPodsNotMatchingPodSelector() {
+	suite.pods[0].ObjectMeta.Labels = map[string]string{"app": "db"}
+	suite.options.Scopes = []policy.Scope{{PodSelector: "app!=db"}}
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestUsesNamespaceSelector() {
+	suite.objects = append(suite.objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "default",
+		Labels: map[string]string{"env": "dev"},
+	}})
+	suite.options.Scopes = []policy.Scope{{NamespaceSelector: "env=prod"}}
+	suite.run()
+
+	suite.options.Scopes = []policy.Scope{{NamespaceSelector: "env=dev"}}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsPodsWithExcludedPriorityClass() {
+	suite.pods[0].Spec.PriorityClassName = "system-node-critical"
+	suite.options.Scopes = []policy.Scope{{ExcludePriorityClasses: []string{"system-node-critical"}}}
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestNeedsAllScopes() {
+	suite.options.Scopes = []policy.Scope{{Namespaces: []string{"default"}}, {ExcludeNamespaces: []string{"default"}}}
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsItself() {
+	suite.options.Self = types.NamespacedName{Namespace: "default", Name: "foo"}
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestDeletesPodsWhenItsOwnPodIsUnknown() {
+	suite.options.Self = types.NamespacedName{Namespace: "default", Name: "self"}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestKeepsReplicasOfItself() {
+	isController := true
+	suite.objects = append(suite.objects,
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            "kube-remediator-1",
+			Namespace:       "default",
+			UID:             "replica-set",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "kube-remediator", Controller: &isController}},
+		}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "kube-remediator", Namespace: "default", UID: "deployment"}},
+	)
+	suite.pods[0].ObjectMeta.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "kube-remediator-1", Controller: &isController}}
+	suite.addPod("self")
+	suite.addPod("other")
+	suite.pods[2].ObjectMeta.OwnerReferences = nil
+	suite.options.Self = types.NamespacedName{Namespace: "default", Name: "self"}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[2]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestDefersOutsideOfWindows() {
+	today := time.Now().UTC().Format("2006-01-02")
+	suite.options.Windows = []policy.Windows{{}, {Freezes: []policy.Freeze{{Start: today, End: today, Reason: "launch"}}}}
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestDefersDryRunOutsideOfWindows() {
+	store, err := history.NewStore(10, "")
+	assert.NilError(suite.t, err)
+	suite.options.History = store
+	suite.options.DryRun = true
+	otherDay := time.Now().UTC().Add(48 * time.Hour).Weekday().String()[:3]
+	suite.options.Windows = []policy.Windows{{Allowed: []policy.Window{{Days: []string{otherDay}, Start: "00:00", End: "00:00"}}}}
+	suite.run()
+	assert.Equal(suite.t, len(store.List(history.Filter{})), 0)
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestDeletesInsideOfWindows() {
+	suite.options.Windows = []policy.Windows{{Allowed: []policy.Window{{Start: "00:00", End: "00:00"}}}}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestNotifiesOncePerRun() {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+	notifier, err := notify.NewNotifier(suite.logger, policy.Notifications{
+		TeamLabel: "team",
+		Routes:    []policy.NotificationRoute{{Name: "all", Webhook: server.URL}},
+	})
+	assert.NilError(suite.t, err)
+	suite.options.Notifier = notifier
+	suite.addPod("bar")
+	suite.ownedByCronJob(nil)
+	suite.objects[1].(*batchv1.CronJob).Labels = map[string]string{"team": "backup"}
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).Return(nil).Times(2)
+	suite.run()
+
+	assert.Equal(suite.t, len(bodies), 1)
+	var batch notify.Batch
+	assert.NilError(suite.t, json.Unmarshal([]byte(bodies[0]), &batch))
+	assert.Equal(suite.t, batch.Summary, "CompletedPodDeleter: 2 delete")
+	teams := map[string]string{}
+	for _, remediation := range batch.Remediations {
+		teams[remediation.Name] = remediation.Team
+	}
+	assert.DeepEqual(suite.t, teams, map[string]string{"foo": "backup", "bar": ""})
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestOnlyCachesMetadataOfOwnersAndNamespaces() {
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	completedPodDeleter := remediator.CompletedPodDeleter{}
+	completedPodDeleter.Configure(suite.options)
+	assert.NilError(suite.t, completedPodDeleter.Setup(suite.logger, suite.mockClient))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+	var cached []string
+	for informer := range factory.WaitForCacheSync(stop) {
+		cached = append(cached, informer.String())
+	}
+	assert.DeepEqual(suite.t, cached, []string{"*v1.Pod"})
+	metadataFactory.Start(stop)
+	assert.Equal(suite.t, len(metadataFactory.WaitForCacheSync(stop)), 7) // namespaces and controlling owners
+}
+
+func (suite *TestCompletedPodDeleterSuite) TestDeletesPodOnceApprovedWithoutWaitingForTheNextRun() {
+	approvals := approval.NewStore()
+	suite.options.Approval = policy.Approval{Required: true, Expiry: time.Hour}
+	suite.options.Approvals = approvals
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).DoAndReturn(func(*corev1.Pod) error {
+		cancel() // stop once the approved pod is deleted
+		return nil
+	})
+	completedPodDeleter := remediator.CompletedPodDeleter{}
+	completedPodDeleter.Configure(suite.options)
+	assert.NilError(suite.t, completedPodDeleter.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go completedPodDeleter.Run(ctx, &wg)
+
+	var pending []approval.Remediation
+	for len(pending) == 0 {
+		time.Sleep(time.Millisecond)
+		pending, _ = approvals.List(approval.Filter{State: approval.StatePending})
+	}
+	_, err := approvals.Decide(pending[0].ID, true, "alice")
+	assert.NilError(suite.t, err)
+	approvals.Check() // like approving on another replica, the next run is an hour away
+
+	wg.Wait()
+}
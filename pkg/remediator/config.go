@@ -0,0 +1,151 @@
+package remediator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// remediators with a config file read it here instead of in Setup, so it can be checked without a cluster
+type ConfigLoader interface {
+	LoadConfig(*zap.Logger) error
+}
+
+// ValidateConfig reads the config file of the registration like Setup does, errors name the file and the field
+func ValidateConfig(registration Registration) error {
+	loader, ok := registration.New().(ConfigLoader)
+	if !ok {
+		return nil
+	}
+	return loader.LoadConfig(zap.NewNop())
+}
+
+// reads the json config file of a remediator into its own instance, so it does not interfere with the policy,
+// and hands it to parse. A missing file uses the defaults, so ConfigMaps only need the files they change.
+// Fields without a default and values of another type than their default are errors, all errors name the file.
+func readConfig(logger *zap.Logger, file string, defaults map[string]interface{}, parse func(*viper.Viper) error) error {
+	logger.Info("Reading config", zap.String("file", file))
+	config := viper.New()
+	config.SetConfigFile(file)
+	config.SetConfigType("json")
+	for key, value := range defaults {
+		config.SetDefault(key, value)
+	}
+	if err := config.ReadInConfig(); errors.Is(err, fs.ErrNotExist) {
+		logger.Info("Config file not found, using defaults", zap.String("file", file))
+	} else if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	} else if err := checkFields(file, defaults); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	logger.Sugar().Infof("Config %v", config.AllSettings())
+	if err := parse(config); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// viper ignores fields it does not know and falls back to defaults for values it cannot convert,
+// so typos like failureTreshold would silently do nothing
+func checkFields(file string, defaults map[string]interface{}) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err // untested section
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return err // untested section
+	}
+	return checkFieldTypes("", fields, defaults)
+}
+
+// fields need a default with the same type, names are case-insensitive like in viper
+func checkFieldTypes(prefix string, fields map[string]interface{}, defaults map[string]interface{}) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		field := prefix + name
+		def, found := lookupFold(defaults, name)
+		if !found {
+			errs = append(errs, fmt.Errorf("unknown field %q", field))
+			continue
+		}
+
+		value := fields[name]
+		var ok bool
+		var expected string
+		switch def := def.(type) {
+		case map[string]interface{}:
+			var nested map[string]interface{}
+			if nested, ok = value.(map[string]interface{}); ok {
+				if err := checkFieldTypes(field+".", nested, def); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			expected = "an object"
+		case string:
+			_, ok = value.(string)
+			expected = "a string"
+		case bool:
+			_, ok = value.(bool)
+			expected = "true or false"
+		case int, int32, int64:
+			number, isNumber := value.(float64)
+			ok, expected = isNumber && number == math.Trunc(number), "a whole number"
+		case []interface{}:
+			_, ok = value.([]interface{})
+			expected = "a list"
+		default:
+			ok = true // untested section
+		}
+		if !ok {
+			actual, _ := json.Marshal(value)
+			errs = append(errs, fmt.Errorf("%s needs to be %s, got %s", field, expected, actual))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func lookupFold(values map[string]interface{}, name string) (interface{}, bool) {
+	for key, value := range values {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// like config.GetDuration, but fails on values that are no durations instead of returning 0
+func getDuration(config *viper.Viper, key string) (time.Duration, error) {
+	duration, err := cast.ToDurationE(config.Get(key))
+	if err != nil {
+		return 0, fmt.Errorf("%s needs to be a duration like 24h, got %q", key, config.GetString(key))
+	}
+	return duration, nil
+}
+
+// namespace fields of remediator configs, empty means all namespaces
+func validateNamespace(key string, namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	if err := policy.ValidateNamespace(namespace); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
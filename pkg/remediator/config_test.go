@@ -0,0 +1,18 @@
+package remediator
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestCheckFieldTypesChecksNestedFieldsAndBools(t *testing.T) {
+	defaults := map[string]interface{}{
+		"enabled": false,
+		"nested":  map[string]interface{}{"enabled": true},
+	}
+	assert.NilError(t, checkFieldTypes("", map[string]interface{}{"Enabled": true, "nested": map[string]interface{}{"enabled": false}}, defaults))
+
+	err := checkFieldTypes("", map[string]interface{}{"enabled": "yes", "nested": map[string]interface{}{"enabeld": true}}, defaults)
+	assert.ErrorContains(t, err, `enabled needs to be true or false, got "yes"`)
+	assert.ErrorContains(t, err, `unknown field "nested.enabeld"`)
+}
@@ -2,13 +2,15 @@ package remediator
 
 import (
 	"context"
+	"fmt"
 	"github.com/aksgithub/kube_remediator/pkg/k8s"
 	"github.com/aksgithub/kube_remediator/pkg/metrics"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/informers"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"sync"
 )
@@ -17,6 +19,13 @@ import (
 // still not be here
 var CONFIG_FILE = "config/crash_loop_back_off_rescheduler.json"
 
+var crashLoopBackOffReschedulerDefaults = map[string]interface{}{
+	"annotation":       "kube-remediator/CrashLoopBackOffRemediator",
+	"failureThreshold": 5,
+	"namespace":        "",
+	"flapProtection":   flapProtectionDefaults,
+}
+
 type PodFilter struct {
 	annotation       string
 	failureThreshold int32
@@ -25,109 +34,163 @@ type PodFilter struct {
 
 type CrashLoopBackOffRescheduler struct {
 	Base
-	filter          PodFilter
-	informerFactory informers.SharedInformerFactory
-	metrics         *metrics.CrashLoopBackOff_Metrics
+	filter  PodFilter
+	pods    coreinformers.PodInformer
+	metrics *metrics.CrashLoopBackOff_Metrics
 }
 
-func (p *CrashLoopBackOffRescheduler) Setup(logger *zap.Logger, client k8s.ClientInterface) error {
-	logger.Info("Reading config", zap.String("file", CONFIG_FILE))
-	viper.SetConfigFile(CONFIG_FILE)
-	viper.SetConfigType("json")
-	viper.SetDefault("annotation", "kube-remediator/CrashLoopBackOffRemediator")
-	viper.SetDefault("failureThreshold", 5)
-	viper.SetDefault("namespace", "")
-
-	if err := viper.ReadInConfig(); err != nil {
-		return err // untested section
-	}
+func init() {
+	Register(Registration{
+		Name:       "CrashLoopBackOffRescheduler",
+		New:        func() BaseIntf { return &CrashLoopBackOffRescheduler{} },
+		Defaults:   crashLoopBackOffReschedulerDefaults,
+		ConfigFile: func() string { return CONFIG_FILE },
+		Rules: append(podRules("list", "watch", "delete"),
+			rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"replicasets", "deployments", "statefulsets", "daemonsets"}, Verbs: []string{"patch"}},
+			rbacv1.PolicyRule{APIGroups: []string{"batch"}, Resources: []string{"jobs", "cronjobs"}, Verbs: []string{"patch"}},
+		),
+	})
+}
 
-	logger.Sugar().Infof("Config %v", viper.AllSettings()) // TODO: prefer using zap.Map or something like that
-	filter := PodFilter{
-		annotation:       viper.GetString("annotation"),
-		failureThreshold: viper.GetInt32("failureThreshold"),
-		namespace:        viper.GetString("namespace"),
-	}
+func (p *CrashLoopBackOffRescheduler) LoadConfig(logger *zap.Logger) error {
+	return readConfig(logger, CONFIG_FILE, crashLoopBackOffReschedulerDefaults, func(config *viper.Viper) (err error) {
+		p.filter = PodFilter{
+			annotation:       config.GetString("annotation"),
+			failureThreshold: config.GetInt32("failureThreshold"),
+			namespace:        config.GetString("namespace"),
+		}
+		if p.filter.failureThreshold <= 0 {
+			return fmt.Errorf("failureThreshold needs to be positive, got %d", p.filter.failureThreshold)
+		}
+		if err := validateNamespace("namespace", p.filter.namespace); err != nil {
+			return err
+		}
+		p.flaps, err = newFlapProtection(config)
+		return err
+	})
+}
 
+func (p *CrashLoopBackOffRescheduler) Setup(logger *zap.Logger, client k8s.ClientInterface) error {
+	if err := p.LoadConfig(logger); err != nil {
+		return err
+	}
 	metrics := metrics.NewCrashLoopBackOffMetrics(logger)
-	metrics.Register()
 
-	informerFactory, err := client.NewSharedInformerFactory(filter.namespace)
-	if err != nil {
+	if err := p.Base.Setup(logger, client); err != nil {
 		return err // untested section
 	}
-	p.informerFactory = informerFactory
-	p.filter = filter
+	if p.filter.annotation != "" {
+		p.optInAliases = []string{p.filter.annotation}
+	}
+	p.pods = p.informers.Core().V1().Pods()
+	p.pods.Informer() // request it before the shared factory is started
+	p.restarts = p.newOwnerRestarts(p.pods, p.shouldReschedule)
+	p.reconsider = func(pod *v1.Pod) { p.rescheduleIfNecessary(nil, pod) }
 	p.metrics = metrics
-	p.logger = logger
-	p.client = client
 	return nil
 }
 
 func (p *CrashLoopBackOffRescheduler) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	p.logStartAndStop(func() {
-		// Check for any CrashLoopBackOff Pods first
-		p.reschedulePods()
-
-		informer := p.informerFactory.Core().V1().Pods().Informer()
+	if !p.waitForCacheSync(ctx, p.pods) {
+		return
+	}
 
-		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-			UpdateFunc: p.rescheduleIfNecessary,
-		})
-		informer.Run(ctx.Done())
+	// registered here instead of in Setup, so a restarted remediator can be set up while the old one still runs
+	p.metrics.Register()
 
-		<-ctx.Done()
+	p.logStartAndStop(func() {
+		// Check for any CrashLoopBackOff Pods first
+		if p.reconcileUntilSuccess(ctx, p.reschedulePods) {
+			p.handlePodUpdates(ctx, p.pods, cache.ResourceEventHandlerFuncs{
+				UpdateFunc: p.rescheduleIfNecessary,
+			})
+		}
 		p.metrics.UnRegister()
 	})
 }
 
-func (p *CrashLoopBackOffRescheduler) reschedulePods() {
+func (p *CrashLoopBackOffRescheduler) Reconcile() error {
+	return p.reschedulePods()
+}
+
+func (p *CrashLoopBackOffRescheduler) reschedulePods() error {
 	p.logger.Info("Running")
-	for _, pod := range *p.getCrashLoopBackOffPods() {
+	pods, err := p.getCrashLoopBackOffPods()
+	if err != nil {
+		return err // untested section
+	}
+	for _, pod := range *pods {
 		p.rescheduleIfNecessary(nil, &pod)
 	}
+	return nil
 }
 
 func (p *CrashLoopBackOffRescheduler) rescheduleIfNecessary(oldObj, newObj interface{}) {
 	pod := newObj.(*v1.Pod)
-	if p.shouldReschedule(pod) {
-		p.deletePod(*pod)
+	if p.filter.namespace != "" && pod.ObjectMeta.Namespace != p.filter.namespace {
+		return // the shared informer watches all namespaces
+	}
+	if !p.shouldReschedule(pod) {
+		return
+	}
+	// replacements that keep crashing are left to humans
+	if owner, allowed := p.checkFlapProtection(pod); allowed && p.removePod(*pod, p.unhealthyReason(pod)) {
+		p.recordAttempt(owner)
 	}
 }
 
-func (p *CrashLoopBackOffRescheduler) getCrashLoopBackOffPods() *[]v1.Pod {
-	pods, err := p.client.GetPods(p.filter.namespace, metav1.ListOptions{})
+// listed from the shared informer instead of the api, sorted so pods are remediated in a stable order
+func (p *CrashLoopBackOffRescheduler) getCrashLoopBackOffPods() (*[]v1.Pod, error) {
+	var pods []*v1.Pod
+	var err error
+	if p.filter.namespace == "" {
+		pods, err = p.pods.Lister().List(labels.Everything())
+	} else {
+		pods, err = p.pods.Lister().Pods(p.filter.namespace).List(labels.Everything())
+	}
 	if err != nil {
-		p.logger.Error("Error getting pod list: ", zap.Error(err))
-		return &[]v1.Pod{}
+		return nil, fmt.Errorf("getting pod list: %w", err) // untested section
 	}
 	var unhealthyPods []v1.Pod
-	for _, pod := range pods.Items {
-		if p.shouldReschedule(&pod) {
-			unhealthyPods = append(unhealthyPods, pod)
+	for _, pod := range sortedPods(pods) {
+		if p.shouldReschedule(pod) {
+			unhealthyPods = append(unhealthyPods, *pod)
 		}
 	}
-	return &unhealthyPods
+	return &unhealthyPods, nil
 }
 
 func (p *CrashLoopBackOffRescheduler) shouldReschedule(pod *v1.Pod) bool {
-	return (p.filter.annotation == "" || pod.ObjectMeta.Annotations[p.filter.annotation] != "false") && // not opted-out
-		len(pod.ObjectMeta.OwnerReferences) > 0 && // Assuming Pod has owner reference of kind Controller
-		p.isPodUnhealthy(pod)
+	return len(pod.ObjectMeta.OwnerReferences) > 0 && // Assuming Pod has owner reference of kind Controller
+		p.isPodUnhealthy(pod) &&
+		p.isOptedIn(pod)
 }
 
 // This is not 100% reliable because Pod could toggle between Terminated with Error and Waiting with CrashLoopBackOff
 func (p *CrashLoopBackOffRescheduler) isPodUnhealthy(pod *v1.Pod) bool {
-	// Check if any of Containers is in CrashLoop
+	return p.crashLoopingContainer(pod) != nil
+}
+
+func (p *CrashLoopBackOffRescheduler) unhealthyReason(pod *v1.Pod) string {
+	container := p.crashLoopingContainer(pod)
+	if container == nil {
+		return "" // untested section
+	}
+	return fmt.Sprintf("CrashLoopBackOff container=%s restartCount=%d", container.Name, container.RestartCount)
+}
+
+// first of the Containers that is in CrashLoop
+func (p *CrashLoopBackOffRescheduler) crashLoopingContainer(pod *v1.Pod) *v1.ContainerStatus {
+	failureThreshold := p.options.Namespaces.FailureThreshold(p.options.Name, pod.ObjectMeta.Namespace, p.filter.failureThreshold)
 	statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
-	for _, containerStatus := range statuses {
-		if containerStatus.RestartCount >= p.filter.failureThreshold {
+	for i, containerStatus := range statuses {
+		if containerStatus.RestartCount >= failureThreshold {
 			if containerStatus.State.Waiting != nil && containerStatus.State.Waiting.Reason == "CrashLoopBackOff" {
-				return true
+				return &statuses[i]
 			}
 		}
 	}
-	return false
+	return nil
 }
@@ -2,19 +2,39 @@ package remediator_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
+	"github.com/aksgithub/kube_remediator/pkg/history"
 	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
 	"github.com/aksgithub/kube_remediator/pkg/remediator"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"gotest.tools/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/record"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 type TestCrashLoopBackOffReschedulerSuite struct {
@@ -23,6 +43,8 @@ type TestCrashLoopBackOffReschedulerSuite struct {
 	mockController *gomock.Controller
 	mockClient     *mock_k8s.MockClientInterface
 	pods           []corev1.Pod
+	objects        []runtime.Object // owners of the pods
+	options        remediator.Options
 	t              *testing.T
 }
 
@@ -35,6 +57,8 @@ func (suite *TestCrashLoopBackOffReschedulerSuite) SetupTest() {
 	suite.logger, _ = zap.NewDevelopment()
 	suite.mockController = gomock.NewController(suite.t)
 	suite.mockClient = mock_k8s.NewMockClientInterface(suite.mockController)
+	suite.options = remediator.Options{Name: "CrashLoopBackOffRescheduler"}
+	suite.objects = nil
 	suite.pods = []corev1.Pod{{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
@@ -74,18 +98,26 @@ func (suite *TestCrashLoopBackOffReschedulerSuite) TearDownTest() {
 	suite.mockController.Finish()
 }
 
-func (suite *TestCrashLoopBackOffReschedulerSuite) newInformerFactory() informers.SharedInformerFactory {
-	return informers.NewSharedInformerFactoryWithOptions(fake.NewSimpleClientset(), 0, informers.WithNamespace(""))
+func (suite *TestCrashLoopBackOffReschedulerSuite) newInformerFactories() (informers.SharedInformerFactory, metadatainformer.SharedInformerFactory) {
+	objects := suite.objects
+	for i := range suite.pods {
+		objects = append(objects, &suite.pods[i])
+	}
+	return newInformerFactories(objects...)
 }
 
 func (suite *TestCrashLoopBackOffReschedulerSuite) run() {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // cancel first so we can just run once and exit
 
-	suite.mockClient.EXPECT().NewSharedInformerFactory("").Return(suite.newInformerFactory(), nil)
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
 	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
 	err := crashloop.Setup(suite.logger, suite.mockClient)
 	assert.Equal(suite.t, err, nil)
+	startInformers(suite.T(), factory, metadataFactory)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -94,40 +126,114 @@ func (suite *TestCrashLoopBackOffReschedulerSuite) run() {
 }
 
 func (suite *TestCrashLoopBackOffReschedulerSuite) TestReschedulesUnhealthyPod() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
 	suite.run()
 }
 
 func (suite *TestCrashLoopBackOffReschedulerSuite) TestLoopsOverAllPods() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: append(suite.pods, suite.pods...)}, nil)
-	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil).Times(2)
+	suite.pods = append(suite.pods, *suite.pods[0].DeepCopy())
+	suite.pods[1].ObjectMeta.Name = "otherPod"
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[1]).Return(nil)
 	suite.run()
 }
 
 func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsUnhealthyPodWithoutOwnerReference() {
 	suite.pods[0].ObjectMeta.OwnerReferences = []metav1.OwnerReference{}
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.run()
 }
 
 func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsPodBelowThreshold() {
 	suite.pods[0].Status.ContainerStatuses[0].RestartCount = 4
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.run()
 }
 
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestStopsWhenCancelledBeforePodsSynced() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
+	assert.NilError(suite.t, crashloop.Setup(suite.logger, suite.mockClient))
+
+	// informers are never started, so nothing is remediated
+	var wg sync.WaitGroup
+	wg.Add(1)
+	crashloop.Run(ctx, &wg)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) useNamespace(namespace string) {
+	remediator.CONFIG_FILE = filepath.Join(suite.t.TempDir(), "crash_loop_back_off_rescheduler.json")
+	config := fmt.Sprintf(`{"namespace": %q}`, namespace)
+	assert.NilError(suite.t, os.WriteFile(remediator.CONFIG_FILE, []byte(config), 0644))
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestOnlyReschedulesPodsOfConfiguredNamespace() {
+	suite.useNamespace("team")
+	pod := *suite.pods[0].DeepCopy()
+	pod.ObjectMeta.Namespace = "team"
+	suite.pods = append(suite.pods, pod)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[1]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestReschedulesUpdatedPodsOfConfiguredNamespace() {
+	suite.useNamespace("team")
+	other := suite.pods[0].DeepCopy()
+	crashing := suite.pods[0].DeepCopy()
+	crashing.ObjectMeta.Namespace = "team"
+	healthy := crashing.DeepCopy()
+	healthy.ObjectMeta.Name = "otherPod"
+	healthy.Status.ContainerStatuses[0].RestartCount = 0
+	client := fake.NewSimpleClientset(other, crashing, healthy)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	_, metadataFactory := newInformerFactories()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	deletes := 0
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).DoAndReturn(func(pod *corev1.Pod) error {
+		assert.Equal(suite.t, pod.Namespace+"/"+pod.Name, "team/healthyPod")
+		if deletes++; deletes == 2 {
+			cancel() // the first delete is on start, the second one from an update
+		}
+		return nil
+	}).AnyTimes()
+
+	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
+	assert.NilError(suite.t, crashloop.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go crashloop.Run(ctx, &wg)
+
+	// updates before the remediator watches are missed, updates of one informer are handled in order
+	for i := 0; ctx.Err() == nil; i++ {
+		for _, pod := range []*corev1.Pod{other, healthy, crashing} {
+			pod.ObjectMeta.Labels = map[string]string{"update": fmt.Sprint(i)}
+			_, err := client.CoreV1().Pods(pod.Namespace).Update(context.Background(), pod, metav1.UpdateOptions{})
+			assert.NilError(suite.t, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+}
+
 func (suite *TestCrashLoopBackOffReschedulerSuite) TestReschedulesBasedOnInitContainers() {
 	suite.pods[0].Status.ContainerStatuses[0].RestartCount = 0 // make healthy
 	suite.pods[0].Status.InitContainerStatuses[0].RestartCount = 6
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
 	suite.run()
 }
 
 func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsWithOtherReason() {
 	suite.pods[0].Status.ContainerStatuses[0].State.Waiting.Reason = "X"
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.run()
 }
 
@@ -135,8 +241,13 @@ func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsOptedOutUnHealthyPod
 	suite.pods[0].ObjectMeta.Annotations = map[string]string{
 		"kube-remediator/CrashLoopBackOffRemediator": "false",
 	}
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil).Times(1)
+	suite.run()
+}
 
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsPodsOptedOutWithRemediatorName() {
+	suite.pods[0].ObjectMeta.Labels = map[string]string{
+		"kube-remediator/CrashLoopBackOffRescheduler": "false",
+	}
 	suite.run()
 }
 
@@ -144,19 +255,789 @@ func (suite *TestCrashLoopBackOffReschedulerSuite) TestReschedulesUnHealthyPodsW
 	suite.pods[0].ObjectMeta.Annotations = map[string]string{
 		"kube-remediator/CrashLoopBackOffRemediator": "true",
 	}
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil).Times(1)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil).Times(1)
 
 	suite.run()
 }
 
 func (suite *TestCrashLoopBackOffReschedulerSuite) TestDoesNotCrashWhenDeleteFails() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(errors.New("Foo"))
 	suite.run()
 }
 
-func (suite *TestCrashLoopBackOffReschedulerSuite) TestDoesNotCrashWhenListFails() {
-	suite.mockClient.EXPECT().GetPods("").Return(nil, errors.New("Foo"))
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestEvictsUnhealthyPod() {
+	suite.options.Action = policy.ActionEvict
+	suite.mockClient.EXPECT().EvictPod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsPodWhenEvictionIsBlocked() {
+	suite.options.Action = policy.ActionEvict
+	suite.mockClient.EXPECT().EvictPod(&suite.pods[0]).Return(apierrors.NewTooManyRequests("pdb", 10))
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestDeletesPodWhenEvictionIsBlockedAndConfigured() {
+	suite.options.Action = policy.ActionEvict
+	suite.options.DeleteWhenEvictionBlocked = true
+	suite.mockClient.EXPECT().EvictPod(&suite.pods[0]).Return(apierrors.NewTooManyRequests("pdb", 10))
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestDoesNotDeleteWhenEvictionFails() {
+	suite.options.Action = policy.ActionEvict
+	suite.options.DeleteWhenEvictionBlocked = true
+	suite.mockClient.EXPECT().EvictPod(&suite.pods[0]).Return(errors.New("Foo"))
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) events(recorder *record.FakeRecorder) []string {
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	return events
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRecordsEventsOnPodAndOwner() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	isController := true
+	suite.pods[0].ObjectMeta.OwnerReferences[0].Kind = "ReplicaSet"
+	suite.pods[0].ObjectMeta.OwnerReferences[0].Controller = &isController
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+
+	message := "Normal Remediated CrashLoopBackOffRescheduler deleted Pod default/healthyPod: CrashLoopBackOff container= restartCount=6"
+	assert.DeepEqual(suite.t, suite.events(recorder), []string{message, message})
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRecordsOwnerEventOnTheWorkload() {
+	recorder := record.NewFakeRecorder(10)
+	recorder.IncludeObject = true
+	suite.options.Recorder = recorder
+	suite.ownedByDeployment(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.expectOwnerPatch() // flap protection
+	suite.run()
+
+	events := suite.events(recorder)
+	assert.Equal(suite.t, len(events), 2)
+	assert.Assert(suite.t, strings.Contains(events[0], "involvedObject{kind=Pod,"), events[0])
+	assert.Assert(suite.t, strings.Contains(events[1], "involvedObject{kind=Deployment,"), events[1])
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRecordsWarningEventWhenDeleteFails() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(errors.New("Foo"))
+	suite.run()
+
+	assert.DeepEqual(suite.t, suite.events(recorder), []string{
+		"Warning RemediationFailed Failed: CrashLoopBackOffRescheduler deleted Pod default/healthyPod: CrashLoopBackOff container= restartCount=6: Foo",
+	})
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRecordsDryRunEvents() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.options.DryRun = true
+	suite.run()
+
+	assert.DeepEqual(suite.t, suite.events(recorder), []string{
+		"Normal RemediationDryRun Dry-run: CrashLoopBackOffRescheduler deleted Pod default/healthyPod: CrashLoopBackOff container= restartCount=6",
+	})
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRecordsHistory() {
+	store, err := history.NewStore(10, "")
+	assert.NilError(suite.t, err)
+	suite.options.History = store
+	isController := true
+	suite.pods[0].ObjectMeta.OwnerReferences[0].Kind = "ReplicaSet"
+	suite.pods[0].ObjectMeta.OwnerReferences[0].Controller = &isController
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+
+	entries := store.List(history.Filter{})
+	assert.Equal(suite.t, len(entries), 1)
+	entries[0].Time = time.Time{}
+	assert.DeepEqual(suite.t, entries[0], history.Entry{
+		Remediator: "CrashLoopBackOffRescheduler",
+		Action:     "delete",
+		Result:     "success",
+		Reason:     "CrashLoopBackOff container= restartCount=6",
+		Kind:       "Pod",
+		Namespace:  "default",
+		Name:       "healthyPod",
+		Owner:      &history.Owner{Kind: "ReplicaSet", Name: suite.pods[0].ObjectMeta.OwnerReferences[0].Name},
+	})
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestUsesFailureThresholdOfNamespace() {
+	threshold := int32(10)
+	suite.options.Namespaces = policy.NewNamespacePolicies(nil)
+	suite.options.Namespaces.Set(policy.RemediationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"},
+		Spec: policy.RemediationPolicySpec{
+			Remediators: map[string]policy.NamespaceRemediatorSettings{
+				"CrashLoopBackOffRescheduler": {FailureThreshold: &threshold},
+			},
+		},
+	})
+	suite.run()
+}
+
+// copies of the unhealthy pod with their own names and images
+func (suite *TestCrashLoopBackOffReschedulerSuite) crashingPods(images ...string) []corev1.Pod {
+	var pods []corev1.Pod
+	for i, image := range images {
+		pod := *suite.pods[0].DeepCopy()
+		pod.ObjectMeta.Name = fmt.Sprintf("crashing-%d", i)
+		pod.Spec.Containers = []corev1.Container{{Name: "app", Image: image}}
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestPausesWhenManyPodsShareAnImage() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.options.CircuitBreaker = policy.CircuitBreaker{Window: 10 * time.Minute, MaxShared: 2}
+	pods := suite.crashingPods("app:broken", "app:broken", "app:broken", "app:broken")
+	suite.pods = pods
+	suite.mockClient.EXPECT().DeletePod(&pods[0]).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&pods[1]).Return(nil)
+	suite.run()
+
+	events := suite.events(recorder)
+	assert.Equal(suite.t, len(events), 3)
+	assert.Equal(suite.t, events[2], "Warning RemediationPaused CrashLoopBackOffRescheduler paused remediation of image/app:broken: "+
+		"3 candidates within 10m0s, remediation resumes once they are back to 1")
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsRemediatingOutsideOfPausedScopes() {
+	suite.options.CircuitBreaker = policy.CircuitBreaker{Window: 10 * time.Minute, MaxShared: 1}
+	pods := suite.crashingPods("app:broken", "app:broken", "other:1")
+	suite.pods = pods
+	suite.mockClient.EXPECT().DeletePod(&pods[0]).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&pods[2]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestPausesNamespaceAndCluster() {
+	suite.options.CircuitBreaker = policy.CircuitBreaker{Window: 10 * time.Minute, MaxNamespace: 2, MaxCluster: 4}
+	pods := suite.crashingPods("a:1", "b:1", "c:1", "d:1", "e:1")
+	pods[3].ObjectMeta.Namespace = "other"
+	pods[4].ObjectMeta.Namespace = "other"
+	suite.pods = pods
+	suite.mockClient.EXPECT().DeletePod(&pods[0]).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&pods[1]).Return(nil) // pods[2] opens default
+	suite.mockClient.EXPECT().DeletePod(&pods[3]).Return(nil) // pods[4] opens the cluster, paused candidates count too
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestResumesOnceTheStormIsOver() {
+	suite.options.CircuitBreaker = policy.CircuitBreaker{Window: 10 * time.Millisecond, MaxShared: 1}
+	pods := suite.crashingPods("app:broken", "app:broken", "app:broken")
+	suite.pods = pods[:2]
+	suite.mockClient.EXPECT().DeletePod(&pods[0]).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&pods[2]).Return(nil)
+
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
+	assert.NilError(suite.t, crashloop.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	crashloop.Run(ctx, &wg) // opens image/app:broken with pods[1]
+	time.Sleep(20 * time.Millisecond)
+	store := factory.Core().V1().Pods().Informer().GetStore()
+	assert.NilError(suite.t, store.Delete(&pods[0]))
+	assert.NilError(suite.t, store.Delete(&pods[1]))
+	assert.NilError(suite.t, store.Add(&pods[2]))
+	crashloop.Run(ctx, &wg)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestPausesWhenManyPodsShareAConfigMap() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.options.CircuitBreaker = policy.CircuitBreaker{Window: 10 * time.Minute, MaxShared: 2}
+	pods := suite.crashingPods("a:1", "b:1", "c:1", "d:1")
+	// every way a pod can use a ConfigMap counts, each only once per pod
+	config := corev1.LocalObjectReference{Name: "app-config"}
+	pods[0].Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: config}}}
+	pods[0].Spec.Volumes = []corev1.Volume{{VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: config}}}}
+	pods[1].Spec.InitContainers = []corev1.Container{{Name: "init", Image: "init:1", Env: []corev1.EnvVar{
+		{Name: "PLAIN", Value: "value"},
+		{Name: "CONFIG", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: config, Key: "key"}}},
+	}}}
+	pods[2].Spec.Volumes = []corev1.Volume{{VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+		Sources: []corev1.VolumeProjection{{}, {ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: config}}},
+	}}}}
+	pods[3].Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{{}, {ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: config}}}
+	suite.pods = pods
+	suite.mockClient.EXPECT().DeletePod(&pods[0]).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&pods[1]).Return(nil)
+	suite.run()
+
+	events := suite.events(recorder)
+	assert.Equal(suite.t, len(events), 3)
+	assert.Equal(suite.t, events[2], "Warning RemediationPaused CrashLoopBackOffRescheduler paused remediation of configmap/default/app-config: "+
+		"3 candidates within 10m0s, remediation resumes once they are back to 1")
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestPausesWhenManyPodsRunOnANode() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.options.CircuitBreaker = policy.CircuitBreaker{Window: 10 * time.Minute, MaxShared: 1}
+	pods := suite.crashingPods("a:1", "b:1", "c:1")
+	pods[0].Spec.NodeName = "node-1"
+	pods[1].Spec.NodeName = "node-1"
+	pods[2].Spec.NodeName = "node-2"
+	suite.pods = pods
+	suite.mockClient.EXPECT().DeletePod(&pods[0]).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&pods[2]).Return(nil)
+	suite.run()
+
+	events := suite.events(recorder)
+	assert.Equal(suite.t, events[1], "Warning RemediationPaused CrashLoopBackOffRescheduler paused remediation of node/node-1: "+
+		"2 candidates within 10m0s, remediation resumes once they are back to 0")
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestReportsClosedCircuitBreakersWithoutNewCandidates() {
+	interval := remediator.CircuitBreakerInterval
+	remediator.CircuitBreakerInterval = time.Millisecond
+	defer func() { remediator.CircuitBreakerInterval = interval }()
+	core, logs := observer.New(zap.InfoLevel)
+	suite.logger = zap.New(core)
+	suite.options.CircuitBreaker = policy.CircuitBreaker{Window: 10 * time.Millisecond, MaxShared: 1}
+	pods := suite.crashingPods("app:broken", "app:broken")
+	pods[0].Spec.NodeName = "node-1"
+	pods[1].Spec.NodeName = "node-1"
+	suite.pods = pods
+	suite.mockClient.EXPECT().DeletePod(&pods[0]).Return(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
+	assert.NilError(suite.t, crashloop.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go crashloop.Run(ctx, &wg)
+
+	// pods[1] opens the image and the node at once, both close once the window passed
+	for logs.FilterMessage("Circuit breaker closed, resuming remediation").Len() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+	closed := logs.FilterMessage("Circuit breaker closed, resuming remediation").All()
+	assert.Equal(suite.t, closed[0].ContextMap()["scope"], "image/app:broken")
+	assert.Equal(suite.t, closed[1].ContextMap()["scope"], "node/node-1")
+}
+
+// the pod belongs to Deployment web through ReplicaSet web-1
+func (suite *TestCrashLoopBackOffReschedulerSuite) ownedByDeployment(annotations map[string]string) {
+	isController := true
+	for i := range suite.pods {
+		suite.pods[i].ObjectMeta.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-1", Controller: &isController}}
+	}
+	suite.objects = append(suite.objects,
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-1",
+			Namespace:       "default",
+			UID:             "web-1",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", Controller: &isController}},
+		}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web", Annotations: annotations}},
+	)
+}
+
+// attempts ago, oldest first
+func attempts(ago ...time.Duration) string {
+	var times []string
+	for _, duration := range ago {
+		times = append(times, time.Now().Add(-duration).UTC().Format(time.RFC3339))
+	}
+	return strings.Join(times, ",")
+}
+
+// patches of the Deployment's annotations
+func (suite *TestCrashLoopBackOffReschedulerSuite) expectOwnerPatch() *map[string]interface{} {
+	annotations := &map[string]interface{}{}
+	suite.mockClient.EXPECT().PatchOwner("Deployment", "default", "web", gomock.Any()).
+		DoAndReturn(func(kind, namespace, name string, patch []byte) error {
+			var parsed struct {
+				Metadata struct{ Annotations map[string]interface{} }
+			}
+			assert.NilError(suite.t, json.Unmarshal(patch, &parsed))
+			*annotations = parsed.Metadata.Annotations
+			return nil
+		})
+	return annotations
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRecordsAttemptsOnOwner() {
+	suite.ownedByDeployment(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	patched := suite.expectOwnerPatch()
+	suite.run()
+
+	assert.Equal(suite.t, len(*patched), 1)
+	_, err := time.Parse(time.RFC3339, (*patched)["kube-remediator/CrashLoopBackOffRescheduler-attempts"].(string))
+	assert.NilError(suite.t, err)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsRemediatingWhenAttemptsCanNotBeRecorded() {
+	suite.ownedByDeployment(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.mockClient.EXPECT().PatchOwner("Deployment", "default", "web", gomock.Any()).Return(errors.New("Foo"))
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestUsesOwnerAnnotationsOnceTheInformerHasThem() {
+	suite.ownedByDeployment(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil).Times(2)
+	suite.expectOwnerPatch()
+	suite.expectOwnerPatch()
+
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
+	assert.NilError(suite.t, crashloop.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	crashloop.Run(ctx, &wg) // backs off from web until the informer has it with other annotations
+
+	// someone removed the attempts
+	deployments := metadataFactory.ForResource(appsv1.SchemeGroupVersion.WithResource("deployments")).Informer().GetStore()
+	deployment := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web", ResourceVersion: "2"},
+	}
+	assert.NilError(suite.t, deployments.Update(deployment))
+	crashloop.Run(ctx, &wg)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestBacksOffFromOwner() {
+	suite.ownedByDeployment(map[string]string{"kube-remediator/CrashLoopBackOffRescheduler-attempts": attempts(time.Minute)})
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestBacksOffExponentially() {
+	// 3 attempts wait 40m after the last one
+	suite.ownedByDeployment(map[string]string{"kube-remediator/CrashLoopBackOffRescheduler-attempts": attempts(3*time.Hour, 2*time.Hour, 30*time.Minute)})
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRetriesAfterBackoff() {
+	suite.ownedByDeployment(map[string]string{"kube-remediator/CrashLoopBackOffRescheduler-attempts": attempts(25*time.Hour, 11*time.Minute)})
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	patched := suite.expectOwnerPatch()
+	suite.run()
+
+	// the attempt outside of the window is forgotten
+	assert.Equal(suite.t, len(strings.Split((*patched)["kube-remediator/CrashLoopBackOffRescheduler-attempts"].(string), ",")), 2)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestBacksOffForOtherPodsOfTheOwner() {
+	suite.pods = append(suite.pods, *suite.pods[0].DeepCopy())
+	suite.pods[1].ObjectMeta.Name = "otherPod"
+	suite.ownedByDeployment(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.expectOwnerPatch()
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestMarksOwnerExhausted() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.ownedByDeployment(map[string]string{
+		"kube-remediator/CrashLoopBackOffRescheduler-attempts": attempts(23*time.Hour, 22*time.Hour, 21*time.Hour, 20*time.Hour),
+	})
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	patched := suite.expectOwnerPatch()
+	suite.run()
+
+	assert.Equal(suite.t, (*patched)["kube-remediator/CrashLoopBackOffRescheduler-attempts"], nil)
+	_, err := time.Parse(time.RFC3339, (*patched)["kube-remediator/CrashLoopBackOffRescheduler-exhausted"].(string))
+	assert.NilError(suite.t, err)
+	events := suite.events(recorder)
+	assert.Equal(suite.t, events[len(events)-1], "Warning RemediationExhausted CrashLoopBackOffRescheduler gave up on Deployment default/web "+
+		"after 5 attempts within 24h0m0s, remove annotation kube-remediator/CrashLoopBackOffRescheduler-exhausted to resume")
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsPodsOfExhaustedOwners() {
+	suite.ownedByDeployment(map[string]string{"kube-remediator/CrashLoopBackOffRescheduler-exhausted": "2024-03-01T12:00:00Z"})
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestOnlyRemembersAttemptsInDryRun() {
+	suite.options.DryRun = true
+	suite.ownedByDeployment(nil)
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestSetupFailsOnInvalidFlapProtection() {
+	remediator.CONFIG_FILE = filepath.Join(suite.t.TempDir(), "crash_loop_back_off_rescheduler.json")
+	assert.NilError(suite.t, os.WriteFile(remediator.CONFIG_FILE, []byte(`{"flapProtection": {"window": "0s"}}`), 0644))
+
+	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
+	assert.ErrorContains(suite.t, crashloop.Setup(suite.logger, suite.mockClient), "flapProtection needs a positive window and backoff")
+}
+
+// a replica of the pod that is not crashing
+func (suite *TestCrashLoopBackOffReschedulerSuite) addHealthyPod(name string) {
+	pod := *suite.pods[0].DeepCopy()
+	pod.ObjectMeta.Name = name
+	pod.Status.ContainerStatuses[0].RestartCount = 0
+	suite.pods = append(suite.pods, pod)
+}
+
+// restarts of the Deployment, annotations of flap protection are patched too
+func (suite *TestCrashLoopBackOffReschedulerSuite) expectRestarts() *[]string {
+	return suite.expectRestartsOf("Deployment")
+}
+
+// restartedAt annotations patched onto the pod template of the owner web of the kind
+func (suite *TestCrashLoopBackOffReschedulerSuite) expectRestartsOf(kind string) *[]string {
+	var restarts []string
+	suite.mockClient.EXPECT().PatchOwner(kind, "default", "web", gomock.Any()).
+		DoAndReturn(func(kind, namespace, name string, patch []byte) error {
+			var parsed struct {
+				Spec struct {
+					Template struct {
+						Metadata struct{ Annotations map[string]string }
+					}
+				}
+			}
+			assert.NilError(suite.t, json.Unmarshal(patch, &parsed))
+			if restartedAt, found := parsed.Spec.Template.Metadata.Annotations["kubectl.kubernetes.io/restartedAt"]; found {
+				restarts = append(restarts, restartedAt)
+			}
+			return nil
+		}).AnyTimes()
+	return &restarts
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRestartsOwnerWhenMostPodsCrash() {
+	suite.options.RestartOwner = policy.RestartOwner{Threshold: 0.5, Cooldown: 10 * time.Minute}
+	suite.pods = append(suite.pods, *suite.pods[0].DeepCopy())
+	suite.pods[1].ObjectMeta.Name = "otherPod"
+	suite.addHealthyPod("healthyReplica")
+	suite.ownedByDeployment(nil)
+	restarts := suite.expectRestarts()
+	suite.run()
+
+	assert.Equal(suite.t, len(*restarts), 1)
+	_, err := time.Parse(time.RFC3339, (*restarts)[0])
+	assert.NilError(suite.t, err)
+}
+
+// the pods belong to web of the kind directly, like pods of StatefulSets and DaemonSets do
+func (suite *TestCrashLoopBackOffReschedulerSuite) ownedDirectlyBy(kind string) {
+	isController := true
+	for i := range suite.pods {
+		suite.pods[i].ObjectMeta.OwnerReferences = []metav1.OwnerReference{{Kind: kind, Name: "web", Controller: &isController}}
+	}
+	meta := metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web"}
+	switch kind {
+	case "StatefulSet":
+		suite.objects = append(suite.objects, &appsv1.StatefulSet{ObjectMeta: meta})
+	case "DaemonSet":
+		suite.objects = append(suite.objects, &appsv1.DaemonSet{ObjectMeta: meta})
+	case "Job":
+		suite.objects = append(suite.objects, &batchv1.Job{ObjectMeta: meta})
+	}
+}
+
+// flap protection would back off from the owner after its first attempt
+func (suite *TestCrashLoopBackOffReschedulerSuite) withoutFlapProtection() {
+	remediator.CONFIG_FILE = filepath.Join(suite.t.TempDir(), "crash_loop_back_off_rescheduler.json")
+	assert.NilError(suite.t, os.WriteFile(remediator.CONFIG_FILE, []byte(`{"flapProtection": {"maxAttempts": 0}}`), 0644))
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) assertRestarts(kind string) {
+	suite.options.RestartOwner = policy.RestartOwner{Threshold: 0.5, Cooldown: 10 * time.Minute}
+	suite.ownedDirectlyBy(kind)
+	restarts := suite.expectRestartsOf(kind)
+	suite.run()
+
+	assert.Equal(suite.t, len(*restarts), 1)
+	_, err := time.Parse(time.RFC3339, (*restarts)[0])
+	assert.NilError(suite.t, err)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRestartsStatefulSets() {
+	suite.assertRestarts("StatefulSet")
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRestartsDaemonSets() {
+	suite.assertRestarts("DaemonSet")
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestDeletesPodsOfOwnersThatCanNotBeRestarted() {
+	suite.withoutFlapProtection()
+	suite.options.RestartOwner = policy.RestartOwner{Threshold: 0.5, Cooldown: 10 * time.Minute}
+	suite.ownedDirectlyBy("Job")
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestRestartsOwnerOnceForAllItsPods() {
+	suite.withoutFlapProtection()
+	suite.options.RestartOwner = policy.RestartOwner{Threshold: 0.5, Cooldown: 10 * time.Minute}
+	suite.pods = append(suite.pods, *suite.pods[0].DeepCopy())
+	suite.pods[1].ObjectMeta.Name = "otherPod"
+	suite.ownedByDeployment(nil)
+	restarts := suite.expectRestarts()
+	suite.run() // otherPod is replaced by the restart
+
+	assert.Equal(suite.t, len(*restarts), 1)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestOnlyCountsRunningPodsOfTheOwner() {
+	suite.options.RestartOwner = policy.RestartOwner{Threshold: 0.5, Cooldown: 10 * time.Minute}
+	suite.addHealthyPod("terminatingReplica")
+	suite.addHealthyPod("otherApp")
+	suite.ownedByDeployment(nil)
+	now := metav1.Now()
+	suite.pods[1].ObjectMeta.DeletionTimestamp = &now
+	suite.pods[2].ObjectMeta.OwnerReferences = nil
+	restarts := suite.expectRestarts()
+	suite.run()
+
+	assert.Equal(suite.t, len(*restarts), 1) // 1 of 1 pods, not 1 of 3
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestDeletesPodWhenFewPodsOfOwnerCrash() {
+	suite.options.RestartOwner = policy.RestartOwner{Threshold: 0.5, Cooldown: 10 * time.Minute}
+	suite.addHealthyPod("healthyReplica")
+	suite.ownedByDeployment(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	restarts := suite.expectRestarts()
+	suite.run()
+
+	assert.Equal(suite.t, len(*restarts), 0)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsPodsOfRecentlyRestartedOwner() {
+	suite.options.RestartOwner = policy.RestartOwner{Threshold: 0.5, Cooldown: 10 * time.Minute}
+	suite.ownedByDeployment(nil)
+	suite.objects[1].(*appsv1.Deployment).Spec.Template.Annotations = map[string]string{
+		"kubectl.kubernetes.io/restartedAt": time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+	}
+	suite.run()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestOnlyLogsRestartsInDryRun() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.options.DryRun = true
+	suite.options.RestartOwner = policy.RestartOwner{Threshold: 0.5, Cooldown: 10 * time.Minute}
+	suite.ownedByDeployment(nil)
+	suite.run()
+
+	assert.Equal(suite.t, suite.events(recorder)[0], "Normal RemediationDryRun Dry-run: CrashLoopBackOffRescheduler restarted Deployment default/web: "+
+		"1 of 1 pods need remediation, healthyPod: CrashLoopBackOff container= restartCount=6")
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) requireApproval() *approval.Store {
+	approvals := approval.NewStore()
+	suite.options.Approval = policy.Approval{Required: true, Expiry: time.Hour}
+	suite.options.Approvals = approvals
+	return approvals
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) listApprovals(approvals *approval.Store, filter approval.Filter) []approval.Remediation {
+	remediations, err := approvals.List(filter)
+	assert.NilError(suite.t, err)
+	return remediations
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestProposesInsteadOfDeleting() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	approvals := suite.requireApproval()
 	suite.run()
+
+	pending := suite.listApprovals(approvals, approval.Filter{State: approval.StatePending})
+	assert.Equal(suite.t, len(pending), 1)
+	assert.Equal(suite.t, pending[0].Name, "healthyPod")
+	assert.Equal(suite.t, pending[0].Action, "delete")
+	assert.Assert(suite.t, strings.HasPrefix(suite.events(recorder)[0], "Normal RemediationPending CrashLoopBackOffRescheduler needs approval "+
+		"before deleting Pod default/healthyPod: CrashLoopBackOff container= restartCount=6, approve with POST /approvals/"+pending[0].ID+"/approve"))
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestOnlyRequiresApprovalInConfiguredNamespaces() {
+	approvals := suite.requireApproval()
+	suite.options.Approval.Namespaces = []string{"prod-*"}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+
+	assert.Equal(suite.t, len(suite.listApprovals(approvals, approval.Filter{})), 0)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestDeletesPodApprovedWithAnnotation() {
+	approvals := suite.requireApproval()
+	suite.pods[0].ObjectMeta.Annotations = map[string]string{"kube-remediator/approval": "approve"}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+
+	assert.Equal(suite.t, len(suite.listApprovals(approvals, approval.Filter{})), 0)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsPodRejectedWithAnnotation() {
+	approvals := suite.requireApproval()
+	suite.pods[0].ObjectMeta.Annotations = map[string]string{"kube-remediator/approval": "reject"}
+	suite.run()
+
+	rejected := suite.listApprovals(approvals, approval.Filter{State: approval.StateRejected})
+	assert.Equal(suite.t, len(rejected), 1)
+	assert.Equal(suite.t, rejected[0].DecidedBy, "annotation")
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestWaitsWhenApprovalsCannotBeRead() {
+	suite.requireApproval()
+	suite.options.Approvals = approval.NewConfigMapStore(&fakeConfigMaps{err: errors.New("Foo")}, "kube-system", approval.ConfigMapName)
+	suite.pods[0].ObjectMeta.Annotations = map[string]string{"kube-remediator/approval": "approve"}
+	suite.run() // would fail on deleting
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestKeepsApprovalWhenDeleteFails() {
+	approvals := suite.requireApproval()
+	suite.pods[0].ObjectMeta.Annotations = map[string]string{"kube-remediator/approval": "approve"}
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(errors.New("Foo"))
+	suite.run()
+
+	assert.Equal(suite.t, len(suite.listApprovals(approvals, approval.Filter{State: approval.StateApproved})), 1)
+}
+
+// lets every token decide
+type allowingReviews struct{}
+
+func (allowingReviews) ReviewToken(token string) (authenticationv1.UserInfo, bool, error) {
+	return authenticationv1.UserInfo{Username: token}, true, nil
+}
+
+func (allowingReviews) ReviewAccess(authenticationv1.UserInfo, authorizationv1.ResourceAttributes) (bool, error) {
+	return true, nil
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestDeletesPodOnceApprovedOverHttp() {
+	approvals := suite.requireApproval()
+	approvals.AuthorizeWith(allowingReviews{})
+	mux := http.NewServeMux()
+	assert.NilError(suite.t, approvals.RegisterHandler(mux))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).DoAndReturn(func(pod *corev1.Pod) error {
+		assert.Equal(suite.t, pod.Name, "healthyPod")
+		cancel() // stop once the approved pod is deleted
+		return nil
+	})
+
+	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
+	assert.NilError(suite.t, crashloop.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go crashloop.Run(ctx, &wg)
+
+	var pending []approval.Remediation
+	for len(pending) == 0 {
+		time.Sleep(time.Millisecond)
+		pending = suite.listApprovals(approvals, approval.Filter{State: approval.StatePending})
+	}
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/approvals/"+pending[0].ID+"/approve", nil)
+	request.Header.Set("Authorization", "Bearer alice")
+	mux.ServeHTTP(response, request)
+	assert.Equal(suite.t, response.Code, http.StatusOK)
+
+	wg.Wait()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) approveGonePod(approvals *approval.Store) {
+	gone, _, err := approvals.Propose(approval.Remediation{
+		Remediator: "CrashLoopBackOffRescheduler", Action: "delete", Kind: "Pod", Namespace: "default", Name: "gone", UID: "gone",
+	}, time.Hour)
+	assert.NilError(suite.t, err)
+	_, err = approvals.Decide(gone.ID, true, "alice")
+	assert.NilError(suite.t, err)
+}
+
+// runs the remediator until done says it acted on approvals
+func (suite *TestCrashLoopBackOffReschedulerSuite) actOnApprovals(approvals *approval.Store, done func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	crashloop := remediator.CrashLoopBackOffRescheduler{}
+	crashloop.Configure(suite.options)
+	assert.NilError(suite.t, crashloop.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go crashloop.Run(ctx, &wg)
+
+	// the remediator only hears about approvals once it subscribed
+	for !done() {
+		time.Sleep(time.Millisecond)
+		approvals.Check()
+	}
+	cancel()
+	wg.Wait()
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestCompletesApprovalsOfPodsThatAreGone() {
+	approvals := suite.requireApproval()
+	suite.pods = nil
+	suite.approveGonePod(approvals)
+	// owners are restarted when one of their pods comes up again, their approvals are kept until then
+	restart, _, err := approvals.Propose(approval.Remediation{
+		Remediator: "CrashLoopBackOffRescheduler", Action: "restart", Kind: "Deployment", Namespace: "default", Name: "web", UID: "web",
+	}, time.Hour)
+	assert.NilError(suite.t, err)
+	_, err = approvals.Decide(restart.ID, true, "alice")
+	assert.NilError(suite.t, err)
+
+	suite.actOnApprovals(approvals, func() bool { return len(suite.listApprovals(approvals, approval.Filter{})) == 1 })
+	assert.Equal(suite.t, suite.listApprovals(approvals, approval.Filter{})[0].ID, restart.ID)
+}
+
+func (suite *TestCrashLoopBackOffReschedulerSuite) TestReportsApprovalsThatCanNotBeCompleted() {
+	core, logs := observer.New(zap.ErrorLevel)
+	suite.logger = zap.New(core)
+	suite.requireApproval()
+	configMaps := &fakeConfigMaps{}
+	suite.options.Approvals = approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+	suite.pods = nil
+	suite.approveGonePod(suite.options.Approvals)
+	configMaps.patchErr = errors.New("Foo")
+	suite.actOnApprovals(suite.options.Approvals, func() bool { return logs.FilterMessage("Error completing approval").Len() == 1 })
 }
@@ -0,0 +1,78 @@
+package remediator
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// reasons of the events we record, the message says which remediator did what and why
+const (
+	EventReasonRemediated = "Remediated"
+	EventReasonDryRun     = "RemediationDryRun"
+	EventReasonBlocked    = "RemediationBlocked"
+	EventReasonFailed     = "RemediationFailed"
+
+	EventReasonCircuitBreakerOpen = "RemediationPaused"
+	EventReasonExhausted          = "RemediationExhausted"
+	EventReasonPending            = "RemediationPending"
+)
+
+var actionVerbs = map[string]struct{ doing, done string }{
+	"delete": {"Deleting", "deleted"},
+	"evict":  {"Evicting", "evicted"},
+	// owners of pods
+	"restart": {"Restarting", "restarted"},
+	// used by rules
+	"annotate": {"Annotating", "annotated"},
+	"notify":   {"Notifying about", "notified about"},
+}
+
+// records on the object and on the workload it belongs to, so it shows up when describing either of them
+func (p *Base) recordEvent(object runtime.Object, eventType string, reason string, message string) {
+	if p.options.Recorder == nil {
+		return
+	}
+	p.options.Recorder.Event(object, eventType, reason, message)
+
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return // untested section
+	}
+	if owner := p.rootOwnerReference(accessor); owner != nil {
+		p.options.Recorder.Event(&v1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			Namespace:  accessor.GetNamespace(),
+			UID:        owner.UID,
+		}, eventType, reason, message)
+	}
+}
+
+// reference to the top-most controlling owner, like the Deployment of a pod instead of its ReplicaSet,
+// as far as the owners are in the informers. Nil when the object has no controlling owner.
+func (p *Base) rootOwnerReference(object metav1.Object) *metav1.OwnerReference {
+	reference := metav1.GetControllerOfNoCopy(object)
+	for owner := p.optIns.controller(object); owner != nil; owner = p.optIns.controller(owner) {
+		next := metav1.GetControllerOfNoCopy(owner)
+		if next == nil {
+			break
+		}
+		reference = next
+	}
+	return reference
+}
+
+// typed objects from the api usually do not have their kind set
+func kindOf(object runtime.Object) string {
+	if kind := object.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+		return kind
+	}
+	if kinds, _, err := scheme.Scheme.ObjectKinds(object); err == nil && len(kinds) > 0 {
+		return kinds[0].Kind
+	}
+	return "Object" // untested section
+}
@@ -2,11 +2,13 @@ package remediator
 
 import (
 	"context"
+	"fmt"
 	"github.com/aksgithub/kube_remediator/pkg/k8s"
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/informers"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"strings"
 	"sync"
@@ -15,59 +17,82 @@ import (
 
 type FailedPodRescheduler struct {
 	Base
-	informerFactory informers.SharedInformerFactory
+	pods coreinformers.PodInformer
+}
+
+func init() {
+	Register(Registration{
+		Name: "FailedPodRescheduler",
+		New:  func() BaseIntf { return &FailedPodRescheduler{} },
+		Rules: append(podRules("list", "watch", "delete"),
+			rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets", "daemonsets"}, Verbs: []string{"patch"}},
+		),
+	})
 }
 
 func (p *FailedPodRescheduler) Setup(logger *zap.Logger, client k8s.ClientInterface) error {
-	informerFactory, err := client.NewSharedInformerFactory("")
-	if err != nil {
+	if err := p.Base.Setup(logger, client); err != nil {
 		return err // untested section
 	}
-	p.informerFactory = informerFactory
-	p.logger = logger
-	p.client = client
+	p.pods = p.informers.Core().V1().Pods()
+	p.pods.Informer() // request it before the shared factory is started
+	p.restarts = p.newOwnerRestarts(p.pods, p.shouldReschedule)
+	p.reconsider = func(pod *v1.Pod) { p.rescheduleIfNecessary(nil, pod) }
 	return nil
 }
 
 func (p *FailedPodRescheduler) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	if !p.waitForCacheSync(ctx, p.pods) {
+		return
+	}
 	p.logStartAndStop(func() {
 		// Check for any Failed Pods first
-		p.reschedulePods()
-		// TODO: filter failed pods here to avoid overhead
-		informer := p.informerFactory.Core().V1().Pods().Informer()
-
-		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-			UpdateFunc: p.rescheduleIfNecessary,
-		})
-		informer.Run(ctx.Done())
-
-		<-ctx.Done()
+		if p.reconcileUntilSuccess(ctx, p.reschedulePods) {
+			p.handlePodUpdates(ctx, p.pods, cache.ResourceEventHandlerFuncs{
+				UpdateFunc: p.rescheduleIfNecessary,
+			})
+		}
 	})
 }
 
-func (p *FailedPodRescheduler) reschedulePods() {
+func (p *FailedPodRescheduler) Reconcile() error {
+	return p.reschedulePods()
+}
+
+func (p *FailedPodRescheduler) reschedulePods() error {
 	p.logger.Info("Reconcile")
-	for _, pod := range *p.getFailedPods() {
+	pods, err := p.getFailedPods()
+	if err != nil {
+		return err // untested section
+	}
+	for _, pod := range *pods {
 		p.rescheduleIfNecessary(nil, &pod)
 	}
+	return nil
 }
 
 func (p *FailedPodRescheduler) rescheduleIfNecessary(oldObj, newObj interface{}) {
 	pod := newObj.(*v1.Pod)
 	if p.shouldReschedule(pod) {
-		p.deletePod(*pod)
+		p.removePod(*pod, fmt.Sprintf("Failed reason=%s", pod.Status.Reason))
 	}
 }
 
-func (p *FailedPodRescheduler) getFailedPods() *[]v1.Pod {
-	pods, err := p.client.GetPods("", metav1.ListOptions{FieldSelector: "status.phase=Failed"})
+// listed from the shared informer instead of the api, shouldReschedule checks the phase
+func (p *FailedPodRescheduler) getFailedPods() (*[]v1.Pod, error) {
+	pods, err := p.pods.Lister().List(labels.Everything())
 	if err != nil {
-		p.logger.Error("Error getting pod list: ", zap.Error(err))
-		return &[]v1.Pod{}
+		return nil, fmt.Errorf("getting pod list: %w", err) // untested section
+	}
+	var failedPods []v1.Pod
+	for _, pod := range sortedPods(pods) {
+		if pod.Status.Phase == v1.PodFailed {
+			failedPods = append(failedPods, *pod)
+		}
 	}
-	return &pods.Items
+	return &failedPods, nil
 }
 
 func (p *FailedPodRescheduler) shouldReschedule(pod *v1.Pod) bool {
@@ -89,7 +114,8 @@ func (p *FailedPodRescheduler) shouldReschedule(pod *v1.Pod) bool {
 	}
 
 	// Keep pods for 5 mins to be able to debug and log pipeline to find out metadata
-	if pod.ObjectMeta.CreationTimestamp.Time.After(time.Now().Add(-5 * time.Minute)) {
+	minAge := p.options.Namespaces.MinAge(p.options.Name, pod.ObjectMeta.Namespace, 5*time.Minute)
+	if pod.ObjectMeta.CreationTimestamp.Time.After(time.Now().Add(-minAge)) {
 		return false
 	}
 
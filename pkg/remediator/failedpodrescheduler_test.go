@@ -3,16 +3,20 @@ package remediator_test
 import (
 	"context"
 	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
 	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
 	"github.com/aksgithub/kube_remediator/pkg/remediator"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"gotest.tools/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/informers"
-	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/metadata/metadatainformer"
 	"sync"
 	"testing"
 	"time"
@@ -48,7 +52,7 @@ func (suite *TestFailedPodReschedulerSuite) SetupTest() {
 					Name: "controller",
 				},
 			},
-			CreationTimestamp: metav1.Time{time.Now().Add(-10 * time.Minute)},
+			CreationTimestamp: metav1.Time{Time: time.Now().Add(-10 * time.Minute)},
 		},
 		Status: corev1.PodStatus{
 			Phase:  "Failed",
@@ -61,67 +65,128 @@ func (suite *TestFailedPodReschedulerSuite) TearDownTest() {
 	suite.mockController.Finish()
 }
 
-func (suite *TestFailedPodReschedulerSuite) newInformerFactory() informers.SharedInformerFactory {
-	return informers.NewSharedInformerFactoryWithOptions(fake.NewSimpleClientset(), 0, informers.WithNamespace(""))
+func (suite *TestFailedPodReschedulerSuite) newInformerFactories() (informers.SharedInformerFactory, metadatainformer.SharedInformerFactory) {
+	var objects []runtime.Object
+	for i := range suite.pods {
+		objects = append(objects, &suite.pods[i])
+	}
+	return newInformerFactories(objects...)
 }
 
 func (suite *TestFailedPodReschedulerSuite) run() {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // cancel first so we can just run once and exit
 
-	suite.mockClient.EXPECT().NewSharedInformerFactory("").Return(suite.newInformerFactory(), nil)
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
 	r := remediator.FailedPodRescheduler{}
 	err := r.Setup(suite.logger, suite.mockClient)
 	assert.Equal(suite.t, err, nil)
+	startInformers(suite.T(), factory, metadataFactory)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	r.Run(ctx, &wg)
 }
 
+func (suite *TestFailedPodReschedulerSuite) TestStopsWhenCancelledBeforePodsSynced() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	r := remediator.FailedPodRescheduler{}
+	assert.NilError(suite.t, r.Setup(suite.logger, suite.mockClient))
+
+	// informers are never started, so nothing is remediated
+	var wg sync.WaitGroup
+	wg.Add(1)
+	r.Run(ctx, &wg)
+}
+
 func (suite *TestFailedPodReschedulerSuite) TestReschedulesFailedPod() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
 	suite.run()
 }
 
 func (suite *TestFailedPodReschedulerSuite) TestLoopsOverAllPods() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: append(suite.pods, suite.pods...)}, nil)
-	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil).Times(2)
+	suite.pods = append(suite.pods, *suite.pods[0].DeepCopy())
+	suite.pods[1].ObjectMeta.Name = "otherPod"
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[1]).Return(nil)
 	suite.run()
 }
 
 func (suite *TestFailedPodReschedulerSuite) TestKeepsFailedPodWithoutOwnerReference() {
 	suite.pods[0].ObjectMeta.OwnerReferences = []metav1.OwnerReference{}
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.run()
 }
 
 func (suite *TestFailedPodReschedulerSuite) TestKeepsFailedPodsWhenTheyAreCleanup() {
 	suite.pods[0].ObjectMeta.OwnerReferences[0].Kind = "Job"
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.run()
 }
 
 func (suite *TestFailedPodReschedulerSuite) TestKeepsFailedPodsWithOtherReasons() {
 	suite.pods[0].Status.Reason = "fake"
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.run()
 }
 
 func (suite *TestFailedPodReschedulerSuite) TestDoesNotCrashWhenDeleteFails() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(errors.New("foo"))
 	suite.run()
 }
 
-func (suite *TestFailedPodReschedulerSuite) TestDoesNotCrashWhenListFails() {
-	suite.mockClient.EXPECT().GetPods("").Return(nil, errors.New("foo"))
+func (suite *TestFailedPodReschedulerSuite) TestDoesNotDeleteWhenPodIsNew() {
+	suite.pods[0].CreationTimestamp = metav1.Time{Time: time.Now().Add(-4 * time.Minute)}
 	suite.run()
 }
 
-func (suite *TestFailedPodReschedulerSuite) TestDoesNotDeleteWhenPodIsNew() {
-	suite.pods[0].CreationTimestamp = metav1.Time{time.Now().Add(-4 * time.Minute)}
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
-	suite.run()
+func (suite *TestFailedPodReschedulerSuite) TestDeletesPodOnceApprovedAndReportsApprovalsThatCanNotBeCompleted() {
+	core, logs := observer.New(zap.ErrorLevel)
+	suite.logger = zap.New(core)
+	configMaps := &fakeConfigMaps{}
+	approvals := approval.NewConfigMapStore(configMaps, "kube-system", approval.ConfigMapName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	suite.mockClient.EXPECT().DeletePod(gomock.Any()).DoAndReturn(func(pod *corev1.Pod) error {
+		assert.Equal(suite.t, pod.Name, "healthyPod")
+		configMaps.patchErr = errors.New("Foo") // the approval can not be completed after the delete
+		return nil
+	})
+	r := remediator.FailedPodRescheduler{}
+	r.Configure(remediator.Options{
+		Name:      "FailedPodRescheduler",
+		Approval:  policy.Approval{Required: true, Expiry: time.Hour},
+		Approvals: approvals,
+	})
+	assert.NilError(suite.t, r.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go r.Run(ctx, &wg)
+
+	var pending []approval.Remediation
+	for len(pending) == 0 {
+		time.Sleep(time.Millisecond)
+		var err error
+		pending, err = approvals.List(approval.Filter{State: approval.StatePending})
+		assert.NilError(suite.t, err)
+	}
+	_, err := approvals.Decide(pending[0].ID, true, "alice")
+	assert.NilError(suite.t, err)
+
+	// the remediator only hears about approvals once it subscribed
+	for logs.FilterMessage("Error completing approval").Len() == 0 {
+		time.Sleep(time.Millisecond)
+		approvals.Check()
+	}
+	cancel()
+	wg.Wait()
 }
@@ -0,0 +1,180 @@
+package remediator
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+// config of remediators that use flap protection
+var flapProtectionDefaults = map[string]interface{}{
+	"maxAttempts": 5, // 0 turns it off
+	"window":      "24h",
+	"backoff":     "10m",
+}
+
+// Stops remediating pods of a workload whose replacements keep failing, so humans take over.
+// Attempts are kept in annotations of the top-most controlling owner (for example the Deployment), so they survive restarts:
+// kube-remediator/<remediator>-attempts has the times of attempts within the window and
+// kube-remediator/<remediator>-exhausted is set once maxAttempts were made, until someone removes it.
+type flapProtection struct {
+	maxAttempts int
+	window      time.Duration
+	backoff     time.Duration // before the second attempt, doubled for every further one
+	lock        sync.Mutex
+	pending     map[types.UID]pendingAnnotations
+}
+
+// annotations we patched, used until the informer has the owner with them
+type pendingAnnotations struct {
+	resourceVersion string // of the owner before the patch
+	annotations     map[string]string
+}
+
+// nil when turned off, a nil flapProtection allows everything
+func newFlapProtection(config *viper.Viper) (*flapProtection, error) {
+	f := &flapProtection{
+		maxAttempts: config.GetInt("flapProtection.maxAttempts"),
+		pending:     map[types.UID]pendingAnnotations{},
+	}
+	var err error
+	if f.window, err = getDuration(config, "flapProtection.window"); err != nil {
+		return nil, err
+	}
+	if f.backoff, err = getDuration(config, "flapProtection.backoff"); err != nil {
+		return nil, err
+	}
+	if f.maxAttempts < 0 {
+		return nil, fmt.Errorf("flapProtection.maxAttempts can not be negative, got %d", f.maxAttempts)
+	}
+	if f.maxAttempts == 0 {
+		return nil, nil
+	}
+	if f.window <= 0 || f.backoff < 0 {
+		return nil, fmt.Errorf("flapProtection needs a positive window and backoff, got %q and %q",
+			config.GetString("flapProtection.window"), config.GetString("flapProtection.backoff"))
+	}
+	return f, nil
+}
+
+func (p *Base) attemptsAnnotation() string  { return optInPrefix + p.options.Name + "-attempts" }
+func (p *Base) exhaustedAnnotation() string { return optInPrefix + p.options.Name + "-exhausted" }
+
+// the owner attempts are tracked on and if the pod may be remediated now, owner is nil when not tracked
+func (p *Base) checkFlapProtection(pod *v1.Pod) (metav1.Object, bool) {
+	if p.flaps == nil {
+		return nil, true
+	}
+	owner := p.optIns.root(pod)
+	if owner == nil {
+		return nil, true // not a workload we know, nothing to track attempts on
+	}
+	annotations := p.flaps.annotations(owner)
+	logInfo := append(objectInfo(pod), zap.String("owner", kindOf(owner.(runtime.Object))+"/"+owner.GetName()))
+	if exhausted, found := annotations[p.exhaustedAnnotation()]; found {
+		p.logger.Debug("Skipping, remediation of owner exhausted", append(logInfo, zap.String("since", exhausted))...)
+		return owner, false
+	}
+	attempts := p.flaps.recentAttempts(annotations[p.attemptsAnnotation()], time.Now())
+	if len(attempts) > 0 {
+		next := attempts[len(attempts)-1].Add(p.flaps.backoff << (len(attempts) - 1))
+		if time.Now().Before(next) {
+			p.logger.Debug("Skipping, backing off from owner", append(logInfo, zap.Int("attempts", len(attempts)), zap.Time("until", next))...)
+			return owner, false
+		}
+	}
+	return owner, true
+}
+
+// remembers an attempt on the owner and marks it exhausted on the last one
+func (p *Base) recordAttempt(owner metav1.Object) {
+	if p.flaps == nil || owner == nil {
+		return
+	}
+	now := time.Now()
+	annotations := p.flaps.annotations(owner)
+	attempts := append(p.flaps.recentAttempts(annotations[p.attemptsAnnotation()], now), now)
+
+	changes := map[string]interface{}{}
+	if len(attempts) >= p.flaps.maxAttempts {
+		changes[p.attemptsAnnotation()] = nil // a fresh start once someone removes the exhausted mark
+		changes[p.exhaustedAnnotation()] = now.UTC().Format(time.RFC3339)
+		kind := kindOf(owner.(runtime.Object))
+		message := fmt.Sprintf("%s gave up on %s %s/%s after %d attempts within %s, remove annotation %s to resume",
+			p.options.Name, kind, owner.GetNamespace(), owner.GetName(), len(attempts), p.flaps.window, p.exhaustedAnnotation())
+		p.logger.Warn("Remediation of owner exhausted", zap.String("owner", kind+"/"+owner.GetName()),
+			zap.String("namespace", owner.GetNamespace()), zap.Int("attempts", len(attempts)))
+		metrics.UpdateRemediationExhaustedCount(p.options.Name)
+		p.recordEvent(owner.(runtime.Object), v1.EventTypeWarning, EventReasonExhausted, message)
+	} else {
+		var times []string
+		for _, attempt := range attempts {
+			times = append(times, attempt.UTC().Format(time.RFC3339))
+		}
+		changes[p.attemptsAnnotation()] = strings.Join(times, ",")
+	}
+	p.flaps.remember(owner, annotations, changes)
+
+	if p.options.DryRun {
+		return // only remembered in memory
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": changes},
+	})
+	if err := p.client.PatchOwner(kindOf(owner.(runtime.Object)), owner.GetNamespace(), owner.GetName(), patch); err != nil {
+		p.logger.Warn("Error annotating owner", zap.String("name", owner.GetName()),
+			zap.String("namespace", owner.GetNamespace()), zap.Error(err))
+	}
+}
+
+// annotations of the owner including ours the informer does not have yet
+func (f *flapProtection) annotations(owner metav1.Object) map[string]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if pending, found := f.pending[owner.GetUID()]; found {
+		if pending.resourceVersion == owner.GetResourceVersion() {
+			return pending.annotations
+		}
+		delete(f.pending, owner.GetUID()) // changed since, so it includes our patch
+	}
+	return owner.GetAnnotations()
+}
+
+// nil changes remove annotations like in the merge patch
+func (f *flapProtection) remember(owner metav1.Object, current map[string]string, changes map[string]interface{}) {
+	annotations := map[string]string{}
+	for key, value := range current {
+		annotations[key] = value
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(annotations, key)
+		} else {
+			annotations[key] = value.(string)
+		}
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pending[owner.GetUID()] = pendingAnnotations{resourceVersion: owner.GetResourceVersion(), annotations: annotations}
+}
+
+// attempts within the window, oldest first, unreadable ones are ignored
+func (f *flapProtection) recentAttempts(value string, now time.Time) []time.Time {
+	var attempts []time.Time
+	for _, item := range strings.Split(value, ",") {
+		attempt, err := time.Parse(time.RFC3339, item)
+		if err == nil && now.Sub(attempt) < f.window {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts
+}
@@ -0,0 +1,47 @@
+package remediator_test
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"testing"
+)
+
+// fake informers with the objects, everything but pods is also in the metadata informers,
+// like owners and namespaces are in the cluster
+func newInformerFactories(objects ...runtime.Object) (informers.SharedInformerFactory, metadatainformer.SharedInformerFactory) {
+	metadataScheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(metadataScheme)
+	var metadata []runtime.Object
+	for _, object := range objects {
+		if _, isPod := object.(*corev1.Pod); isPod {
+			continue
+		}
+		kinds, _, err := scheme.Scheme.ObjectKinds(object)
+		if err != nil {
+			panic(err)
+		}
+		accessor, _ := meta.Accessor(object)
+		partial := meta.AsPartialObjectMetadata(accessor)
+		partial.TypeMeta = metav1.TypeMeta{APIVersion: kinds[0].GroupVersion().String(), Kind: kinds[0].Kind}
+		metadata = append(metadata, partial)
+	}
+	return informers.NewSharedInformerFactory(fake.NewSimpleClientset(objects...), 0),
+		metadatainformer.NewSharedInformerFactory(metadatafake.NewSimpleMetadataClient(metadataScheme, metadata...), 0)
+}
+
+// starts the informers remediators requested in Setup and waits for them to sync, like main does after all are set up
+func startInformers(t *testing.T, factory informers.SharedInformerFactory, metadataFactory metadatainformer.SharedInformerFactory) {
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	factory.Start(stop)
+	metadataFactory.Start(stop)
+	factory.WaitForCacheSync(stop)
+	metadataFactory.WaitForCacheSync(stop)
+}
@@ -0,0 +1,58 @@
+package remediator
+
+import (
+	"encoding/json"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"time"
+)
+
+// LastRunsConfigMap is where periodic remediators remember when they last ran, in the namespace kube-remediator runs in
+const LastRunsConfigMap = "kube-remediator-last-runs"
+
+// what LastRuns needs from the client, implemented by k8s.Client
+type configMaps interface {
+	GetConfigMap(namespace string, name string) (*v1.ConfigMap, error)
+	PatchConfigMap(namespace string, name string, patch []byte) (*v1.ConfigMap, error)
+}
+
+// LastRuns keeps the start of the last successful run of every periodic remediator in a ConfigMap,
+// so restarts and new leaders only catch up on runs that were actually missed
+type LastRuns struct {
+	client    configMaps
+	namespace string
+	name      string
+}
+
+func NewLastRuns(client configMaps, namespace string, name string) *LastRuns {
+	return &LastRuns{client: client, namespace: namespace, name: name}
+}
+
+// zero when the remediator never ran
+func (r *LastRuns) Get(remediator string) (time.Time, error) {
+	configMap, err := r.client.GetConfigMap(r.namespace, r.name)
+	if apierrors.IsNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	value, found := configMap.Data[remediator]
+	if !found {
+		return time.Time{}, nil
+	}
+	last, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("last run of %s in ConfigMap %s/%s: %w", remediator, r.namespace, r.name, err)
+	}
+	return last, nil
+}
+
+func (r *LastRuns) Set(remediator string, at time.Time) error {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"data": map[string]string{remediator: at.UTC().Format(time.RFC3339)},
+	})
+	_, err := r.client.PatchConfigMap(r.namespace, r.name, patch)
+	return err
+}
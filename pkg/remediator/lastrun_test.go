@@ -0,0 +1,72 @@
+package remediator_test
+
+import (
+	"encoding/json"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"testing"
+	"time"
+)
+
+// a single ConfigMap, missing until something is patched into it like k8s.Client
+type fakeConfigMaps struct {
+	data     map[string]string
+	err      error
+	patchErr error
+}
+
+func (f *fakeConfigMaps) GetConfigMap(namespace string, name string) (*corev1.ConfigMap, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.data == nil {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+	}
+	return &corev1.ConfigMap{Data: f.data}, nil
+}
+
+func (f *fakeConfigMaps) PatchConfigMap(namespace string, name string, patch []byte) (*corev1.ConfigMap, error) {
+	if f.patchErr != nil {
+		return nil, f.patchErr
+	}
+	var changes corev1.ConfigMap
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, err
+	}
+	if f.data == nil {
+		f.data = map[string]string{}
+	}
+	for key, value := range changes.Data {
+		f.data[key] = value
+	}
+	return &corev1.ConfigMap{Data: f.data}, nil
+}
+
+func TestLastRunsAreZeroWithoutConfigMap(t *testing.T) {
+	lastRuns := remediator.NewLastRuns(&fakeConfigMaps{}, "default", remediator.LastRunsConfigMap)
+	last, err := lastRuns.Get("OldPodDeleter")
+	assert.NilError(t, err)
+	assert.Assert(t, last.IsZero())
+}
+
+func TestLastRunsRemembersRunsByRemediator(t *testing.T) {
+	lastRuns := remediator.NewLastRuns(&fakeConfigMaps{}, "default", remediator.LastRunsConfigMap)
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NilError(t, lastRuns.Set("OldPodDeleter", at))
+
+	last, err := lastRuns.Get("OldPodDeleter")
+	assert.NilError(t, err)
+	assert.Equal(t, last, at)
+	last, err = lastRuns.Get("CompletedPodDeleter")
+	assert.NilError(t, err)
+	assert.Assert(t, last.IsZero())
+}
+
+func TestLastRunsFailOnUnreadableTimes(t *testing.T) {
+	lastRuns := remediator.NewLastRuns(&fakeConfigMaps{data: map[string]string{"OldPodDeleter": "yesterday"}}, "default", "runs")
+	_, err := lastRuns.Get("OldPodDeleter")
+	assert.ErrorContains(t, err, "last run of OldPodDeleter in ConfigMap default/runs")
+}
@@ -0,0 +1,139 @@
+package remediator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"go.uber.org/zap"
+	"reflect"
+	"sync"
+)
+
+// Spec is everything a remediator is started with, when it changes the remediator is restarted
+type Spec struct {
+	Options Options       // Budgets are built by the Manager from Budget
+	Budget  policy.Budget // budget of this remediator, in addition to the global one
+	Config  string        // content of the registration's ConfigFile
+}
+
+// Manager runs remediators and restarts the ones affected by config changes
+type Manager struct {
+	logger        *zap.Logger
+	registrations []Registration
+	newLogger     func(name string) *zap.Logger
+	client        k8s.ClientInterface // shared by all remediators
+	globalBudget  *Budget
+	globalConfig  policy.Budget
+	budgets       map[string]*Budget // of remediators by name, kept when they are restarted or stopped
+	running       map[string]*runningRemediator
+}
+
+type runningRemediator struct {
+	spec   Spec
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+func NewManager(
+	logger *zap.Logger,
+	registrations []Registration,
+	newLogger func(name string) *zap.Logger,
+	client k8s.ClientInterface,
+) *Manager {
+	return &Manager{
+		logger:        logger,
+		registrations: registrations,
+		newLogger:     newLogger,
+		client:        client,
+		budgets:       map[string]*Budget{},
+		running:       map[string]*runningRemediator{},
+	}
+}
+
+// Apply starts remediators that have a spec, stops the ones that do not and restarts the ones whose spec changed.
+// A remediator that fails Setup keeps running with its previous spec, errors are returned once everything is applied.
+// The global budget is shared by all remediators. It and the budgets of remediators are kept between calls,
+// config changes keep what was used of them.
+func (m *Manager) Apply(ctx context.Context, globalBudget policy.Budget, specs map[string]Spec) error {
+	var errs []error
+
+	if globalBudget != m.globalConfig {
+		m.globalBudget = carryOverBudget(m.globalBudget, "global", globalBudget)
+		m.globalConfig = globalBudget
+		for _, running := range m.running {
+			running.spec = Spec{} // remediators hold on to the old budget, so restart all
+		}
+	}
+
+	for name, running := range m.running {
+		if _, found := specs[name]; !found {
+			m.logger.Info("Stopping disabled remediator", zap.String("remediator", name))
+			running.stop()
+			delete(m.running, name)
+		}
+	}
+
+	for _, registration := range m.registrations {
+		name := registration.Name
+		spec, found := specs[name]
+		if !found {
+			continue
+		}
+		current, running := m.running[name]
+		if running && reflect.DeepEqual(current.spec, spec) {
+			continue
+		}
+
+		logger := m.newLogger(name)
+		r, budget, err := m.setup(registration, spec, logger)
+		if err != nil {
+			if running {
+				logger.Error("Error applying new config, keeping the previous one", zap.Error(err))
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		if running {
+			logger.Info("Restarting to apply new config")
+			current.stop()
+		}
+		m.budgets[name] = budget
+		m.start(ctx, name, r, spec)
+	}
+
+	return errors.Join(errs...)
+}
+
+// stops all remediators and waits for them to finish
+func (m *Manager) Stop() {
+	for name, running := range m.running {
+		running.stop()
+		delete(m.running, name)
+	}
+}
+
+func (m *Manager) setup(registration Registration, spec Spec, logger *zap.Logger) (BaseIntf, *Budget, error) {
+	budget := carryOverBudget(m.budgets[registration.Name], registration.Name, spec.Budget)
+	options := spec.Options
+	options.Budgets = []*Budget{budget, m.globalBudget}
+
+	r := registration.New()
+	r.Configure(options)
+	return r, budget, r.Setup(logger, m.client)
+}
+
+func (m *Manager) start(ctx context.Context, name string, r BaseIntf, spec Spec) {
+	ctx, cancel := context.WithCancel(ctx)
+	running := &runningRemediator{spec: spec, cancel: cancel}
+	running.wg.Add(1)
+	go r.Run(ctx, &running.wg)
+	m.running[name] = running
+}
+
+func (r *runningRemediator) stop() {
+	r.cancel()
+	r.wg.Wait()
+}
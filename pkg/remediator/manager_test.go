@@ -0,0 +1,180 @@
+package remediator_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// records what the manager does with it
+type fakeRemediator struct {
+	remediator.Base
+	manager *TestManagerSuite
+	options remediator.Options
+}
+
+func (r *fakeRemediator) Configure(options remediator.Options) {
+	r.options = options
+}
+
+func (r *fakeRemediator) Setup(*zap.Logger, k8s.ClientInterface) error {
+	return r.manager.setupError
+}
+
+func (r *fakeRemediator) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	r.manager.record(r, 1)
+	<-ctx.Done()
+	r.manager.record(r, -1)
+}
+
+type TestManagerSuite struct {
+	suite.Suite
+	logger     *zap.Logger
+	manager    *remediator.Manager
+	ctx        context.Context
+	cancel     func()
+	setupError error
+	lock       sync.Mutex
+	running    map[string]int
+	starts     map[string]int
+	options    map[string]remediator.Options
+	t          *testing.T
+}
+
+func TestSuiteManager(t *testing.T) {
+	suite.Run(t, &TestManagerSuite{t: t})
+}
+
+func (suite *TestManagerSuite) SetupTest() {
+	suite.logger, _ = zap.NewDevelopment()
+	suite.setupError = nil
+	suite.running = map[string]int{}
+	suite.starts = map[string]int{}
+	suite.options = map[string]remediator.Options{}
+	suite.ctx, suite.cancel = context.WithCancel(context.Background())
+
+	var registrations []remediator.Registration
+	for _, name := range []string{"A", "B"} {
+		registrations = append(registrations, remediator.Registration{
+			Name: name,
+			New:  func() remediator.BaseIntf { return &fakeRemediator{manager: suite} },
+		})
+	}
+	client := mock_k8s.NewMockClientInterface(gomock.NewController(suite.t))
+	suite.manager = remediator.NewManager(
+		suite.logger,
+		registrations,
+		func(string) *zap.Logger { return suite.logger },
+		client,
+	)
+}
+
+func (suite *TestManagerSuite) TearDownTest() {
+	suite.manager.Stop()
+	suite.cancel()
+}
+
+func (suite *TestManagerSuite) record(r *fakeRemediator, delta int) {
+	suite.lock.Lock()
+	defer suite.lock.Unlock()
+	suite.running[r.options.Name] += delta
+	if delta > 0 {
+		suite.starts[r.options.Name]++
+		suite.options[r.options.Name] = r.options
+	}
+}
+
+// waits for started remediators to report in
+func (suite *TestManagerSuite) assertRunning(name string, running int, starts int) {
+	suite.Eventually(func() bool {
+		suite.lock.Lock()
+		defer suite.lock.Unlock()
+		return suite.running[name] == running && suite.starts[name] == starts
+	}, time.Second, time.Millisecond, "%s should be running %d times after %d starts", name, running, starts)
+}
+
+func (suite *TestManagerSuite) spec(name string, dryRun bool) remediator.Spec {
+	return remediator.Spec{Options: remediator.Options{Name: name, DryRun: dryRun}}
+}
+
+func (suite *TestManagerSuite) TestStartsRemediatorsWithSpec() {
+	err := suite.manager.Apply(suite.ctx, policy.Budget{}, map[string]remediator.Spec{"A": suite.spec("A", false)})
+	assert.NilError(suite.t, err)
+	suite.assertRunning("A", 1, 1)
+	suite.assertRunning("B", 0, 0)
+}
+
+func (suite *TestManagerSuite) TestKeepsUnchangedRemediatorsRunning() {
+	specs := map[string]remediator.Spec{"A": suite.spec("A", false)}
+	suite.manager.Apply(suite.ctx, policy.Budget{}, specs)
+	suite.manager.Apply(suite.ctx, policy.Budget{}, specs)
+	suite.assertRunning("A", 1, 1)
+}
+
+func (suite *TestManagerSuite) TestRestartsChangedRemediators() {
+	suite.manager.Apply(suite.ctx, policy.Budget{}, map[string]remediator.Spec{"A": suite.spec("A", false), "B": suite.spec("B", false)})
+	suite.manager.Apply(suite.ctx, policy.Budget{}, map[string]remediator.Spec{"A": suite.spec("A", true), "B": suite.spec("B", false)})
+	suite.assertRunning("A", 1, 2)
+	suite.assertRunning("B", 1, 1)
+	suite.True(suite.options["A"].DryRun)
+}
+
+func (suite *TestManagerSuite) TestRestartsAllWhenGlobalBudgetChanges() {
+	specs := map[string]remediator.Spec{"A": suite.spec("A", false), "B": suite.spec("B", false)}
+	suite.manager.Apply(suite.ctx, policy.Budget{}, specs)
+	suite.manager.Apply(suite.ctx, policy.Budget{MaxActions: 1, Window: time.Hour}, specs)
+	suite.assertRunning("A", 1, 2)
+	suite.assertRunning("B", 1, 2)
+	suite.NotNil(suite.options["A"].Budgets[1])
+}
+
+func (suite *TestManagerSuite) TestStopsRemovedRemediators() {
+	suite.manager.Apply(suite.ctx, policy.Budget{}, map[string]remediator.Spec{"A": suite.spec("A", false)})
+	suite.manager.Apply(suite.ctx, policy.Budget{}, map[string]remediator.Spec{})
+	suite.assertRunning("A", 0, 1)
+}
+
+func (suite *TestManagerSuite) TestKeepsPreviousConfigWhenSetupFails() {
+	suite.manager.Apply(suite.ctx, policy.Budget{}, map[string]remediator.Spec{"A": suite.spec("A", false)})
+	suite.setupError = errors.New("invalid")
+	err := suite.manager.Apply(suite.ctx, policy.Budget{}, map[string]remediator.Spec{"A": suite.spec("A", true)})
+	assert.ErrorContains(suite.t, err, "A: invalid")
+	suite.assertRunning("A", 1, 1)
+	suite.False(suite.options["A"].DryRun)
+}
+
+func (suite *TestManagerSuite) TestStopWaitsForRemediators() {
+	suite.manager.Apply(suite.ctx, policy.Budget{}, map[string]remediator.Spec{"A": suite.spec("A", false)})
+	suite.assertRunning("A", 1, 1)
+	suite.manager.Stop()
+	suite.assertRunning("A", 0, 1)
+}
+
+func (suite *TestManagerSuite) TestKeepsBudgetsWhenRestarting() {
+	budgeted := func(dryRun bool) map[string]remediator.Spec {
+		spec := suite.spec("A", dryRun)
+		spec.Budget = policy.Budget{MaxActions: 1, Window: time.Hour}
+		return map[string]remediator.Spec{"A": spec}
+	}
+	suite.manager.Apply(suite.ctx, policy.Budget{MaxActions: 1, Window: time.Hour}, budgeted(false))
+	suite.assertRunning("A", 1, 1)
+	budgets := suite.options["A"].Budgets
+
+	suite.manager.Apply(suite.ctx, policy.Budget{MaxActions: 1, Window: time.Hour}, budgeted(true))
+	suite.manager.Apply(suite.ctx, policy.Budget{MaxActions: 1, Window: time.Hour}, map[string]remediator.Spec{})
+	suite.manager.Apply(suite.ctx, policy.Budget{MaxActions: 1, Window: time.Hour}, budgeted(false))
+	suite.assertRunning("A", 1, 3)
+	suite.True(suite.options["A"].Budgets[0] == budgets[0])
+	suite.True(suite.options["A"].Budgets[1] == budgets[1])
+}
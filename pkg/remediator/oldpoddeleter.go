@@ -2,40 +2,106 @@ package remediator
 
 import (
 	"context"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"sync"
 	"time"
 )
 
+var OldPodDeleterConfigFile = "config/old_pod_deleter.json"
+
+var oldPodDeleterDefaults = withPeriodicDefaults(map[string]interface{}{
+	"minAge": "24h",
+})
+
 type OldPodDeleter struct {
 	Base
+	pods   coreinformers.PodInformer
+	period period
+	minAge time.Duration // namespaces can override it
+}
+
+func init() {
+	Register(Registration{
+		Name:       "OldPodDeleter",
+		New:        func() BaseIntf { return &OldPodDeleter{} },
+		Defaults:   oldPodDeleterDefaults,
+		ConfigFile: func() string { return OldPodDeleterConfigFile },
+		Rules:      append(podRules("list", "watch", "delete"), lastRunsRule),
+	})
+}
+
+func (p *OldPodDeleter) LoadConfig(logger *zap.Logger) error {
+	return readConfig(logger, OldPodDeleterConfigFile, oldPodDeleterDefaults, func(config *viper.Viper) (err error) {
+		if p.period, err = newPeriod(config); err != nil {
+			return err
+		}
+		if p.minAge, err = getDuration(config, "minAge"); err != nil {
+			return err
+		}
+		if p.minAge < 0 {
+			return fmt.Errorf("minAge can not be negative, got %q", config.GetString("minAge"))
+		}
+		return nil
+	})
+}
+
+func (p *OldPodDeleter) Setup(logger *zap.Logger, client k8s.ClientInterface) error {
+	if err := p.LoadConfig(logger); err != nil {
+		return err
+	}
+	if err := p.Base.Setup(logger, client); err != nil {
+		return err // untested section
+	}
+	p.requireOptIn = true // deleting pods just because they are old is only safe for workloads that expect it
+	p.pods = p.informers.Core().V1().Pods()
+	p.pods.Informer() // request it before the shared factory is started
+	p.reconsider = p.deleteIfOld
+	return nil
 }
 
 func (p *OldPodDeleter) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	p.reconcileEvery(ctx, p.deleteOldPods, 1*time.Hour)
+	if p.waitForCacheSync(ctx, p.pods) {
+		p.reconcileOnSchedule(ctx, p.deleteOldPods, p.period)
+	}
 }
 
-func (p *OldPodDeleter) deleteOldPods() {
+func (p *OldPodDeleter) periodic() {} // untested section
+
+func (p *OldPodDeleter) Reconcile() error {
+	return p.deleteOldPods()
+}
+
+func (p *OldPodDeleter) deleteOldPods() error {
 	p.logger.Info("Running")
 
-	// get all pods that opted in to deletion
-	pods, err := p.client.GetPods("", metav1.ListOptions{
-		LabelSelector: "kube-remediator/OldPodDeleter=true",
-	})
+	// opt-ins can also be on owners or namespaces, so all pods need to be checked
+	pods, err := p.pods.Lister().List(labels.Everything())
 	if err != nil {
-		p.logger.Error("Error getting pod list", zap.Error(err))
-		return
+		return fmt.Errorf("getting pod list: %w", err) // untested section
 	}
 
-	// deleteOldPods those that are too old
-	cutoff := time.Now().Add(-24 * time.Hour)
-	for _, pod := range pods.Items {
-		if pod.ObjectMeta.CreationTimestamp.Time.After(cutoff) {
-			continue
-		}
-		p.deletePod(pod)
+	for _, pod := range pods {
+		p.deleteIfOld(pod)
+	}
+	return nil
+}
+
+// deletes the pod when it opted in and is too old
+func (p *OldPodDeleter) deleteIfOld(pod *v1.Pod) {
+	if !p.isOptedIn(pod) {
+		return
+	}
+	cutoff := time.Now().Add(-p.options.Namespaces.MinAge(p.options.Name, pod.ObjectMeta.Namespace, p.minAge))
+	if pod.ObjectMeta.CreationTimestamp.Time.After(cutoff) {
+		return
 	}
+	p.removePod(*pod, fmt.Sprintf("Opted-in old pod age=%s", time.Since(pod.ObjectMeta.CreationTimestamp.Time).Round(time.Minute)))
 }
@@ -3,7 +3,9 @@ package remediator_test
 import (
 	"context"
 	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
 	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
 	"github.com/aksgithub/kube_remediator/pkg/remediator"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/suite"
@@ -11,6 +13,11 @@ import (
 	"gotest.tools/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -22,6 +29,8 @@ type TestOldPodDeleterSuite struct {
 	mockController *gomock.Controller
 	mockClient     *mock_k8s.MockClientInterface
 	pods           []corev1.Pod
+	objects        []runtime.Object // namespaces and owners of the pods
+	options        remediator.Options
 	t              *testing.T
 }
 
@@ -30,9 +39,12 @@ func TestSuiteOldPodDeleter(t *testing.T) {
 }
 
 func (suite *TestOldPodDeleterSuite) SetupTest() {
+	remediator.OldPodDeleterConfigFile = "../../config/old_pod_deleter.json"
 	suite.logger, _ = zap.NewDevelopment()
 	suite.mockController = gomock.NewController(suite.t)
 	suite.mockClient = mock_k8s.NewMockClientInterface(suite.mockController)
+	suite.options = remediator.Options{Name: "OldPodDeleter"}
+	suite.objects = nil
 	suite.pods = []corev1.Pod{{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              "foo",
@@ -49,10 +61,24 @@ func (suite *TestOldPodDeleterSuite) TeardownTest() {
 	suite.mockController.Finish()
 }
 
+// factories with the current pods and objects, started with startInformers after Setup
+func (suite *TestOldPodDeleterSuite) newInformerFactories() (informers.SharedInformerFactory, metadatainformer.SharedInformerFactory) {
+	objects := suite.objects
+	for i := range suite.pods {
+		objects = append(objects, &suite.pods[i])
+	}
+	return newInformerFactories(objects...)
+}
+
 func (suite *TestOldPodDeleterSuite) run() {
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
 	oldPodDeleter := remediator.OldPodDeleter{}
+	oldPodDeleter.Configure(suite.options)
 	err := oldPodDeleter.Setup(suite.logger, suite.mockClient)
 	assert.Equal(suite.t, err, nil)
+	startInformers(suite.T(), factory, metadataFactory)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // cancel first so we can just run once and exit
@@ -64,24 +90,165 @@ func (suite *TestOldPodDeleterSuite) run() {
 }
 
 func (suite *TestOldPodDeleterSuite) TestDeletesOldPods() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
 	suite.run()
 }
 
 func (suite *TestOldPodDeleterSuite) TestKeepsNewPods() {
 	suite.pods[0].ObjectMeta.CreationTimestamp = metav1.NewTime(time.Now().Add(-23 * time.Hour))
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.run()
 }
 
-func (suite *TestOldPodDeleterSuite) TestDoesNotCrashWhenListFails() {
-	suite.mockClient.EXPECT().GetPods("").Return(nil, errors.New("Foo"))
+func (suite *TestOldPodDeleterSuite) TestKeepsPodsWithoutLabel() {
+	suite.pods[0].ObjectMeta.Labels = map[string]string{}
 	suite.run()
 }
 
 func (suite *TestOldPodDeleterSuite) TestDoesNotCrashWhenDeleteFails() {
-	suite.mockClient.EXPECT().GetPods("").Return(&corev1.PodList{Items: suite.pods}, nil)
 	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(errors.New("Foo"))
 	suite.run()
 }
+
+func (suite *TestOldPodDeleterSuite) TestKeepsOldPodsInDryRun() {
+	suite.options.DryRun = true
+	suite.run()
+}
+
+func (suite *TestOldPodDeleterSuite) TestDeletesPodsOfOptedInNamespaces() {
+	suite.pods[0].ObjectMeta.Labels = map[string]string{}
+	suite.objects = append(suite.objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "default",
+		Labels: map[string]string{"kube-remediator/OldPodDeleter": "true"},
+	}})
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestOldPodDeleterSuite) TestKeepsPodsOptedOutInOptedInNamespaces() {
+	suite.pods[0].ObjectMeta.Labels = map[string]string{}
+	suite.pods[0].ObjectMeta.Annotations = map[string]string{"kube-remediator/OldPodDeleter": "false"}
+	suite.objects = append(suite.objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "default",
+		Annotations: map[string]string{"kube-remediator/OldPodDeleter": "true"},
+	}})
+	suite.run()
+}
+
+func (suite *TestOldPodDeleterSuite) useConfig(config string) {
+	remediator.OldPodDeleterConfigFile = filepath.Join(suite.t.TempDir(), "old_pod_deleter.json")
+	err := os.WriteFile(remediator.OldPodDeleterConfigFile, []byte(config), 0644)
+	assert.NilError(suite.t, err)
+}
+
+func (suite *TestOldPodDeleterSuite) setupError() error {
+	oldPodDeleter := remediator.OldPodDeleter{}
+	oldPodDeleter.Configure(suite.options)
+	return oldPodDeleter.Setup(suite.logger, suite.mockClient)
+}
+
+func (suite *TestOldPodDeleterSuite) TestUsesConfiguredMinAge() {
+	suite.useConfig(`{"minAge": "1h"}`)
+	suite.pods[0].ObjectMeta.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestOldPodDeleterSuite) TestRunsOnCronSchedule() {
+	suite.useConfig(`{"schedule": "CRON_TZ=Europe/Berlin 0 3 * * *"}`)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil) // catching up on start
+	suite.run()
+}
+
+func (suite *TestOldPodDeleterSuite) TestCatchesUpOnMissedRunAndRemembersIt() {
+	configMaps := &fakeConfigMaps{data: map[string]string{"OldPodDeleter": time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)}}
+	suite.options.LastRuns = remediator.NewLastRuns(configMaps, "default", remediator.LastRunsConfigMap)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+
+	last, err := suite.options.LastRuns.Get("OldPodDeleter")
+	assert.NilError(suite.t, err)
+	assert.Assert(suite.t, time.Since(last) < time.Minute)
+}
+
+func (suite *TestOldPodDeleterSuite) TestCatchesUpWhenLastRunsAreUnavailable() {
+	configMaps := &fakeConfigMaps{err: errors.New("Foo"), patchErr: errors.New("Foo")}
+	suite.options.LastRuns = remediator.NewLastRuns(configMaps, "default", remediator.LastRunsConfigMap)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestOldPodDeleterSuite) TestDoesNotCatchUpWhenNoRunWasMissed() {
+	configMaps := &fakeConfigMaps{data: map[string]string{"OldPodDeleter": time.Now().Add(-30 * time.Minute).UTC().Format(time.RFC3339)}}
+	suite.options.LastRuns = remediator.NewLastRuns(configMaps, "default", remediator.LastRunsConfigMap)
+	suite.run()
+}
+
+func (suite *TestOldPodDeleterSuite) TestWaitsForScheduleWhenSkippingCatchUp() {
+	suite.useConfig(`{"catchUp": "skip"}`)
+	suite.run()
+}
+
+func (suite *TestOldPodDeleterSuite) TestFailsWithInvalidSchedule() {
+	suite.useConfig(`{"schedule": "every hour"}`)
+	assert.ErrorContains(suite.t, suite.setupError(), `invalid schedule "every hour"`)
+}
+
+func (suite *TestOldPodDeleterSuite) TestFailsWithUnknownCatchUp() {
+	suite.useConfig(`{"catchUp": "all"}`)
+	assert.ErrorContains(suite.t, suite.setupError(), `unknown catchUp "all"`)
+}
+
+func (suite *TestOldPodDeleterSuite) TestFailsWithNegativeMinAge() {
+	suite.useConfig(`{"minAge": "-1h"}`)
+	assert.ErrorContains(suite.t, suite.setupError(), "minAge can not be negative")
+}
+
+func (suite *TestOldPodDeleterSuite) TestFailsWithUnknownFields() {
+	suite.useConfig(`{"minAg": "1h"}`)
+	err := suite.setupError()
+	assert.ErrorContains(suite.t, err, remediator.OldPodDeleterConfigFile)
+	assert.ErrorContains(suite.t, err, `unknown field "minAg"`)
+}
+
+func (suite *TestOldPodDeleterSuite) TestFailsWithWrongTypes() {
+	suite.useConfig(`{"minAge": 5}`)
+	assert.ErrorContains(suite.t, suite.setupError(), "minAge needs to be a string, got 5")
+}
+
+func (suite *TestOldPodDeleterSuite) TestFailsWithInvalidMinAge() {
+	suite.useConfig(`{"minAge": "soon"}`)
+	assert.ErrorContains(suite.t, suite.setupError(), `minAge needs to be a duration like 24h, got "soon"`)
+}
+
+func (suite *TestOldPodDeleterSuite) TestDeletesPodOnceApprovedWithoutWaitingForTheNextRun() {
+	approvals := approval.NewStore()
+	suite.options.Approval = policy.Approval{Required: true, Expiry: time.Hour}
+	suite.options.Approvals = approvals
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).DoAndReturn(func(*corev1.Pod) error {
+		cancel() // stop once the approved pod is deleted
+		return nil
+	})
+	oldPodDeleter := remediator.OldPodDeleter{}
+	oldPodDeleter.Configure(suite.options)
+	assert.NilError(suite.t, oldPodDeleter.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go oldPodDeleter.Run(ctx, &wg)
+
+	var pending []approval.Remediation
+	for len(pending) == 0 {
+		time.Sleep(time.Millisecond)
+		pending, _ = approvals.List(approval.Filter{State: approval.StatePending})
+	}
+	_, err := approvals.Decide(pending[0].ID, true, "alice")
+	assert.NilError(suite.t, err)
+	approvals.Check() // like approving on another replica, the next run is an hour away
+
+	wg.Wait()
+}
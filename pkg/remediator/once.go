@@ -0,0 +1,146 @@
+package remediator
+
+import (
+	"context"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/history"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"go.uber.org/zap"
+	"time"
+)
+
+// most actions a single pass keeps, more are dropped
+const onceLimit = 100000
+
+// Outcome is what a remediator did in RunOnce
+type Outcome struct {
+	Remediator   string          `json:"remediator"`
+	Error        string          `json:"error,omitempty"`    // Setup or the reconcile failed
+	Warnings     []string        `json:"warnings,omitempty"` // what a single pass can not do, see onceWarningsIntf
+	Remediations []history.Entry `json:"remediations"`
+}
+
+// if the remediator or any of its actions failed, blocked evictions are left for the next run
+func (o Outcome) Failed() bool {
+	if o.Error != "" {
+		return true
+	}
+	for _, remediation := range o.Remediations {
+		if remediation.Result == metrics.ResultError {
+			return true
+		}
+	}
+	return false
+}
+
+// implemented by remediators that need earlier runs for some actions, which a single pass does not have
+type onceWarningsIntf interface {
+	onceWarnings() []string
+}
+
+// RunOnce runs every remediator that has a spec once like on start and returns what each did, in registration order.
+// Remediators that follow pod updates only list pods once. Budgets are built like the Manager does and everything
+// else in the options applies as usual, except the history, which only keeps what this pass did.
+// Actions are done when it returns, notifications are sent after each remediator.
+func RunOnce(ctx context.Context, logger *zap.Logger, client k8s.ClientInterface, registrations []Registration, globalBudget policy.Budget, specs map[string]Spec) ([]Outcome, error) {
+	store, err := history.NewStore(onceLimit, "")
+	if err != nil {
+		return nil, err // untested section
+	}
+	global := NewBudget("global", globalBudget)
+	remediators, errs, err := setupOnce(ctx, logger, client, registrations, specs, func(name string, spec Spec) Options {
+		options := spec.Options
+		options.Budgets = []*Budget{NewBudget(name, spec.Budget), global}
+		options.History = store
+		return options
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var outcomes []Outcome
+	for _, registration := range registrations {
+		name := registration.Name
+		if err, failed := errs[name]; failed {
+			outcomes = append(outcomes, Outcome{Remediator: name, Error: err.Error(), Remediations: []history.Entry{}})
+			continue
+		}
+		r, found := remediators[name]
+		if !found {
+			continue
+		}
+		outcome := Outcome{Remediator: name, Remediations: []history.Entry{}}
+		if intf, ok := r.(onceWarningsIntf); ok {
+			outcome.Warnings = intf.onceWarnings()
+		}
+		started := time.Now()
+		if err := r.Reconcile(); err != nil {
+			logger.Error("Reconcile failed", zap.String("remediator", name), zap.Error(err))
+			outcome.Error = err.Error()
+		} else if _, periodic := r.(periodicIntf); periodic && specs[name].Options.LastRuns != nil {
+			// so the deployment does not catch up on a run this pass just did
+			if err := specs[name].Options.LastRuns.Set(name, started); err != nil {
+				logger.Warn("Error remembering last run", zap.String("remediator", name), zap.Error(err))
+			}
+		}
+		specs[name].Options.Notifier.Flush(name)
+		// newest first in the store, oldest first in the outcome
+		entries := store.List(history.Filter{Remediator: name})
+		for i := len(entries) - 1; i >= 0; i-- {
+			outcome.Remediations = append(outcome.Remediations, entries[i])
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, nil
+}
+
+// sets up every remediator that has a spec with the options it returns, starts the informers they requested and
+// waits for them to sync. Remediators that fail Setup are left out and their errors returned by name.
+func setupOnce(
+	ctx context.Context,
+	logger *zap.Logger,
+	client k8s.ClientInterface,
+	registrations []Registration,
+	specs map[string]Spec,
+	options func(name string, spec Spec) Options,
+) (map[string]BaseIntf, map[string]error, error) {
+	remediators := map[string]BaseIntf{}
+	errs := map[string]error{}
+	for _, registration := range registrations {
+		spec, found := specs[registration.Name]
+		if !found {
+			continue
+		}
+		r := registration.New()
+		r.Configure(options(registration.Name, spec))
+		if err := r.Setup(logger.With(zap.String("remediator", registration.Name)), client); err != nil {
+			errs[registration.Name] = err
+			continue
+		}
+		if intf, ok := r.(onceWarningsIntf); ok {
+			for _, warning := range intf.onceWarnings() {
+				logger.Warn(warning, zap.String("remediator", registration.Name))
+			}
+		}
+		remediators[registration.Name] = r
+	}
+
+	// remediators only requested their informers in Setup
+	factory := client.SharedInformerFactory()
+	factory.Start(ctx.Done())
+	for informer, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, nil, fmt.Errorf("waiting for %v to sync: %w", informer, ctx.Err())
+		}
+	}
+	metadataFactory := client.MetadataInformerFactory()
+	metadataFactory.Start(ctx.Done())
+	for resource, synced := range metadataFactory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, nil, fmt.Errorf("waiting for %v to sync: %w", resource, ctx.Err())
+		}
+	}
+	return remediators, errs, nil
+}
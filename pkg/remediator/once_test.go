@@ -0,0 +1,262 @@
+package remediator_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestRunOnceSuite struct {
+	suite.Suite
+	logger         *zap.Logger
+	mockController *gomock.Controller
+	mockClient     *mock_k8s.MockClientInterface
+	crashing       corev1.Pod
+	completed      corev1.Pod
+	globalBudget   policy.Budget
+	specs          map[string]remediator.Spec
+	names          []string
+	t              *testing.T
+}
+
+func TestSuiteRunOnce(t *testing.T) {
+	suite.Run(t, &TestRunOnceSuite{t: t})
+}
+
+func (suite *TestRunOnceSuite) SetupTest() {
+	remediator.CONFIG_FILE = "../../config/crash_loop_back_off_rescheduler.json"
+	remediator.CompletedPodDeleterConfigFile = "../../config/completed_pod_deleter.json"
+	suite.logger, _ = zap.NewDevelopment()
+	suite.mockController = gomock.NewController(suite.t)
+	suite.mockClient = mock_k8s.NewMockClientInterface(suite.mockController)
+
+	isController := true
+	suite.crashing = corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-1",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &isController}},
+		},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:         "web",
+			RestartCount: 6,
+			State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}}},
+	}
+	suite.completed = corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "backup-1",
+			Namespace:         "jobs",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-25 * time.Hour)),
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+	}
+	suite.globalBudget = policy.Budget{}
+	suite.specs = map[string]remediator.Spec{
+		"CrashLoopBackOffRescheduler": {Options: remediator.Options{Name: "CrashLoopBackOffRescheduler", Action: policy.ActionDelete}},
+		"CompletedPodDeleter":         {Options: remediator.Options{Name: "CompletedPodDeleter", Action: policy.ActionDelete}},
+	}
+	suite.names = []string{"CrashLoopBackOffRescheduler", "CompletedPodDeleter", "OldPodDeleter"}
+}
+
+func (suite *TestRunOnceSuite) TearDownTest() {
+	suite.mockController.Finish()
+}
+
+func (suite *TestRunOnceSuite) runOnce() []remediator.Outcome {
+	factory, metadataFactory := newInformerFactories([]runtime.Object{&suite.crashing, &suite.completed}...)
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory).AnyTimes()
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outcomes, err := suite.run(ctx)
+	assert.NilError(suite.t, err)
+	return outcomes
+}
+
+func (suite *TestRunOnceSuite) run(ctx context.Context) ([]remediator.Outcome, error) {
+	var registrations []remediator.Registration
+	for _, name := range suite.names {
+		registration, found := remediator.Lookup(name)
+		assert.Assert(suite.t, found)
+		registrations = append(registrations, registration)
+	}
+	return remediator.RunOnce(ctx, suite.logger, suite.mockClient, registrations, suite.globalBudget, suite.specs)
+}
+
+func (suite *TestRunOnceSuite) TestRemediatesOnceInRegistrationOrder() {
+	suite.mockClient.EXPECT().DeletePod(&suite.crashing).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.completed).Return(nil)
+
+	outcomes := suite.runOnce()
+
+	assert.Equal(suite.t, len(outcomes), 2)
+	assert.Equal(suite.t, outcomes[0].Remediator, "CrashLoopBackOffRescheduler")
+	assert.Equal(suite.t, len(outcomes[0].Remediations), 1)
+	assert.Equal(suite.t, outcomes[0].Remediations[0].Name, "web-1")
+	assert.Equal(suite.t, outcomes[0].Remediations[0].Result, "success")
+	assert.Equal(suite.t, outcomes[1].Remediator, "CompletedPodDeleter")
+	assert.Equal(suite.t, outcomes[1].Remediations[0].Name, "backup-1")
+	assert.Assert(suite.t, !outcomes[0].Failed() && !outcomes[1].Failed())
+}
+
+func (suite *TestRunOnceSuite) TestReportsFailedActions() {
+	suite.mockClient.EXPECT().DeletePod(&suite.crashing).Return(errors.New("Foo"))
+	suite.mockClient.EXPECT().DeletePod(&suite.completed).Return(nil)
+
+	outcomes := suite.runOnce()
+
+	assert.Equal(suite.t, outcomes[0].Remediations[0].Result, "error")
+	assert.Assert(suite.t, outcomes[0].Failed())
+	assert.Assert(suite.t, !outcomes[1].Failed())
+}
+
+func (suite *TestRunOnceSuite) TestReportsFailedSetup() {
+	remediator.CompletedPodDeleterConfigFile = filepath.Join(suite.t.TempDir(), "broken.json")
+	assert.NilError(suite.t, os.WriteFile(remediator.CompletedPodDeleterConfigFile, []byte(`{"namespace": `), 0644))
+	suite.mockClient.EXPECT().DeletePod(&suite.crashing).Return(nil)
+
+	outcomes := suite.runOnce()
+
+	assert.Equal(suite.t, len(outcomes), 2)
+	assert.Equal(suite.t, outcomes[1].Remediator, "CompletedPodDeleter")
+	assert.Assert(suite.t, strings.Contains(outcomes[1].Error, "broken.json"))
+	assert.Assert(suite.t, outcomes[1].Failed())
+}
+
+func (suite *TestRunOnceSuite) TestSharesGlobalBudget() {
+	suite.globalBudget = policy.Budget{MaxActions: 1, Window: time.Hour}
+	suite.mockClient.EXPECT().DeletePod(&suite.crashing).Return(nil)
+
+	outcomes := suite.runOnce()
+
+	assert.Equal(suite.t, len(outcomes[0].Remediations), 1)
+	assert.Equal(suite.t, len(outcomes[1].Remediations), 0) // budget exhausted is retried on the next run
+	assert.Assert(suite.t, !outcomes[1].Failed())
+}
+
+func (suite *TestRunOnceSuite) TestDryRun() {
+	for name, spec := range suite.specs {
+		spec.Options.DryRun = true
+		suite.specs[name] = spec
+	}
+
+	outcomes := suite.runOnce() // the mock fails on any change
+
+	assert.Equal(suite.t, outcomes[0].Remediations[0].Result, "dry_run")
+	assert.Equal(suite.t, outcomes[1].Remediations[0].Result, "dry_run")
+}
+
+func (suite *TestRunOnceSuite) TestReportsFailedReconciles() {
+	remediator.PersistentVolumeClaimCleanerConfigFile = "../../config/persistent_volume_claim_cleaner.json"
+	suite.names = []string{"PersistentVolumeClaimCleaner"}
+	suite.specs = map[string]remediator.Spec{
+		"PersistentVolumeClaimCleaner": {Options: remediator.Options{Name: "PersistentVolumeClaimCleaner", Action: policy.ActionDelete}},
+	}
+	suite.mockClient.EXPECT().GetPersistentVolumeClaims("", metav1.ListOptions{}).Return(nil, errors.New("Foo"))
+
+	outcomes := suite.runOnce()
+
+	assert.Equal(suite.t, len(outcomes), 1)
+	assert.Equal(suite.t, outcomes[0].Error, "getting persistent volume claim list: Foo")
+	assert.Assert(suite.t, outcomes[0].Failed())
+}
+
+func (suite *TestRunOnceSuite) TestFailsWhenInformersDoNotSync() {
+	factory, metadataFactory := newInformerFactories(&suite.crashing, &suite.completed)
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory).AnyTimes()
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory).AnyTimes()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := suite.run(ctx)
+
+	assert.ErrorContains(suite.t, err, "waiting for")
+	assert.Assert(suite.t, errors.Is(err, context.Canceled))
+}
+
+func (suite *TestRunOnceSuite) TestFailsWhenMetadataInformersDoNotSync() {
+	factory, metadataFactory := newInformerFactories(&suite.crashing, &suite.completed)
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory).AnyTimes()
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory).AnyTimes()
+	// pods synced before, opt-in metadata informers only get requested in Setup
+	factory.Core().V1().Pods().Informer()
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := suite.run(ctx)
+
+	assert.ErrorContains(suite.t, err, "waiting for")
+	assert.Assert(suite.t, errors.Is(err, context.Canceled))
+}
+
+func (suite *TestRunOnceSuite) TestRemembersRunsOfPeriodicRemediators() {
+	configMaps := &fakeConfigMaps{}
+	lastRuns := remediator.NewLastRuns(configMaps, "kube-system", remediator.LastRunsConfigMap)
+	for name, spec := range suite.specs {
+		spec.Options.LastRuns = lastRuns
+		suite.specs[name] = spec
+	}
+	suite.mockClient.EXPECT().DeletePod(&suite.crashing).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.completed).Return(nil)
+	started := time.Now()
+
+	suite.runOnce()
+
+	last, err := lastRuns.Get("CompletedPodDeleter")
+	assert.NilError(suite.t, err)
+	assert.Assert(suite.t, !last.Before(started.Truncate(time.Second)))
+	last, err = lastRuns.Get("CrashLoopBackOffRescheduler")
+	assert.NilError(suite.t, err)
+	assert.Assert(suite.t, last.IsZero()) // follows pod updates, it has no schedule to catch up on
+}
+
+func (suite *TestRunOnceSuite) TestKeepsGoingWhenRunsCanNotBeRemembered() {
+	lastRuns := remediator.NewLastRuns(&fakeConfigMaps{patchErr: errors.New("Foo")}, "kube-system", remediator.LastRunsConfigMap)
+	spec := suite.specs["CompletedPodDeleter"]
+	spec.Options.LastRuns = lastRuns
+	suite.specs["CompletedPodDeleter"] = spec
+	suite.mockClient.EXPECT().DeletePod(&suite.crashing).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.completed).Return(nil)
+
+	outcomes := suite.runOnce()
+
+	assert.Assert(suite.t, !outcomes[1].Failed())
+}
+
+func (suite *TestRunOnceSuite) TestWarnsAboutRulesThatNeedEarlierRuns() {
+	remediator.RuleRemediatorConfigFile = filepath.Join(suite.t.TempDir(), "rule_remediator.json")
+	rules := `{"rules": [
+		{"name": "stuck-pending", "condition": "pod.status.phase == 'Pending'", "for": "1h", "action": "delete"},
+		{"name": "crashing", "condition": "pod.status.phase == 'Failed'", "action": "delete"}
+	]}`
+	assert.NilError(suite.t, os.WriteFile(remediator.RuleRemediatorConfigFile, []byte(rules), 0644))
+	suite.names = []string{"RuleRemediator"}
+	suite.specs = map[string]remediator.Spec{
+		"RuleRemediator": {Options: remediator.Options{Name: "RuleRemediator", Action: policy.ActionDelete}},
+	}
+
+	outcomes := suite.runOnce()
+
+	assert.DeepEqual(suite.t, outcomes[0].Warnings, []string{"rule stuck-pending never acts in a single pass, its condition needs to hold for 1h0m0s"})
+	assert.Assert(suite.t, !outcomes[0].Failed())
+}
@@ -0,0 +1,118 @@
+package remediator
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Objects opt in or out of a remediator with the annotation or label kube-remediator/<remediator name> set to "true" or "false".
+// The object wins over its controlling owners, which win over its namespace,
+// so a whole namespace can opt out and a single workload in it can opt back in.
+const optInPrefix = "kube-remediator/"
+
+// controlling owners that are followed, by kind
+var ownerResources = map[string]schema.GroupVersionResource{
+	"ReplicaSet":  {Group: "apps", Version: "v1", Resource: "replicasets"},
+	"Deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"StatefulSet": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+	"DaemonSet":   {Group: "apps", Version: "v1", Resource: "daemonsets"},
+	"Job":         {Group: "batch", Version: "v1", Resource: "jobs"},
+	"CronJob":     {Group: "batch", Version: "v1", Resource: "cronjobs"},
+}
+
+var namespaceResource = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// finds opt-ins on owners and namespaces through shared informers that only cache metadata,
+// since annotations, labels and ownerReferences are all that is needed
+type optIns struct {
+	namespaces cache.GenericLister
+	owners     map[string]cache.GenericLister // by kind
+	synced     []cache.InformerSynced
+}
+
+func newOptIns(factory metadatainformer.SharedInformerFactory) *optIns {
+	o := &optIns{owners: map[string]cache.GenericLister{}}
+	// requested before the shared factory is started
+	namespaces := factory.ForResource(namespaceResource)
+	o.namespaces = namespaces.Lister()
+	o.synced = append(o.synced, namespaces.Informer().HasSynced)
+	for kind, resource := range ownerResources {
+		owners := factory.ForResource(resource)
+		o.owners[kind] = owners.Lister()
+		o.synced = append(o.synced, owners.Informer().HasSynced)
+	}
+	return o
+}
+
+// metadata of the namespace, nil when it is not known
+func (o *optIns) namespace(name string) *metav1.PartialObjectMetadata {
+	if o == nil {
+		return nil
+	}
+	namespace, err := o.namespaces.Get(name)
+	if err != nil {
+		return nil
+	}
+	return namespace.(*metav1.PartialObjectMetadata)
+}
+
+// the closest explicit choice for the object, found is false when nothing sets any of the keys.
+// A nil *optIns only looks at the object itself.
+func (o *optIns) lookup(object metav1.Object, keys []string) (optedIn bool, found bool) {
+	for current := object; current != nil; current = o.controller(current) {
+		if optedIn, found := optInValue(current, keys); found {
+			return optedIn, true
+		}
+	}
+	namespace := o.namespace(object.GetNamespace())
+	if namespace == nil {
+		return false, false
+	}
+	return optInValue(namespace, keys)
+}
+
+// keys are checked in order, annotations before labels
+func optInValue(object metav1.Object, keys []string) (bool, bool) {
+	for _, key := range keys {
+		for _, values := range []map[string]string{object.GetAnnotations(), object.GetLabels()} {
+			switch values[key] {
+			case "true":
+				return true, true
+			case "false":
+				return false, true
+			}
+		}
+	}
+	return false, false
+}
+
+// top-most controlling owner of the object, like the Deployment of a pod, nil when it has none
+func (o *optIns) root(object metav1.Object) metav1.Object {
+	var root metav1.Object
+	for owner := o.controller(object); owner != nil; owner = o.controller(owner) {
+		root = owner
+	}
+	return root
+}
+
+// controlling owner of the object, nil when it has none or it is gone.
+// Owners only have metadata, with the kind of the reference so they can be told apart.
+func (o *optIns) controller(object metav1.Object) metav1.Object {
+	reference := metav1.GetControllerOfNoCopy(object)
+	if o == nil || reference == nil {
+		return nil
+	}
+	lister, found := o.owners[reference.Kind]
+	if !found {
+		return nil
+	}
+	cached, err := lister.ByNamespace(object.GetNamespace()).Get(reference.Name)
+	if err != nil {
+		return nil
+	}
+	owner := *cached.(*metav1.PartialObjectMetadata) // the cache is shared, so only the copy gets the kind
+	owner.TypeMeta = metav1.TypeMeta{APIVersion: reference.APIVersion, Kind: reference.Kind}
+	return &owner
+}
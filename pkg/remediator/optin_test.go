@@ -0,0 +1,32 @@
+package remediator
+
+import (
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"testing"
+)
+
+func ownedBy(kind string, annotations map[string]string) *metav1.ObjectMeta {
+	controller := true
+	return &metav1.ObjectMeta{
+		Namespace:       "default",
+		Annotations:     annotations,
+		OwnerReferences: []metav1.OwnerReference{{Kind: kind, Name: "owner", Controller: &controller}},
+	}
+}
+
+func TestNilOptInsOnlyLookAtTheObject(t *testing.T) {
+	var o *optIns
+	keys := []string{optInPrefix + "A"}
+
+	optedIn, found := o.lookup(ownedBy("ReplicaSet", map[string]string{optInPrefix + "A": "true"}), keys)
+	assert.Assert(t, optedIn && found)
+	_, found = o.lookup(ownedBy("ReplicaSet", nil), keys)
+	assert.Assert(t, !found)
+}
+
+func TestOptInsIgnoreOwnersOfOtherKinds(t *testing.T) {
+	o := &optIns{owners: map[string]cache.GenericLister{}}
+	assert.Assert(t, o.controller(ownedBy("Widget", nil)) == nil)
+}
@@ -0,0 +1,131 @@
+package remediator
+
+import (
+	"context"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// what periodic remediators do about runs they missed while they were not running (restart, another leader).
+// Runs missed while a run takes longer than the schedule are never made up for, the next run is the next scheduled one.
+const (
+	CatchUpOnce = "once" // run right away when starting if a run was missed, a single run makes up for all missed ones
+	CatchUpSkip = "skip" // wait for the next scheduled run
+)
+
+// config shared by periodic remediators
+var periodicDefaults = map[string]interface{}{
+	"schedule": "@every 1h",
+	"catchUp":  CatchUpOnce,
+}
+
+// defaults of a periodic remediator's config, including the shared ones
+func withPeriodicDefaults(defaults map[string]interface{}) map[string]interface{} {
+	all := map[string]interface{}{}
+	for key, value := range periodicDefaults {
+		all[key] = value
+	}
+	for key, value := range defaults {
+		all[key] = value
+	}
+	return all
+}
+
+// implemented by periodic remediators, RunOnce remembers their runs like the schedule does
+type periodicIntf interface {
+	periodic()
+}
+
+// when a periodic remediator runs
+type period struct {
+	schedule cron.Schedule
+	catchUp  string
+}
+
+// reads schedule and catchUp, schedule is a cron expression like "0 3 * * *" or "@every 1h",
+// a CRON_TZ=Europe/Berlin prefix sets the timezone, which is otherwise the local one (UTC in the image)
+func newPeriod(config *viper.Viper) (period, error) {
+	schedule, err := cron.ParseStandard(config.GetString("schedule"))
+	if err != nil {
+		return period{}, fmt.Errorf("invalid schedule %q: %w", config.GetString("schedule"), err)
+	}
+	catchUp := config.GetString("catchUp")
+	if catchUp != CatchUpOnce && catchUp != CatchUpSkip {
+		return period{}, fmt.Errorf("unknown catchUp %q, use %q or %q", catchUp, CatchUpOnce, CatchUpSkip)
+	}
+	return period{schedule: schedule, catchUp: catchUp}, nil
+}
+
+// like reconcileEvery, but on the configured schedule.
+// Failed runs are retried with backoff until the next scheduled run.
+func (p *Base) reconcileOnSchedule(ctx context.Context, fn func() error, period period) {
+	defer metrics.DeleteNextRun(p.options.Name)
+
+	p.logStartAndStop(func() {
+		var err error
+		if period.catchUp == CatchUpOnce && p.missedRun(period, time.Now()) {
+			err = p.runScheduled(fn)
+		}
+
+		var retry backoff
+		for {
+			now := time.Now()
+			next := period.schedule.Next(now)
+			interval := period.schedule.Next(next).Sub(next)
+			next = next.Add(jitter(interval))
+			retrying := false
+			if err != nil {
+				if at := now.Add(wait.Jitter(retry.next(interval), jitterFactor)); at.Before(next) {
+					next, retrying = at, true
+				}
+			} else {
+				retry.reset()
+			}
+			p.logger.Info("Next run", zap.Time("at", next), zap.Bool("retry", retrying))
+			metrics.SetNextRun(p.options.Name, next)
+
+			if !sleep(ctx, time.Until(next)) {
+				return
+			}
+			err = p.runScheduled(fn)
+		}
+	})
+}
+
+// if a scheduled run should have started since the last run, unknown last runs count as missed
+func (p *Base) missedRun(period period, now time.Time) bool {
+	if p.options.LastRuns == nil {
+		return true
+	}
+	last, err := p.options.LastRuns.Get(p.options.Name)
+	if err != nil {
+		p.logger.Warn("Error reading last run, catching up", zap.Error(err))
+		return true
+	}
+	if last.IsZero() {
+		return true
+	}
+	if missed := period.schedule.Next(last); missed.After(now) {
+		p.logger.Info("No run missed, not catching up", zap.Time("last_run", last))
+		return false
+	}
+	p.logger.Info("Catching up on missed run", zap.Time("last_run", last))
+	return true
+}
+
+// like reconcile, remembers when successful runs started
+func (p *Base) runScheduled(fn func() error) error {
+	started := time.Now()
+	err := p.reconcile(fn)
+	if err == nil && p.options.LastRuns != nil {
+		if err := p.options.LastRuns.Set(p.options.Name, started); err != nil {
+			p.logger.Warn("Error remembering last run", zap.Error(err))
+		}
+	}
+	return err
+}
@@ -0,0 +1,304 @@
+package remediator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var PersistentVolumeClaimCleanerConfigFile = "config/persistent_volume_claim_cleaner.json"
+
+// marks when a claim was first seen without a StatefulSet, so the threshold survives restarts
+const orphanedSinceAnnotation = "kube-remediator/orphaned-since"
+
+// records the StatefulSet that made a claim while it still exists, so it is known once the StatefulSet is gone
+const statefulSetAnnotation = "kube-remediator/statefulset"
+
+var persistentVolumeClaimCleanerDefaults = withPeriodicDefaults(map[string]interface{}{
+	"annotation": "kube-remediator/PersistentVolumeClaimCleaner",
+	"threshold":  "168h",
+	"namespace":  "",
+})
+
+// StatefulSets name their claims <volumeClaimTemplate>-<statefulset>-<ordinal>
+var (
+	ordinal          = regexp.MustCompile(`^[0-9]+$`)
+	statefulSetClaim = regexp.MustCompile(`^.+-.+-[0-9]+$`)
+)
+
+// claims of an existing StatefulSet start with prefix, the StatefulSet labels them with its selector
+type claimTemplate struct {
+	prefix      string
+	statefulSet string
+	selector    labels.Selector
+}
+
+type PersistentVolumeClaimCleaner struct {
+	Base
+	threshold time.Duration
+	namespace string
+	period    period
+	pods      coreinformers.PodInformer
+	orphaned  map[string]time.Time // first seen orphaned, used when the claim is not annotated yet (dry-run)
+	madeBy    map[string]string    // StatefulSet of claims, used when the claim is not annotated yet (dry-run)
+}
+
+func init() {
+	Register(Registration{
+		Name:       "PersistentVolumeClaimCleaner",
+		New:        func() BaseIntf { return &PersistentVolumeClaimCleaner{} },
+		Defaults:   persistentVolumeClaimCleanerDefaults,
+		ConfigFile: func() string { return PersistentVolumeClaimCleanerConfigFile },
+		Rules: append(baseRules(),
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims"}, Verbs: []string{"list", "patch", "delete"}},
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"persistentvolumes"}, Verbs: []string{"get"}},
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list", "watch"}},
+			rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"list"}},
+			lastRunsRule,
+		),
+	})
+}
+
+func (p *PersistentVolumeClaimCleaner) LoadConfig(logger *zap.Logger) error {
+	return readConfig(logger, PersistentVolumeClaimCleanerConfigFile, persistentVolumeClaimCleanerDefaults, func(config *viper.Viper) (err error) {
+		if p.period, err = newPeriod(config); err != nil {
+			return err
+		}
+		if p.threshold, err = getDuration(config, "threshold"); err != nil {
+			return err
+		}
+		if p.threshold <= 0 {
+			return fmt.Errorf("threshold needs to be positive, got %q", config.GetString("threshold"))
+		}
+		p.namespace = config.GetString("namespace")
+		if err := validateNamespace("namespace", p.namespace); err != nil {
+			return err
+		}
+		if annotation := config.GetString("annotation"); annotation != "" {
+			p.optInAliases = []string{annotation}
+		}
+		return nil
+	})
+}
+
+func (p *PersistentVolumeClaimCleaner) Setup(logger *zap.Logger, client k8s.ClientInterface) error {
+	if err := p.LoadConfig(logger); err != nil {
+		return err
+	}
+	p.orphaned = map[string]time.Time{}
+	p.madeBy = map[string]string{}
+	if err := p.Base.Setup(logger, client); err != nil {
+		return err // untested section
+	}
+	p.pods = p.informers.Core().V1().Pods()
+	p.pods.Informer() // request it before the shared factory is started
+	return nil
+}
+
+func (p *PersistentVolumeClaimCleaner) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if p.waitForCacheSync(ctx, p.pods) {
+		p.reconcileOnSchedule(ctx, p.deleteOrphanedClaims, p.period)
+	}
+}
+
+func (p *PersistentVolumeClaimCleaner) periodic() {} // untested section
+
+func (p *PersistentVolumeClaimCleaner) Reconcile() error {
+	return p.deleteOrphanedClaims()
+}
+
+func (p *PersistentVolumeClaimCleaner) deleteOrphanedClaims() error {
+	p.logger.Info("Running")
+
+	pvcs, err := p.client.GetPersistentVolumeClaims(p.namespace, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("getting persistent volume claim list: %w", err)
+	}
+	statefulSets, err := p.client.GetStatefulSets(p.namespace, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("getting stateful set list: %w", err)
+	}
+
+	var templates []claimTemplate
+	for _, statefulSet := range statefulSets.Items {
+		selector, err := metav1.LabelSelectorAsSelector(statefulSet.Spec.Selector)
+		if err != nil {
+			selector = labels.Nothing() // claims of broken StatefulSets are kept, but never recorded as theirs
+		}
+		for _, template := range statefulSet.Spec.VolumeClaimTemplates {
+			templates = append(templates, claimTemplate{
+				prefix:      statefulSet.ObjectMeta.Namespace + "/" + template.ObjectMeta.Name + "-" + statefulSet.ObjectMeta.Name + "-",
+				statefulSet: statefulSet.ObjectMeta.Name,
+				selector:    selector,
+			})
+		}
+	}
+
+	mounted, err := p.mountedClaims()
+	if err != nil {
+		return fmt.Errorf("getting pod list: %w", err) // untested section
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		key := pvc.ObjectMeta.Namespace + "/" + pvc.ObjectMeta.Name
+		if !p.isCandidate(pvc) {
+			continue
+		}
+		if template := claimTemplateOf(key, templates); template != nil {
+			if template.selector.Matches(labels.Set(pvc.ObjectMeta.Labels)) {
+				p.recordStatefulSet(pvc, template.statefulSet)
+			}
+			p.unmarkOrphaned(pvc)
+			continue
+		}
+		if mounted[key] {
+			p.unmarkOrphaned(pvc)
+			continue
+		}
+		statefulSet, found := p.statefulSetOf(pvc)
+		if !found {
+			continue // not from a StatefulSet
+		}
+		if p.isRetained(pvc) {
+			continue // never marked, the volume is kept either way
+		}
+
+		since := p.orphanedSince(pvc)
+		if time.Since(since) < p.options.Namespaces.MinAge(p.options.Name, pvc.ObjectMeta.Namespace, p.threshold) {
+			continue
+		}
+		reason := fmt.Sprintf("Left behind by deleted StatefulSet %s orphaned=%s", statefulSet, time.Since(since).Round(time.Minute))
+		if statefulSet == "" {
+			reason = fmt.Sprintf("Named like a claim of a deleted StatefulSet orphaned=%s", time.Since(since).Round(time.Minute))
+		}
+		p.mutate("delete", pvc, reason, func() error {
+			return p.client.DeletePersistentVolumeClaim(pvc)
+		})
+	}
+	return nil
+}
+
+// claims that are not cleaned up by something else
+func (p *PersistentVolumeClaimCleaner) isCandidate(pvc *v1.PersistentVolumeClaim) bool {
+	return pvc.ObjectMeta.DeletionTimestamp == nil &&
+		len(pvc.ObjectMeta.OwnerReferences) == 0 && // garbage collected with their owner
+		p.outOfScope(pvc) == "" &&
+		p.isOptedIn(pvc)
+}
+
+// claims used by pods, which can outlive their StatefulSet when it was deleted with --cascade=orphan
+func (p *PersistentVolumeClaimCleaner) mountedClaims() (map[string]bool, error) {
+	pods, err := p.pods.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err // untested section
+	}
+	mounted := map[string]bool{}
+	for _, pod := range pods {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				mounted[pod.ObjectMeta.Namespace+"/"+volume.PersistentVolumeClaim.ClaimName] = true
+			}
+		}
+	}
+	return mounted, nil
+}
+
+// volumes that should be kept would be released by deleting their claim, so they are left alone
+func (p *PersistentVolumeClaimCleaner) isRetained(pvc *v1.PersistentVolumeClaim) bool {
+	if pvc.Spec.VolumeName == "" {
+		return false // never bound
+	}
+	pv, err := p.client.GetPersistentVolume(pvc.Spec.VolumeName)
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		p.logger.Error("Error getting persistent volume", append(objectInfo(pvc), zap.Error(err))...)
+		return true
+	}
+	return pv.Spec.PersistentVolumeReclaimPolicy == v1.PersistentVolumeReclaimRetain
+}
+
+// marking is bookkeeping and not an action, so it is not budgeted, but dry-run only remembers it in memory
+func (p *PersistentVolumeClaimCleaner) orphanedSince(pvc *v1.PersistentVolumeClaim) time.Time {
+	if since, err := time.Parse(time.RFC3339, pvc.ObjectMeta.Annotations[orphanedSinceAnnotation]); err == nil {
+		return since
+	}
+	key := pvc.ObjectMeta.Namespace + "/" + pvc.ObjectMeta.Name
+	since, found := p.orphaned[key]
+	if !found {
+		since = time.Now()
+		p.orphaned[key] = since
+	}
+	if !p.options.DryRun {
+		p.logger.Info("Marking orphaned persistent volume claim", objectInfo(pvc)...)
+		p.annotate(pvc, orphanedSinceAnnotation, since.UTC().Format(time.RFC3339))
+	}
+	return since
+}
+
+// the claim is used again, for example by a re-created StatefulSet
+func (p *PersistentVolumeClaimCleaner) unmarkOrphaned(pvc *v1.PersistentVolumeClaim) {
+	delete(p.orphaned, pvc.ObjectMeta.Namespace+"/"+pvc.ObjectMeta.Name)
+	if _, found := pvc.ObjectMeta.Annotations[orphanedSinceAnnotation]; found && !p.options.DryRun {
+		p.annotate(pvc, orphanedSinceAnnotation, nil)
+	}
+}
+
+// the claim matches a volumeClaimTemplate and the selector of an existing StatefulSet, bookkeeping like marking
+func (p *PersistentVolumeClaimCleaner) recordStatefulSet(pvc *v1.PersistentVolumeClaim, statefulSet string) {
+	p.madeBy[pvc.ObjectMeta.Namespace+"/"+pvc.ObjectMeta.Name] = statefulSet
+	if pvc.ObjectMeta.Annotations[statefulSetAnnotation] != statefulSet && !p.options.DryRun {
+		p.logger.Info("Recording StatefulSet of persistent volume claim", append(objectInfo(pvc), zap.String("statefulset", statefulSet))...)
+		p.annotate(pvc, statefulSetAnnotation, statefulSet)
+	}
+}
+
+// StatefulSet the claim was seen with. Claims never seen with one, like those orphaned before the first run or of
+// StatefulSets that came and went between runs, are only known by their name, which does not say where the
+// StatefulSet name starts, so it is "". False when the claim is not from a StatefulSet.
+func (p *PersistentVolumeClaimCleaner) statefulSetOf(pvc *v1.PersistentVolumeClaim) (string, bool) {
+	if statefulSet := pvc.ObjectMeta.Annotations[statefulSetAnnotation]; statefulSet != "" {
+		return statefulSet, true
+	}
+	if statefulSet, found := p.madeBy[pvc.ObjectMeta.Namespace+"/"+pvc.ObjectMeta.Name]; found {
+		return statefulSet, true
+	}
+	return "", statefulSetClaim.MatchString(pvc.ObjectMeta.Name)
+}
+
+// nil removes the annotation
+func (p *PersistentVolumeClaimCleaner) annotate(pvc *v1.PersistentVolumeClaim, key string, value interface{}) {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{key: value}},
+	})
+	if err := p.client.PatchPersistentVolumeClaim(pvc, patch); err != nil {
+		p.logger.Warn("Error annotating persistent volume claim", append(objectInfo(pvc), zap.Error(err))...)
+	}
+}
+
+// the rest needs to be the ordinal, so data-web-2-0 of StatefulSet web-2 is not mistaken for a claim of web
+func claimTemplateOf(key string, templates []claimTemplate) *claimTemplate {
+	for i, template := range templates {
+		if strings.HasPrefix(key, template.prefix) && ordinal.MatchString(strings.TrimPrefix(key, template.prefix)) {
+			return &templates[i]
+		}
+	}
+	return nil
+}
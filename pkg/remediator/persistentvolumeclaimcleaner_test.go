@@ -0,0 +1,393 @@
+package remediator_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/record"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TestPersistentVolumeClaimCleanerSuite struct {
+	suite.Suite
+	logger         *zap.Logger
+	mockController *gomock.Controller
+	mockClient     *mock_k8s.MockClientInterface
+	pvcs           []corev1.PersistentVolumeClaim
+	statefulSets   []appsv1.StatefulSet
+	pods           []corev1.Pod
+	options        remediator.Options
+	t              *testing.T
+}
+
+func TestSuitePersistentVolumeClaimCleaner(t *testing.T) {
+	suite.Run(t, &TestPersistentVolumeClaimCleanerSuite{t: t})
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) SetupTest() {
+	remediator.PersistentVolumeClaimCleanerConfigFile = "../../config/persistent_volume_claim_cleaner.json"
+	suite.logger, _ = zap.NewDevelopment()
+	suite.mockController = gomock.NewController(suite.t)
+	suite.mockClient = mock_k8s.NewMockClientInterface(suite.mockController)
+	suite.options = remediator.Options{Name: "PersistentVolumeClaimCleaner"}
+	suite.pvcs = []corev1.PersistentVolumeClaim{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "data-web-0",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "web"},
+			Annotations: map[string]string{
+				"kube-remediator/orphaned-since": time.Now().Add(-8 * 24 * time.Hour).UTC().Format(time.RFC3339),
+				"kube-remediator/statefulset":    "web",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{VolumeName: "pv-0"},
+	}}
+	suite.statefulSets = nil
+	suite.pods = nil
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TeardownTest() {
+	suite.mockController.Finish()
+}
+
+// factories with the current pods, started with startInformers after Setup
+func (suite *TestPersistentVolumeClaimCleanerSuite) newInformerFactories() (informers.SharedInformerFactory, metadatainformer.SharedInformerFactory) {
+	var objects []runtime.Object
+	for i := range suite.pods {
+		objects = append(objects, &suite.pods[i])
+	}
+	return newInformerFactories(objects...)
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) run() {
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	suite.mockClient.EXPECT().GetPersistentVolumeClaims("", metav1.ListOptions{}).
+		Return(&corev1.PersistentVolumeClaimList{Items: suite.pvcs}, nil)
+	suite.mockClient.EXPECT().GetStatefulSets("", metav1.ListOptions{}).
+		Return(&appsv1.StatefulSetList{Items: suite.statefulSets}, nil)
+
+	cleaner := remediator.PersistentVolumeClaimCleaner{}
+	cleaner.Configure(suite.options)
+	err := cleaner.Setup(suite.logger, suite.mockClient)
+	assert.Equal(suite.t, err, nil)
+	startInformers(suite.T(), factory, metadataFactory)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // cancel first so we can just run once and exit
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	cleaner.Run(ctx, &wg)
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) expectVolume(policy corev1.PersistentVolumeReclaimPolicy) {
+	suite.mockClient.EXPECT().GetPersistentVolume("pv-0").Return(&corev1.PersistentVolume{
+		Spec: corev1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: policy},
+	}, nil)
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) addStatefulSet(name string) {
+	suite.statefulSets = append(suite.statefulSets, appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: appsv1.StatefulSetSpec{
+			Selector:             &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}},
+		},
+	})
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDeletesClaimsOrphanedForLong() {
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.mockClient.EXPECT().DeletePersistentVolumeClaim(&suite.pvcs[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDeletesClaimsNextToItself() {
+	suite.options.Self = types.NamespacedName{Namespace: "default", Name: "kube-remediator-0"}
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.mockClient.EXPECT().DeletePersistentVolumeClaim(&suite.pvcs[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDeletesUnboundClaimsWithoutLookingUpVolumes() {
+	suite.pvcs[0].Spec.VolumeName = ""
+	suite.mockClient.EXPECT().DeletePersistentVolumeClaim(&suite.pvcs[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDeletesClaimsOfMissingVolumes() {
+	suite.mockClient.EXPECT().GetPersistentVolume("pv-0").
+		Return(nil, apierrors.NewNotFound(schema.GroupResource{Resource: "persistentvolumes"}, "pv-0"))
+	suite.mockClient.EXPECT().DeletePersistentVolumeClaim(&suite.pvcs[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestPausesWhenManyClaimsOfANamespaceAreOrphaned() {
+	suite.options.CircuitBreaker = policy.CircuitBreaker{Window: time.Hour, MaxNamespace: 1}
+	suite.pvcs[0].Spec.VolumeName = ""
+	other := *suite.pvcs[0].DeepCopy()
+	other.ObjectMeta.Name = "data-web-1"
+	suite.pvcs = append(suite.pvcs, other)
+	suite.mockClient.EXPECT().DeletePersistentVolumeClaim(&suite.pvcs[0]).Return(nil) // data-web-1 opens default
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestMarksNewlyOrphanedClaims() {
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/orphaned-since")
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.mockClient.EXPECT().PatchPersistentVolumeClaim(&suite.pvcs[0], gomock.Any()).DoAndReturn(
+		func(pvc *corev1.PersistentVolumeClaim, patch []byte) error {
+			assert.Assert(suite.t, strings.Contains(string(patch), `"kube-remediator/orphaned-since":"`))
+			return nil
+		})
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestKeepsGoingWhenClaimsCanNotBeMarked() {
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/orphaned-since")
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.mockClient.EXPECT().PatchPersistentVolumeClaim(&suite.pvcs[0], gomock.Any()).Return(errors.New("Foo"))
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestKeepsClaimsOrphanedRecently() {
+	suite.pvcs[0].ObjectMeta.Annotations["kube-remediator/orphaned-since"] = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestKeepsClaimsOfExistingStatefulSetsAndUnmarksThem() {
+	suite.addStatefulSet("web")
+	suite.mockClient.EXPECT().PatchPersistentVolumeClaim(&suite.pvcs[0],
+		[]byte(`{"metadata":{"annotations":{"kube-remediator/orphaned-since":null}}}`)).Return(nil)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDoesNotMistakeClaimsOfSimilarStatefulSets() {
+	suite.addStatefulSet("web")
+	suite.pvcs[0].ObjectMeta.Name = "data-web-2-0"
+	suite.pvcs[0].ObjectMeta.Annotations["kube-remediator/statefulset"] = "web-2"
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.mockClient.EXPECT().DeletePersistentVolumeClaim(&suite.pvcs[0]).Return(nil)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestKeepsMountedClaims() {
+	suite.pods = []corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name: "data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-web-0"},
+			},
+		}}},
+	}}
+	suite.mockClient.EXPECT().PatchPersistentVolumeClaim(&suite.pvcs[0], gomock.Any()).Return(nil)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestKeepsRetainedVolumes() {
+	suite.expectVolume(corev1.PersistentVolumeReclaimRetain)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDoesNotMarkClaimsOfRetainedVolumes() {
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/orphaned-since")
+	suite.expectVolume(corev1.PersistentVolumeReclaimRetain)
+	suite.run() // would fail on patching
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestKeepsClaimsWhenVolumeCannotBeRead() {
+	suite.mockClient.EXPECT().GetPersistentVolume("pv-0").Return(nil, errors.New("Foo"))
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestIgnoresOptedOutClaims() {
+	suite.pvcs[0].ObjectMeta.Annotations["kube-remediator/PersistentVolumeClaimCleaner"] = "false"
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDeletesClaimsOnlyNamedLikeClaimsOfStatefulSets() {
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/statefulset")
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.mockClient.EXPECT().DeletePersistentVolumeClaim(&suite.pvcs[0]).Return(nil)
+	suite.run()
+	assert.Assert(suite.t, strings.Contains(<-recorder.Events, "Named like a claim of a deleted StatefulSet orphaned=192h"))
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestIgnoresClaimsNotFromStatefulSets() {
+	suite.pvcs[0].ObjectMeta.Name = "scratch-1"
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/statefulset")
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestRecordsStatefulSetOfClaims() {
+	suite.addStatefulSet("web")
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/statefulset")
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/orphaned-since")
+	suite.mockClient.EXPECT().PatchPersistentVolumeClaim(&suite.pvcs[0],
+		[]byte(`{"metadata":{"annotations":{"kube-remediator/statefulset":"web"}}}`)).Return(nil)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDoesNotRecordClaimsNotSelectedByTheStatefulSet() {
+	suite.addStatefulSet("web")
+	suite.pvcs[0].ObjectMeta.Labels = map[string]string{"app": "other"}
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/statefulset")
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/orphaned-since")
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestKeepsClaimsOfStatefulSetsWithBrokenSelectors() {
+	suite.addStatefulSet("web")
+	suite.statefulSets[0].Spec.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Bogus"}}
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/statefulset")
+	suite.mockClient.EXPECT().PatchPersistentVolumeClaim(&suite.pvcs[0],
+		[]byte(`{"metadata":{"annotations":{"kube-remediator/orphaned-since":null}}}`)).Return(nil)
+	suite.run() // would fail on recording the StatefulSet
+}
+
+// without annotations dry-run remembers the StatefulSet of claims from earlier runs
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDryRunRemembersStatefulSetOfClaims() {
+	suite.options.DryRun = true
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.addStatefulSet("web")
+	delete(suite.pvcs[0].ObjectMeta.Annotations, "kube-remediator/statefulset")
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	suite.mockClient.EXPECT().GetPersistentVolumeClaims("", metav1.ListOptions{}).
+		Return(&corev1.PersistentVolumeClaimList{Items: suite.pvcs}, nil).Times(2)
+	gomock.InOrder(
+		suite.mockClient.EXPECT().GetStatefulSets("", metav1.ListOptions{}).Return(&appsv1.StatefulSetList{Items: suite.statefulSets}, nil),
+		suite.mockClient.EXPECT().GetStatefulSets("", metav1.ListOptions{}).Return(&appsv1.StatefulSetList{}, nil),
+	)
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+
+	cleaner := remediator.PersistentVolumeClaimCleaner{}
+	cleaner.Configure(suite.options)
+	assert.NilError(suite.t, cleaner.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	assert.NilError(suite.t, cleaner.Reconcile())
+	assert.NilError(suite.t, cleaner.Reconcile())
+	assert.Assert(suite.t, strings.Contains(<-recorder.Events, "Left behind by deleted StatefulSet web"))
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestIgnoresOwnedClaims() {
+	suite.pvcs[0].ObjectMeta.OwnerReferences = []metav1.OwnerReference{{Kind: "StatefulSet", Name: "web"}}
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDryRunDoesNotMarkOrDelete() {
+	suite.options.DryRun = true
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	unmarked := suite.pvcs[0]
+	unmarked.ObjectMeta = *suite.pvcs[0].ObjectMeta.DeepCopy()
+	unmarked.ObjectMeta.Name = "data-web-1"
+	delete(unmarked.ObjectMeta.Annotations, "kube-remediator/orphaned-since")
+	suite.pvcs = append(suite.pvcs, unmarked)
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDoesNotCrashWhenDeleteFails() {
+	suite.expectVolume(corev1.PersistentVolumeReclaimDelete)
+	suite.mockClient.EXPECT().DeletePersistentVolumeClaim(&suite.pvcs[0]).Return(errors.New("Foo"))
+	suite.run()
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestDoesNotCrashWhenListingFails() {
+	configMaps := &fakeConfigMaps{}
+	suite.options.LastRuns = remediator.NewLastRuns(configMaps, "default", remediator.LastRunsConfigMap)
+	defer func() { assert.Assert(suite.t, configMaps.data == nil, "failed runs are not remembered") }()
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	suite.mockClient.EXPECT().GetPersistentVolumeClaims("", metav1.ListOptions{}).Return(nil, errors.New("Foo"))
+
+	cleaner := remediator.PersistentVolumeClaimCleaner{}
+	cleaner.Configure(suite.options)
+	assert.NilError(suite.t, cleaner.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	cleaner.Run(ctx, &wg)
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestReportsFailedStatefulSetListing() {
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	suite.mockClient.EXPECT().GetPersistentVolumeClaims("", metav1.ListOptions{}).Return(&corev1.PersistentVolumeClaimList{}, nil)
+	suite.mockClient.EXPECT().GetStatefulSets("", metav1.ListOptions{}).Return(nil, errors.New("Foo"))
+
+	cleaner := remediator.PersistentVolumeClaimCleaner{}
+	cleaner.Configure(suite.options)
+	assert.NilError(suite.t, cleaner.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	assert.ErrorContains(suite.t, cleaner.Reconcile(), "getting stateful set list: Foo")
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestSetupFailsWithInvalidConfig() {
+	remediator.PersistentVolumeClaimCleanerConfigFile = filepath.Join(suite.t.TempDir(), "persistent_volume_claim_cleaner.json")
+	assert.NilError(suite.t, os.WriteFile(remediator.PersistentVolumeClaimCleanerConfigFile, []byte(`{"threshold": "0s"}`), 0644))
+	cleaner := remediator.PersistentVolumeClaimCleaner{}
+	cleaner.Configure(suite.options)
+	assert.ErrorContains(suite.t, cleaner.Setup(suite.logger, suite.mockClient), "threshold needs to be positive")
+}
+
+func (suite *TestPersistentVolumeClaimCleanerSuite) TestRetriesWhenListingFails() {
+	defer func(backoff time.Duration) { remediator.RetryBackoff = backoff }(remediator.RetryBackoff)
+	remediator.RetryBackoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory)
+	gomock.InOrder(
+		suite.mockClient.EXPECT().GetPersistentVolumeClaims("", metav1.ListOptions{}).Return(nil, errors.New("Foo")),
+		suite.mockClient.EXPECT().GetPersistentVolumeClaims("", metav1.ListOptions{}).
+			Return(&corev1.PersistentVolumeClaimList{}, nil),
+	)
+	suite.mockClient.EXPECT().GetStatefulSets("", metav1.ListOptions{}).
+		DoAndReturn(func(string, metav1.ListOptions) (*appsv1.StatefulSetList, error) {
+			cancel() // stop after the successful retry
+			return &appsv1.StatefulSetList{}, nil
+		})
+
+	cleaner := remediator.PersistentVolumeClaimCleaner{}
+	cleaner.Configure(suite.options)
+	assert.NilError(suite.t, cleaner.Setup(suite.logger, suite.mockClient))
+	startInformers(suite.T(), factory, metadataFactory)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	cleaner.Run(ctx, &wg)
+}
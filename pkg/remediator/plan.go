@@ -0,0 +1,73 @@
+package remediator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/history"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"go.uber.org/zap"
+	"sort"
+)
+
+// Plan runs every remediator that has a spec once in dry-run and returns what they would do, sorted by remediator,
+// namespace and name. Nothing is changed: the client is read-only and no events, notifications or proposals are made.
+// Windows, budgets, circuit breakers and approvals are left out, so it shows candidates regardless of when remediators may act.
+// Remediators that fail are left out and returned as errors.
+func Plan(ctx context.Context, logger *zap.Logger, client k8s.ClientInterface, registrations []Registration, specs map[string]Spec) ([]history.Entry, error) {
+	store, err := history.NewStore(onceLimit, "")
+	if err != nil {
+		return nil, err // untested section
+	}
+	client = k8s.ReadOnly(client)
+
+	remediators, setupErrs, err := setupOnce(ctx, logger, client, registrations, specs, func(name string, spec Spec) Options {
+		options := spec.Options
+		options.DryRun = true
+		options.Budgets = nil
+		options.Recorder = nil
+		options.History = store
+		options.Notifier = nil
+		options.Windows = nil
+		options.CircuitBreaker = policy.CircuitBreaker{}
+		options.Approval = policy.Approval{}
+		options.Approvals = nil
+		return options
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, registration := range registrations {
+		name := registration.Name
+		if err, failed := setupErrs[name]; failed {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		} else if r, found := remediators[name]; found {
+			if err := r.Reconcile(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+
+	entries := store.List(history.Filter{})
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Remediator != b.Remediator {
+			return a.Remediator < b.Remediator
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	planned := []history.Entry{}
+	for _, entry := range entries {
+		if entry.Result == metrics.ResultDryRun {
+			planned = append(planned, entry)
+		}
+	}
+	return planned, errors.Join(errs...)
+}
@@ -0,0 +1,207 @@
+package remediator_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aksgithub/kube_remediator/pkg/history"
+	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type TestPlanSuite struct {
+	suite.Suite
+	logger         *zap.Logger
+	mockController *gomock.Controller
+	mockClient     *mock_k8s.MockClientInterface
+	crashing       corev1.Pod
+	completed      corev1.Pod
+	specs          map[string]remediator.Spec
+	names          []string // remediators that are registered
+	t              *testing.T
+}
+
+func TestSuitePlan(t *testing.T) {
+	suite.Run(t, &TestPlanSuite{t: t})
+}
+
+func (suite *TestPlanSuite) SetupTest() {
+	remediator.CONFIG_FILE = "../../config/crash_loop_back_off_rescheduler.json"
+	remediator.CompletedPodDeleterConfigFile = "../../config/completed_pod_deleter.json"
+	suite.logger, _ = zap.NewDevelopment()
+	suite.mockController = gomock.NewController(suite.t)
+	suite.mockClient = mock_k8s.NewMockClientInterface(suite.mockController)
+
+	isController := true
+	suite.crashing = corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-1",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &isController}},
+		},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:         "web",
+			RestartCount: 6,
+			State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}}},
+	}
+	suite.completed = corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "backup-1",
+			Namespace:         "jobs",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-25 * time.Hour)),
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+	}
+	suite.specs = map[string]remediator.Spec{
+		"CrashLoopBackOffRescheduler": {Options: remediator.Options{Name: "CrashLoopBackOffRescheduler", Action: policy.ActionDelete}},
+		"CompletedPodDeleter":         {Options: remediator.Options{Name: "CompletedPodDeleter", Action: policy.ActionDelete}},
+	}
+	suite.names = []string{"CrashLoopBackOffRescheduler", "CompletedPodDeleter", "OldPodDeleter"}
+}
+
+func (suite *TestPlanSuite) TearDownTest() {
+	suite.mockController.Finish()
+}
+
+func (suite *TestPlanSuite) run(ctx context.Context, objects ...runtime.Object) ([]history.Entry, error) {
+	factory, metadataFactory := newInformerFactories(objects...)
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory).AnyTimes()
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory).AnyTimes()
+
+	var registrations []remediator.Registration
+	for _, name := range suite.names {
+		registration, found := remediator.Lookup(name)
+		assert.Assert(suite.t, found)
+		registrations = append(registrations, registration)
+	}
+	return remediator.Plan(ctx, suite.logger, suite.mockClient, registrations, suite.specs)
+}
+
+func (suite *TestPlanSuite) plan() []history.Entry {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries, err := suite.run(ctx, &suite.crashing, &suite.completed)
+	assert.NilError(suite.t, err)
+	return entries
+}
+
+func (suite *TestPlanSuite) TestListsWhatRemediatorsWouldDo() {
+	entries := suite.plan() // the mock fails on any change
+
+	assert.Equal(suite.t, len(entries), 2)
+	assert.Equal(suite.t, entries[0].Remediator, "CompletedPodDeleter")
+	assert.Equal(suite.t, entries[0].Namespace+"/"+entries[0].Name, "jobs/backup-1")
+	assert.Equal(suite.t, entries[1].Remediator, "CrashLoopBackOffRescheduler")
+	assert.Equal(suite.t, entries[1].Action, "delete")
+	assert.Equal(suite.t, entries[1].Reason, "CrashLoopBackOff container=web restartCount=6")
+	assert.DeepEqual(suite.t, entries[1].Owner, &history.Owner{Kind: "ReplicaSet", Name: "web"})
+}
+
+func (suite *TestPlanSuite) TestIgnoresWindowsAndCircuitBreakers() {
+	otherDay := time.Now().UTC().Add(48 * time.Hour).Weekday().String()[:3]
+	for name, spec := range suite.specs {
+		spec.Options.Windows = []policy.Windows{{Allowed: []policy.Window{{Days: []string{otherDay}, Start: "00:00", End: "00:00"}}}}
+		spec.Options.CircuitBreaker = policy.CircuitBreaker{Window: time.Hour, MaxCluster: 1}
+		suite.specs[name] = spec
+	}
+
+	assert.Equal(suite.t, len(suite.plan()), 2)
+}
+
+func (suite *TestPlanSuite) TestOnlyRunsRemediatorsWithSpecs() {
+	delete(suite.specs, "CompletedPodDeleter")
+
+	entries := suite.plan()
+	assert.Equal(suite.t, len(entries), 1)
+	assert.Equal(suite.t, entries[0].Remediator, "CrashLoopBackOffRescheduler")
+}
+
+func (suite *TestPlanSuite) TestIgnoresApprovals() {
+	spec := suite.specs["CrashLoopBackOffRescheduler"]
+	spec.Options.Approval = policy.Approval{Required: true, Expiry: time.Hour}
+	suite.specs["CrashLoopBackOffRescheduler"] = spec
+
+	assert.Equal(suite.t, len(suite.plan()), 2)
+}
+
+func (suite *TestPlanSuite) TestSortsByRemediatorNamespaceAndName() {
+	second := *suite.crashing.DeepCopy()
+	second.Name = "web-2"
+	other := *suite.crashing.DeepCopy()
+	other.Namespace = "other"
+	failed := *suite.crashing.DeepCopy()
+	failed.Name = "web-3"
+	failed.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	failed.Status = corev1.PodStatus{Phase: corev1.PodFailed, Reason: "OutOfcpu"}
+	remediator.OldPodDeleterConfigFile = "../../config/old_pod_deleter.json"
+	suite.names = append(suite.names, "FailedPodRescheduler")
+	suite.specs["FailedPodRescheduler"] = remediator.Spec{Options: remediator.Options{Name: "FailedPodRescheduler", Action: policy.ActionDelete}}
+	suite.specs["OldPodDeleter"] = remediator.Spec{Options: remediator.Options{Name: "OldPodDeleter", Action: policy.ActionDelete}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries, err := suite.run(ctx, &other, &second, &suite.crashing, &failed)
+	assert.NilError(suite.t, err)
+
+	var planned []string
+	for _, entry := range entries {
+		planned = append(planned, entry.Remediator+" "+entry.Namespace+"/"+entry.Name)
+	}
+	assert.DeepEqual(suite.t, planned, []string{
+		"CrashLoopBackOffRescheduler default/web-1",
+		"CrashLoopBackOffRescheduler default/web-2",
+		"CrashLoopBackOffRescheduler other/web-1",
+		"FailedPodRescheduler default/web-3",
+	})
+}
+
+func (suite *TestPlanSuite) TestReportsFailedSetup() {
+	remediator.CompletedPodDeleterConfigFile = filepath.Join(suite.t.TempDir(), "broken.json")
+	assert.NilError(suite.t, os.WriteFile(remediator.CompletedPodDeleterConfigFile, []byte(`{"namespace": `), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries, err := suite.run(ctx, &suite.crashing, &suite.completed)
+
+	assert.ErrorContains(suite.t, err, "CompletedPodDeleter: ")
+	assert.ErrorContains(suite.t, err, "broken.json")
+	assert.Equal(suite.t, len(entries), 1)
+	assert.Equal(suite.t, entries[0].Remediator, "CrashLoopBackOffRescheduler")
+}
+
+func (suite *TestPlanSuite) TestReportsFailedReconciles() {
+	remediator.PersistentVolumeClaimCleanerConfigFile = "../../config/persistent_volume_claim_cleaner.json"
+	suite.names = []string{"PersistentVolumeClaimCleaner"}
+	suite.specs = map[string]remediator.Spec{
+		"PersistentVolumeClaimCleaner": {Options: remediator.Options{Name: "PersistentVolumeClaimCleaner", Action: policy.ActionDelete}},
+	}
+	suite.mockClient.EXPECT().GetPersistentVolumeClaims("", metav1.ListOptions{}).Return(nil, errors.New("Foo"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := suite.run(ctx)
+
+	assert.ErrorContains(suite.t, err, "PersistentVolumeClaimCleaner: getting persistent volume claim list: Foo")
+}
+
+func (suite *TestPlanSuite) TestFailsWhenInformersDoNotSync() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := suite.run(ctx, &suite.crashing, &suite.completed)
+
+	assert.ErrorContains(suite.t, err, "waiting for")
+	assert.Assert(suite.t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,118 @@
+package remediator
+
+import (
+	"context"
+	"github.com/aksgithub/kube_remediator/pkg/healthz"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"math/rand"
+	"time"
+)
+
+// first wait after a failed reconcile, doubled with every failure up to the normal interval
+var RetryBackoff = 10 * time.Second
+
+// limit of the backoff of remediators that only reconcile on start and then follow pod updates
+var StartRetryLimit = 5 * time.Minute
+
+// waits get up to this fraction longer at random, so replicas in many clusters do not all call their api servers at once
+const jitterFactor = 0.1
+
+// limit of the jitter of scheduled runs, so a daily run does not move by hours
+var MaxJitter = time.Minute
+
+// runs fn and reports the result in logs, metrics and /healthz, what it did is notified about in one batch
+func (p *Base) reconcile(fn func() error) error {
+	err := fn()
+	p.options.Notifier.Flush(p.options.Name)
+	if err != nil {
+		p.logger.Error("Reconcile failed", zap.Error(err))
+		metrics.UpdateReconcileCount(p.options.Name, metrics.ResultError)
+	} else {
+		metrics.UpdateReconcileCount(p.options.Name, metrics.ResultSuccess)
+		metrics.SetLastSuccessfulReconcile(p.options.Name, time.Now())
+	}
+	healthz.Report(p.options.Name, err)
+	return err
+}
+
+// runs fn on start and then about every interval, failed runs are retried sooner with backoff
+func (p *Base) reconcileEvery(ctx context.Context, fn func() error, interval time.Duration) {
+	p.logStartAndStop(func() {
+		var retry backoff
+		for {
+			delay := wait.Jitter(interval, jitterFactor)
+			if err := p.reconcile(fn); err != nil {
+				delay = wait.Jitter(retry.next(interval), jitterFactor)
+				p.logger.Info("Retrying", zap.Duration("in", delay))
+			} else {
+				retry.reset()
+			}
+			if !sleep(ctx, delay) {
+				return
+			}
+		}
+	})
+}
+
+// runs fn until it succeeds, false when ctx is done first
+func (p *Base) reconcileUntilSuccess(ctx context.Context, fn func() error) bool {
+	var retry backoff
+	for p.reconcile(fn) != nil {
+		delay := wait.Jitter(retry.next(StartRetryLimit), jitterFactor)
+		p.logger.Info("Retrying", zap.Duration("in", delay))
+		if !sleep(ctx, delay) {
+			return false
+		}
+	}
+	return true
+}
+
+// exponential backoff of retries, the zero value starts at RetryBackoff
+type backoff struct {
+	failures int
+}
+
+func (b *backoff) next(limit time.Duration) time.Duration {
+	delay := RetryBackoff
+	for i := 0; i < b.failures && delay < limit; i++ {
+		delay *= 2
+	}
+	b.failures++
+	if delay > limit {
+		return limit
+	}
+	return delay
+}
+
+func (b *backoff) reset() {
+	b.failures = 0
+}
+
+// random delay up to jitterFactor of the interval, at most MaxJitter
+func jitter(interval time.Duration) time.Duration {
+	limit := time.Duration(float64(interval) * jitterFactor)
+	if limit > MaxJitter {
+		limit = MaxJitter
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// waits for the delay, false when ctx is done first
+func sleep(ctx context.Context, delay time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
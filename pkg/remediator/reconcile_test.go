@@ -0,0 +1,80 @@
+package remediator
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+func useRetryBackoff(t *testing.T, backoff time.Duration) {
+	previous := RetryBackoff
+	RetryBackoff = backoff
+	t.Cleanup(func() { RetryBackoff = previous })
+}
+
+func newTestBase() *Base {
+	return &Base{logger: zap.NewNop(), options: Options{Name: "Test"}}
+}
+
+// fails the first failures calls, cancels once it succeeded
+func failing(failures int, cancel func()) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= failures {
+			return errors.New("getting pod list: timeout")
+		}
+		cancel()
+		return nil
+	}, &calls
+}
+
+func TestBackoffDoublesUpToLimit(t *testing.T) {
+	useRetryBackoff(t, 10*time.Second)
+	var retry backoff
+	for _, expected := range []time.Duration{10 * time.Second, 20 * time.Second, 35 * time.Second, 35 * time.Second} {
+		assert.Equal(t, retry.next(35*time.Second), expected)
+	}
+	retry.reset()
+	assert.Equal(t, retry.next(35*time.Second), 10*time.Second)
+}
+
+func TestJitterStaysWithinLimits(t *testing.T) {
+	assert.Equal(t, jitter(0), time.Duration(0))
+	for i := 0; i < 100; i++ {
+		assert.Assert(t, jitter(10*time.Second) < time.Second)
+		assert.Assert(t, jitter(24*time.Hour) < MaxJitter)
+	}
+}
+
+func TestReconcileEveryRetriesFailuresSooner(t *testing.T) {
+	useRetryBackoff(t, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fn, calls := failing(2, cancel)
+
+	newTestBase().reconcileEvery(ctx, fn, time.Hour)
+	assert.Equal(t, *calls, 3)
+}
+
+func TestReconcileUntilSuccess(t *testing.T) {
+	useRetryBackoff(t, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fn, calls := failing(2, func() {})
+
+	assert.Assert(t, newTestBase().reconcileUntilSuccess(ctx, fn))
+	assert.Equal(t, *calls, 3)
+}
+
+func TestReconcileUntilSuccessStopsWhenDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fn, calls := failing(1, func() {})
+
+	assert.Assert(t, !newTestBase().reconcileUntilSuccess(ctx, fn))
+	assert.Equal(t, *calls, 1)
+}
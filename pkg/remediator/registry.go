@@ -0,0 +1,80 @@
+package remediator
+
+import (
+	"fmt"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registration describes a remediator, so main can build it without knowing about it.
+// Remediators register themselves in init(), remediators from other packages get linked in with a blank import.
+type Registration struct {
+	Name     string                 // stable name used in the policy, logs and metrics
+	New      func() BaseIntf        // builds a new remediator that still needs Configure and Setup
+	Defaults map[string]interface{} // config used when the config file does not set it
+	// file read in Setup, the remediator is restarted when it changes, nil when there is none
+	ConfigFile func() string
+	Rules      []rbacv1.PolicyRule // permissions the remediator needs, see kubernetes/rbac.yaml
+}
+
+var (
+	registryLock  sync.Mutex
+	registrations = map[string]Registration{}
+)
+
+func Register(registration Registration) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	key := strings.ToLower(registration.Name)
+	if _, found := registrations[key]; found {
+		panic(fmt.Sprintf("remediator %s is already registered", registration.Name))
+	}
+	registrations[key] = registration
+}
+
+// all registered remediators sorted by name, so they always start in the same order
+func Registrations() []Registration {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	all := make([]Registration, 0, len(registrations))
+	for _, registration := range registrations {
+		all = append(all, registration)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// case-insensitive like the policy
+func Lookup(name string) (Registration, bool) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registration, found := registrations[strings.ToLower(name)]
+	return registration, found
+}
+
+// rules shared by remediators that watch and remove pods
+func podRules(verbs ...string) []rbacv1.PolicyRule {
+	return append(baseRules(),
+		rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: verbs},
+		rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods/eviction"}, Verbs: []string{"create"}},
+	)
+}
+
+// rules every remediator needs for what Base does
+// periodic remediators remember their last run, see LastRuns
+var lastRunsRule = rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "patch"}}
+
+func baseRules() []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+		// opt-ins on owners and namespaces
+		{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"replicasets", "deployments", "statefulsets", "daemonsets"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"jobs", "cronjobs"}, Verbs: []string{"list", "watch"}},
+	}
+}
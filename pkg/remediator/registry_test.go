@@ -0,0 +1,204 @@
+package remediator_test
+
+import (
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"github.com/stretchr/testify/suite"
+	"gotest.tools/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+type TestRegistrySuite struct {
+	suite.Suite
+	t *testing.T
+}
+
+func TestSuiteRegistry(t *testing.T) {
+	suite.Run(t, &TestRegistrySuite{t: t})
+}
+
+func (suite *TestRegistrySuite) names() []string {
+	var names []string
+	for _, registration := range remediator.Registrations() {
+		names = append(names, registration.Name)
+	}
+	return names
+}
+
+func (suite *TestRegistrySuite) TestRegistersBuiltInRemediatorsSorted() {
+	assert.DeepEqual(suite.t, suite.names(), []string{
+		"CompletedPodDeleter",
+		"CrashLoopBackOffRescheduler",
+		"FailedPodRescheduler",
+		"OldPodDeleter",
+		"PersistentVolumeClaimCleaner",
+		"RuleRemediator",
+	})
+}
+
+func (suite *TestRegistrySuite) TestBuildsNewRemediators() {
+	registration, found := remediator.Lookup("OldPodDeleter")
+	assert.Assert(suite.t, found)
+	assert.Assert(suite.t, registration.New() != registration.New())
+}
+
+func (suite *TestRegistrySuite) TestLookupIsCaseInsensitive() {
+	registration, found := remediator.Lookup("oldpoddeleter")
+	assert.Assert(suite.t, found)
+	assert.Equal(suite.t, registration.Name, "OldPodDeleter")
+
+	_, found = remediator.Lookup("Unknown")
+	assert.Assert(suite.t, !found)
+}
+
+func (suite *TestRegistrySuite) TestRegisterRejectsDuplicateNames() {
+	suite.Panics(func() {
+		remediator.Register(remediator.Registration{Name: "oldPodDeleter"})
+	})
+}
+
+// kubernetes/rbac.yaml needs to allow everything registered remediators need
+func (suite *TestRegistrySuite) TestRBACCoversRegisteredRemediators() {
+	content, err := os.ReadFile("../../kubernetes/rbac.yaml")
+	assert.NilError(suite.t, err)
+
+	var role rbacv1.ClusterRole
+	for _, document := range strings.Split(string(content), "\n---") {
+		if strings.Contains(document, "kind: ClusterRole\n") && strings.Contains(document, "name: monitor-pods\n") {
+			assert.NilError(suite.t, yaml.Unmarshal([]byte(document), &role))
+		}
+	}
+
+	allowed := func(group string, resource string, verb string) bool {
+		for _, rule := range role.Rules {
+			if contains(rule.APIGroups, group) && contains(rule.Resources, resource) && contains(rule.Verbs, verb) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, registration := range remediator.Registrations() {
+		for _, rule := range registration.Rules {
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					for _, verb := range rule.Verbs {
+						assert.Assert(suite.t, allowed(group, resource, verb),
+							"%s needs %s %s/%s", registration.Name, verb, group, resource)
+					}
+				}
+			}
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (suite *TestRegistrySuite) useShippedConfigs() {
+	remediator.CONFIG_FILE = "../../config/crash_loop_back_off_rescheduler.json"
+	remediator.CompletedPodDeleterConfigFile = "../../config/completed_pod_deleter.json"
+	remediator.OldPodDeleterConfigFile = "../../config/old_pod_deleter.json"
+	remediator.PersistentVolumeClaimCleanerConfigFile = "../../config/persistent_volume_claim_cleaner.json"
+	remediator.RuleRemediatorConfigFile = "../../config/rule_remediator.json"
+}
+
+func (suite *TestRegistrySuite) TestShippedConfigsAreValid() {
+	suite.useShippedConfigs()
+	for _, registration := range remediator.Registrations() {
+		assert.NilError(suite.t, remediator.ValidateConfig(registration), registration.Name)
+	}
+}
+
+func (suite *TestRegistrySuite) TestConfigFilesAreShipped() {
+	suite.useShippedConfigs()
+	for _, registration := range remediator.Registrations() {
+		if registration.ConfigFile == nil {
+			continue
+		}
+		_, err := os.Stat(registration.ConfigFile())
+		assert.NilError(suite.t, err, registration.Name)
+	}
+}
+
+func (suite *TestRegistrySuite) TestMissingConfigsUseDefaults() {
+	remediator.CONFIG_FILE = filepath.Join(suite.t.TempDir(), "crash_loop_back_off_rescheduler.json")
+	remediator.OldPodDeleterConfigFile = filepath.Join(suite.t.TempDir(), "old_pod_deleter.json")
+	for _, name := range []string{"CrashLoopBackOffRescheduler", "OldPodDeleter"} {
+		registration, _ := remediator.Lookup(name)
+		assert.NilError(suite.t, remediator.ValidateConfig(registration), name)
+	}
+}
+
+func (suite *TestRegistrySuite) TestValidateConfigNamesFileAndFields() {
+	suite.useShippedConfigs()
+	remediator.CONFIG_FILE = filepath.Join(suite.t.TempDir(), "crash_loop_back_off_rescheduler.json")
+	config := `{"failureTreshold": 5, "namespace": "Team A", "failureThreshold": "5"}`
+	assert.NilError(suite.t, os.WriteFile(remediator.CONFIG_FILE, []byte(config), 0644))
+
+	registration, _ := remediator.Lookup("CrashLoopBackOffRescheduler")
+	err := remediator.ValidateConfig(registration)
+
+	assert.ErrorContains(suite.t, err, remediator.CONFIG_FILE+": ")
+	assert.ErrorContains(suite.t, err, `unknown field "failureTreshold"`)
+	assert.ErrorContains(suite.t, err, `failureThreshold needs to be a whole number, got "5"`)
+}
+
+func (suite *TestRegistrySuite) TestValidateConfigRejectsInvalidValues() {
+	suite.useShippedConfigs()
+	remediator.CONFIG_FILE = filepath.Join(suite.t.TempDir(), "crash_loop_back_off_rescheduler.json")
+	registration, _ := remediator.Lookup("CrashLoopBackOffRescheduler")
+
+	for config, message := range map[string]string{
+		`{"failureThreshold": -1}`:                 "failureThreshold needs to be positive, got -1",
+		`{"namespace": "Team A"}`:                  `namespace: invalid namespace "Team A"`,
+		`{"flapProtection": {"window": "soon"}}`:   "flapProtection.window needs to be a duration like 24h",
+		`{"flapProtection": {"backoff": "later"}}`: "flapProtection.backoff needs to be a duration like 24h",
+		`{"flapProtection": {"maxAttempts": -1}}`:  "flapProtection.maxAttempts can not be negative",
+		`{"flapProtection": {"window": "0s"}}`:     "flapProtection needs a positive window and backoff",
+	} {
+		assert.NilError(suite.t, os.WriteFile(remediator.CONFIG_FILE, []byte(config), 0644))
+		assert.ErrorContains(suite.t, remediator.ValidateConfig(registration), message)
+	}
+}
+
+func (suite *TestRegistrySuite) TestValidateConfigRejectsInvalidClaimCleanerValues() {
+	suite.useShippedConfigs()
+	remediator.PersistentVolumeClaimCleanerConfigFile = filepath.Join(suite.t.TempDir(), "persistent_volume_claim_cleaner.json")
+	registration, _ := remediator.Lookup("PersistentVolumeClaimCleaner")
+
+	for config, message := range map[string]string{
+		`{"schedule": "sometimes"}`: "schedule",
+		`{"threshold": "soon"}`:     "threshold needs to be a duration like 24h",
+		`{"threshold": "0s"}`:       `threshold needs to be positive, got "0s"`,
+		`{"namespace": "Team A"}`:   `namespace: invalid namespace "Team A"`,
+	} {
+		assert.NilError(suite.t, os.WriteFile(remediator.PersistentVolumeClaimCleanerConfigFile, []byte(config), 0644))
+		assert.ErrorContains(suite.t, remediator.ValidateConfig(registration), message)
+	}
+}
+
+func (suite *TestRegistrySuite) TestValidateConfigRejectsInvalidCompletedPodDeleterValues() {
+	suite.useShippedConfigs()
+	remediator.CompletedPodDeleterConfigFile = filepath.Join(suite.t.TempDir(), "completed_pod_deleter.json")
+	registration, _ := remediator.Lookup("CompletedPodDeleter")
+
+	for config, message := range map[string]string{
+		`{"schedule": "sometimes"}`: "schedule",
+		`{"minAge": "soon"}`:        "minAge needs to be a duration like 24h",
+		`{"minAge": "-1h"}`:         `minAge can not be negative, got "-1h"`,
+	} {
+		assert.NilError(suite.t, os.WriteFile(remediator.CompletedPodDeleterConfigFile, []byte(config), 0644))
+		assert.ErrorContains(suite.t, remediator.ValidateConfig(registration), message)
+	}
+}
@@ -2,9 +2,26 @@ package remediator
 
 import (
 	"context"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/approval"
+	"github.com/aksgithub/kube_remediator/pkg/healthz"
+	"github.com/aksgithub/kube_remediator/pkg/history"
 	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/aksgithub/kube_remediator/pkg/notify"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,60 +29,341 @@ import (
 // will later be used to make arrays or remediators / testing
 type BaseIntf interface {
 	Setup(*zap.Logger, k8s.ClientInterface) error
+	Configure(Options)
 	Run(context.Context, *sync.WaitGroup)
+	Reconcile() error // looks at everything once like Run does on start, needs synced informers
+}
+
+// Options are decided by the policy and apply to every remediator
+type Options struct {
+	Name    string
+	DryRun  bool      // log and count actions instead of executing them
+	Budgets []*Budget // every action needs to fit into all of them
+	Action  string    // policy.ActionDelete or policy.ActionEvict
+
+	Recorder record.EventRecorder // nil records no events
+	History  *history.Store       // nil keeps no history
+	Notifier *notify.Notifier     // nil sends no notifications
+
+	Approval  policy.Approval // which actions wait for approval
+	Approvals *approval.Store // proposed actions, nil never waits for approval
+
+	Namespaces *policy.NamespacePolicies // RemediationPolicies of namespaces, nil uses cluster defaults everywhere
+	Scopes     []policy.Scope            // objects need to be in all of them
+	Windows    []policy.Windows          // actions are deferred unless all of them are open
+	Self       types.NamespacedName      // pod we run as, never acted on together with its replicas
+
+	CircuitBreaker policy.CircuitBreaker // pauses scopes with too many candidates
+	RestartOwner   policy.RestartOwner   // restarts owners instead of removing their pods, when the remediator supports it
+	LastRuns       *LastRuns             // periodic remediators remember their runs in it, nil catches up on every start
+
+	DeleteWhenEvictionBlocked bool
 }
 
 type Base struct {
 	BaseIntf
-	client k8s.ClientInterface
-	logger *zap.Logger
+	client    k8s.ClientInterface
+	logger    *zap.Logger
+	options   Options
+	informers informers.SharedInformerFactory
+	optIns    *optIns
+	scopes    []scope
+	schedules []*policy.Schedule
+	breaker   *circuitBreaker
+	flaps     *flapProtection // set by remediators that use it
+	restarts  *ownerRestarts  // set by remediators that can restart owners
+	// checks a single pod again and acts on it when needed, set by remediators that can, for approvals
+	reconsider func(*v1.Pod)
+
+	optInAliases []string // older annotations that mean the same as kube-remediator/<name>
+	requireOptIn bool     // only act on objects that opted in instead of all that did not opt out
 }
 
+// remediators get their informers from p.informers after calling this, so the shared factory is only asked once
 func (p *Base) Setup(logger *zap.Logger, client k8s.ClientInterface) error {
 	p.client = client
 	p.logger = logger
+	p.informers = client.SharedInformerFactory()
+	p.optIns = newOptIns(client.MetadataInformerFactory())
 	return nil
 }
 
+func (p *Base) Configure(options Options) {
+	p.options = options
+	p.scopes = nil
+	for _, config := range options.Scopes {
+		p.scopes = append(p.scopes, newScope(config))
+	}
+	p.breaker = newCircuitBreaker(options.CircuitBreaker)
+	p.schedules = nil
+	for _, config := range options.Windows {
+		if schedule, err := policy.NewSchedule(config); err == nil { // validated with the policy
+			p.schedules = append(p.schedules, schedule)
+		}
+	}
+}
+
+// if all windows allow acting now, reason says why not
+func (p *Base) isWindowOpen(now time.Time) (bool, string) {
+	for _, schedule := range p.schedules {
+		if open, reason := schedule.IsOpen(now); !open {
+			return false, reason
+		}
+	}
+	return true, ""
+}
+
 func (p *Base) logStartAndStop(fn func()) {
+	defer healthz.Remove(p.options.Name)
 	defer p.logger.Info("Stopping", zap.String("reason", "Signal"))
-	p.logger.Info("Starting")
+	p.logger.Info("Starting", zap.Bool("dry_run", p.options.DryRun))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.watchCircuitBreaker(stop)
+	defer p.subscribeToApprovals()()
 	fn()
 }
 
-func (p *Base) reconcileEvery(ctx context.Context, fn func(), interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// calls handler for pod events of the shared informer until ctx is done
+func (p *Base) handlePodUpdates(ctx context.Context, pods coreinformers.PodInformer, handler cache.ResourceEventHandler) {
+	registration, err := pods.Informer().AddEventHandler(handler)
+	if err != nil {
+		p.logger.Error("Error watching pods", zap.Error(err)) // untested section
+		return
+	}
+	<-ctx.Done()
+	pods.Informer().RemoveEventHandler(registration)
+}
 
-	p.logStartAndStop(func() {
-		// Run on start
-		fn()
+// the shared informer is started after all remediators are set up, listing before it synced would miss pods
+// and acting before owners and namespaces synced would miss their opt-outs
+func (p *Base) waitForCacheSync(ctx context.Context, pods coreinformers.PodInformer) bool {
+	synced := []cache.InformerSynced{pods.Informer().HasSynced}
+	if p.optIns != nil {
+		synced = append(synced, p.optIns.synced...)
+	}
+	if p.restarts != nil {
+		synced = append(synced, p.restarts.synced...)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		p.logger.Info("Stopped waiting for pod cache to sync")
+		return false
+	}
+	return true
+}
 
-		for {
-			select {
-			case <-ticker.C:
-				fn() // untested section
-			case <-ctx.Done():
-				return
-			}
+// if kube-remediator/<name> on the object, its controlling owners or its namespace lets the remediator act on it
+func (p *Base) isOptedIn(object metav1.Object) bool {
+	keys := append([]string{optInPrefix + p.options.Name}, p.optInAliases...)
+	optedIn, found := p.optIns.lookup(object, keys)
+	if p.requireOptIn {
+		return found && optedIn
+	}
+	return !found || optedIn
+}
+
+// gets rid of a pod the way the policy wants, reason explains why for logs and events, returns if it is done like mutate
+func (p *Base) removePod(pod v1.Pod, reason string) bool {
+	if owner, share := p.ownerToRestart(&pod); owner != nil {
+		if share == "" {
+			return false // its rollout replaces the pod
 		}
+		return p.restartOwner(owner, fmt.Sprintf("%s need remediation, %s: %s", share, pod.Name, reason))
+	}
+	if p.options.Action == policy.ActionEvict {
+		return p.evictPod(pod, reason)
+	}
+	return p.deletePod(pod, reason)
+}
+
+func (p *Base) deletePod(pod v1.Pod, reason string) bool {
+	return p.mutate("delete", &pod, reason, func() error {
+		return p.client.DeletePod(&pod)
 	})
+}
 
+// evictions blocked by a PodDisruptionBudget are retried when the pod is reconciled again
+func (p *Base) evictPod(pod v1.Pod, reason string) bool {
+	return p.mutate("evict", &pod, reason, func() error {
+		err := p.client.EvictPod(&pod)
+		if apierrors.IsTooManyRequests(err) && p.options.DeleteWhenEvictionBlocked {
+			p.logger.Info("Eviction blocked by PodDisruptionBudget, deleting instead", objectInfo(&pod)...)
+			return p.client.DeletePod(&pod)
+		}
+		return err
+	})
 }
 
-func (p *Base) deletePod(pod v1.Pod) {
-	podInfo := []zap.Field{
-		zap.String("name", pod.ObjectMeta.Name),
-		zap.String("namespace", pod.ObjectMeta.Namespace),
-	}
-	p.tryWithLogging("Deleting Pod", podInfo, func() error {
-		return p.client.DeletePod(&pod)
+// pods of a lister come in random order, sorted by namespace and name so remediating them is predictable
+func sortedPods(pods []*v1.Pod) []*v1.Pod {
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
 	})
+	return pods
 }
 
-func (p *Base) tryWithLogging(message string, logInfo []zap.Field, fn func() error) {
+func objectInfo(object metav1.Object) []zap.Field {
+	return []zap.Field{
+		zap.String("name", object.GetName()),
+		zap.String("namespace", object.GetNamespace()),
+	}
+}
+
+// all changes to the cluster go through here so dry-run and budgets can never be bypassed,
+// dry-run does not spend budget since nothing is changed.
+// Returns if the action is done (or would be in dry-run), false means it should be retried later.
+func (p *Base) mutate(action string, object runtime.Object, reason string, fn func() error) (done bool) {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		p.logger.Error("Error reading object", zap.Error(err)) // untested section
+		return false
+	}
+	kind := kindOf(object)
+	message := actionVerbs[action].doing + " " + kind
+	logInfo := append(objectInfo(accessor), zap.String("reason", reason))
+	description := fmt.Sprintf("%s %s %s %s/%s: %s",
+		p.options.Name, actionVerbs[action].done, kind, accessor.GetNamespace(), accessor.GetName(), reason)
+
+	// namespaces can turn remediators off, so checked before anything else
+	if p.options.Namespaces.IsDisabled(p.options.Name, accessor.GetNamespace()) || p.options.Namespaces.IsOptedOut(accessor) {
+		p.logger.Debug("Skipping because of RemediationPolicy: "+message, logInfo...)
+		return false
+	}
+	if reason := p.outOfScope(accessor); reason != "" {
+		p.logger.Debug("Skipping out of scope: "+message, append(logInfo, zap.String("scope", reason))...)
+		return false
+	}
+	if !p.isOptedIn(accessor) {
+		p.logger.Debug("Skipping because not opted in: "+message, logInfo...)
+		return false
+	}
+	// candidates are counted before windows and dry-run, so the breaker opens there too
+	if !p.passesCircuitBreaker(object, accessor) {
+		p.logger.Debug("Circuit breaker open, pausing: "+message, logInfo...)
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultPaused)
+		return false
+	}
+	// dry-run defers too, so it shows when actions would happen
+	if open, reason := p.isWindowOpen(time.Now()); !open {
+		p.logger.Debug("Outside of remediation windows, deferring: "+message, append(logInfo, zap.String("window", reason))...)
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultDeferred)
+		return false
+	}
+	// proposals show up in dry-run too, so approving them can be tried out
+	approved, ok := p.checkApproval(object, accessor, kind, action, reason)
+	if !ok {
+		return false
+	}
+	if approved != "" {
+		defer func() {
+			if !done {
+				return
+			}
+			if err := p.options.Approvals.Complete(approved); err != nil {
+				// stays approved until it expires, so the object could be remediated again without asking
+				p.logger.Error("Error completing approval", append(logInfo, zap.String("id", approved), zap.Error(err))...)
+			}
+		}()
+	}
+
+	if p.options.DryRun {
+		p.logger.Info("Dry-run: "+message, append(logInfo, zap.Bool("dry_run", true))...)
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultDryRun)
+		p.recordRemediation(accessor, kind, action, metrics.ResultDryRun, reason)
+		p.recordEvent(object, v1.EventTypeNormal, EventReasonDryRun, "Dry-run: "+description)
+		return true
+	}
+
+	budgets := p.options.Budgets
+	if unbudgetedActions[action] {
+		budgets = nil
+	}
+	if budget, ok := takeBudget(budgets); !ok {
+		p.logger.Warn("Budget exhausted, skipping: "+message, append(logInfo,
+			zap.String("budget", budget.scope),
+			zap.Int("max_actions", budget.config.MaxActions),
+			zap.Duration("window", budget.config.Window),
+		)...)
+		metrics.UpdateBudgetExhaustedCount(p.options.Name, budget.scope)
+		return false
+	}
+
 	p.logger.Info(message, logInfo...)
-	if err := fn(); err != nil {
+	err = fn()
+	switch {
+	case err == nil:
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultSuccess)
+		p.recordRemediation(accessor, kind, action, metrics.ResultSuccess, reason)
+		p.options.Namespaces.RecordRemediation(accessor.GetNamespace(), policy.RemediationRecord{
+			Time:       metav1.Now(),
+			Remediator: p.options.Name,
+			Action:     action,
+			Kind:       kind,
+			Name:       accessor.GetName(),
+			Reason:     reason,
+		})
+		p.recordEvent(object, v1.EventTypeNormal, EventReasonRemediated, description)
+		return true
+	case apierrors.IsTooManyRequests(err):
+		// nothing changed, so it should not count against the budget
+		refundBudget(budgets)
+		p.logger.Info("Blocked "+message+", retrying later", append(logInfo, zap.Error(err))...)
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultBlocked)
+		p.recordRemediation(accessor, kind, action, metrics.ResultBlocked, reason)
+		p.recordEvent(object, v1.EventTypeNormal, EventReasonBlocked, fmt.Sprintf("Blocked, retrying later: %s: %v", description, err))
+	default:
+		// failed actions did not remediate anything, so errors can not use up the budget
+		refundBudget(budgets)
 		p.logger.Warn("Error "+message, append(logInfo, zap.Error(err))...)
+		metrics.UpdatePodActionCount(p.options.Name, action, metrics.ResultError)
+		p.recordRemediation(accessor, kind, action, metrics.ResultError, reason)
+		p.recordEvent(object, v1.EventTypeWarning, EventReasonFailed, fmt.Sprintf("Failed: %s: %v", description, err))
+	}
+	return false
+}
+
+// in the history and notifications, skipped actions are not recorded since they are retried
+// and would push everything else out of the history, blocked ones are not notified about for the same reason.
+// Proposals waiting for approval are recorded once.
+func (p *Base) recordRemediation(object metav1.Object, kind string, action string, result string, reason string) {
+	if p.options.History == nil && p.options.Notifier == nil {
+		return
+	}
+	entry := history.Entry{
+		Time:       time.Now(),
+		Remediator: p.options.Name,
+		Action:     action,
+		Result:     result,
+		Reason:     reason,
+		Kind:       kind,
+		Namespace:  object.GetNamespace(),
+		Name:       object.GetName(),
+	}
+	if owner := metav1.GetControllerOfNoCopy(object); owner != nil {
+		entry.Owner = &history.Owner{Kind: owner.Kind, Name: owner.Name}
+	}
+	if result != metrics.ResultBlocked {
+		p.options.Notifier.Add(notify.Remediation{Entry: entry, Team: p.team(object)})
+	}
+	if p.options.History == nil {
+		return
+	}
+	if err := p.options.History.Add(entry); err != nil {
+		p.logger.Warn("Error persisting remediation history", zap.Error(err)) // untested section
+	}
+}
+
+// team label of the object or its closest controlling owner that has one
+func (p *Base) team(object metav1.Object) string {
+	label := p.options.Notifier.TeamLabel()
+	for current := object; current != nil; current = p.optIns.controller(current) {
+		if team, found := current.GetLabels()[label]; found {
+			return team
+		}
 	}
+	return ""
 }
@@ -0,0 +1,168 @@
+package remediator
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sync"
+	"time"
+)
+
+// set on the pod template like kubectl rollout restart does, changing it makes the owner replace all its pods
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// Restarts the top-most owner of a pod instead of removing the pod, when the policy's restart_owner threshold of
+// its pods are candidates, so its rollout strategy decides how fast they are replaced and availability is kept.
+// Only Deployments, StatefulSets and DaemonSets can be restarted, pods of other owners are removed as usual.
+type ownerRestarts struct {
+	pods         corelisters.PodLister
+	deployments  appslisters.DeploymentLister
+	statefulSets appslisters.StatefulSetLister
+	daemonSets   appslisters.DaemonSetLister
+	synced       []cache.InformerSynced
+	candidate    func(*v1.Pod) bool // if the remediator would act on the pod
+	lock         sync.Mutex
+	restarted    map[types.UID]time.Time // by us, used until the informer has the owner with the annotation
+}
+
+// nil when the policy does not restart owners, so their full objects are only cached when they are needed
+func (p *Base) newOwnerRestarts(pods coreinformers.PodInformer, candidate func(*v1.Pod) bool) *ownerRestarts {
+	if p.options.RestartOwner.Threshold <= 0 {
+		return nil
+	}
+	apps := p.informers.Apps().V1()
+	r := &ownerRestarts{
+		pods:         pods.Lister(),
+		deployments:  apps.Deployments().Lister(),
+		statefulSets: apps.StatefulSets().Lister(),
+		daemonSets:   apps.DaemonSets().Lister(),
+		candidate:    candidate,
+		restarted:    map[types.UID]time.Time{},
+	}
+	// requested before the shared factory is started
+	for _, informer := range []cache.SharedIndexInformer{
+		apps.Deployments().Informer(),
+		apps.StatefulSets().Informer(),
+		apps.DaemonSets().Informer(),
+	} {
+		r.synced = append(r.synced, informer.HasSynced)
+	}
+	return r
+}
+
+// the owner to restart instead of removing the pod, nil when the pod should be removed.
+// share is empty when the owner was restarted within the cooldown, its rollout replaces the pod then.
+func (p *Base) ownerToRestart(pod *v1.Pod) (owner metav1.Object, share string) {
+	config := p.options.RestartOwner
+	if p.restarts == nil || config.Threshold <= 0 || !p.isOptedIn(pod) {
+		return nil, ""
+	}
+	owner = p.optIns.root(pod)
+	if owner == nil || p.restarts.podTemplate(owner) == nil {
+		return nil, ""
+	}
+	if last := p.restarts.lastRestart(owner); time.Since(last) < config.Cooldown {
+		p.logger.Debug("Skipping, owner restarted recently", append(objectInfo(pod),
+			zap.String("owner", kindOf(owner.(runtime.Object))+"/"+owner.GetName()), zap.Time("restarted", last))...)
+		return owner, ""
+	}
+	candidates, pods := p.restarts.count(p.optIns, owner)
+	if float64(candidates) <= config.Threshold*float64(pods) {
+		return nil, ""
+	}
+	return owner, fmt.Sprintf("%d of %d pods", candidates, pods)
+}
+
+// patches the pod template like kubectl rollout restart, returns if it is done like mutate
+func (p *Base) restartOwner(owner metav1.Object, reason string) bool {
+	object := owner.(runtime.Object)
+	kind := kindOf(object)
+	now := time.Now()
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{restartedAtAnnotation: now.UTC().Format(time.RFC3339)},
+				},
+			},
+		},
+	})
+	restarted := p.mutate("restart", object, reason, func() error {
+		return p.client.PatchOwner(kind, owner.GetNamespace(), owner.GetName(), patch)
+	})
+	if restarted {
+		p.restarts.remember(owner, now) // also in dry-run, so its pods are not counted again right away
+	}
+	return restarted
+}
+
+// latest restart by us or anyone else, zero when it never was
+func (r *ownerRestarts) lastRestart(owner metav1.Object) time.Time {
+	var last time.Time
+	if value, found := r.podTemplate(owner).Annotations[restartedAtAnnotation]; found {
+		last, _ = time.Parse(time.RFC3339, value) // unreadable ones count as never
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if restarted, found := r.restarted[owner.GetUID()]; found && restarted.After(last) {
+		return restarted
+	}
+	delete(r.restarted, owner.GetUID()) // the informer caught up
+	return last
+}
+
+func (r *ownerRestarts) remember(owner metav1.Object, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.restarted[owner.GetUID()] = now
+}
+
+// candidates among the pods of the owner that are not terminating
+func (r *ownerRestarts) count(optIns *optIns, owner metav1.Object) (candidates int, pods int) {
+	all, err := r.pods.Pods(owner.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		return 0, 0 // untested section
+	}
+	for _, pod := range all {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if root := optIns.root(pod); root == nil || root.GetUID() != owner.GetUID() {
+			continue
+		}
+		pods++
+		if r.candidate(pod) {
+			candidates++
+		}
+	}
+	return candidates, pods
+}
+
+// template of owners that can be restarted, nil for others and when the owner is gone
+func (r *ownerRestarts) podTemplate(owner metav1.Object) *v1.PodTemplateSpec {
+	namespace, name := owner.GetNamespace(), owner.GetName()
+	switch kindOf(owner.(runtime.Object)) {
+	case "Deployment":
+		if deployment, err := r.deployments.Deployments(namespace).Get(name); err == nil {
+			return &deployment.Spec.Template
+		}
+	case "StatefulSet":
+		if statefulSet, err := r.statefulSets.StatefulSets(namespace).Get(name); err == nil {
+			return &statefulSet.Spec.Template
+		}
+	case "DaemonSet":
+		if daemonSet, err := r.daemonSets.DaemonSets(namespace).Get(name); err == nil {
+			return &daemonSet.Spec.Template
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package remediator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/k8s"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"sync"
+	"time"
+)
+
+var RuleRemediatorConfigFile = "config/rule_remediator.json"
+
+var ruleRemediatorDefaults = map[string]interface{}{
+	"rules": []interface{}{},
+}
+
+// how often rules are evaluated, also the precision of their "for"
+var RuleInterval = 30 * time.Second
+
+// runs the rules from config against all pods of the shared informer
+type RuleRemediator struct {
+	Base
+	rules []compiledRule
+	pods  coreinformers.PodInformer
+
+	matchingSince map[string]time.Time // rule/pod uid -> when the condition started to hold
+	done          map[string]bool      // rule/pod uid -> pods the action was taken on already
+}
+
+func init() {
+	Register(Registration{
+		Name:       "RuleRemediator",
+		New:        func() BaseIntf { return &RuleRemediator{} },
+		Defaults:   ruleRemediatorDefaults,
+		ConfigFile: func() string { return RuleRemediatorConfigFile },
+		Rules:      podRules("list", "watch", "delete", "patch"),
+	})
+}
+
+func (p *RuleRemediator) LoadConfig(logger *zap.Logger) error {
+	return readConfig(logger, RuleRemediatorConfigFile, ruleRemediatorDefaults, func(config *viper.Viper) error {
+		rules, err := loadRules(config)
+		if err != nil {
+			return fmt.Errorf("invalid rules: %w", err)
+		}
+		for _, rule := range rules {
+			logger.Info("Loaded rule", zap.String("rule", rule.Name), zap.String("condition", rule.Condition), zap.String("action", rule.Action))
+		}
+		p.rules = rules
+		return nil
+	})
+}
+
+func (p *RuleRemediator) Setup(logger *zap.Logger, client k8s.ClientInterface) error {
+	if err := p.LoadConfig(logger); err != nil {
+		return err
+	}
+	p.matchingSince = map[string]time.Time{}
+	p.done = map[string]bool{}
+	if err := p.Base.Setup(logger, client); err != nil {
+		return err // untested section
+	}
+	p.pods = p.informers.Core().V1().Pods()
+	p.pods.Informer() // request it before the shared factory is started
+	return nil
+}
+
+func (p *RuleRemediator) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if p.waitForCacheSync(ctx, p.pods) {
+		p.reconcileEvery(ctx, p.applyRules, RuleInterval)
+	}
+}
+
+func (p *RuleRemediator) Reconcile() error {
+	return p.applyRules()
+}
+
+// rules with a "for" need their condition to hold across evaluations, which a single pass never sees
+func (p *RuleRemediator) onceWarnings() []string {
+	var warnings []string
+	for _, rule := range p.rules {
+		if rule.For > 0 {
+			warnings = append(warnings, fmt.Sprintf("rule %s never acts in a single pass, its condition needs to hold for %s", rule.Name, rule.For))
+		}
+	}
+	return warnings
+}
+
+func (p *RuleRemediator) applyRules() error {
+	if len(p.rules) == 0 {
+		return nil
+	}
+	pods, err := p.pods.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("getting pod list: %w", err) // untested section
+	}
+
+	now := time.Now()
+	matchingSince := map[string]time.Time{} // pods that stopped matching or are gone are forgotten
+	done := map[string]bool{}
+	for _, pod := range pods {
+		var object map[string]interface{} // converted once, only when a rule selects the pod
+		for _, rule := range p.rules {
+			if !rule.selects(pod) {
+				continue
+			}
+			if object == nil {
+				if object, err = podToMap(pod); err != nil {
+					p.logger.Error("Error converting pod", append(objectInfo(pod), zap.Error(err))...) // untested section
+					break
+				}
+			}
+			matched, err := rule.matches(object, pod.ObjectMeta.CreationTimestamp.Time, now)
+			if err != nil {
+				p.logger.Debug("Error evaluating rule", append(objectInfo(pod), zap.String("rule", rule.Name), zap.Error(err))...)
+			}
+			if !matched {
+				continue
+			}
+
+			key := rule.Name + "/" + string(pod.ObjectMeta.UID)
+			since, found := p.matchingSince[key]
+			if !found {
+				since = now
+			}
+			matchingSince[key] = since
+			if p.done[key] {
+				done[key] = true
+				continue
+			}
+			if now.Sub(since) < rule.For {
+				continue
+			}
+			done[key] = p.apply(rule, *pod, now.Sub(since))
+		}
+	}
+	p.matchingSince = matchingSince
+	p.done = done
+	return nil
+}
+
+// returns if the action is done, so it is not repeated while the pod keeps matching (for example while it terminates)
+func (p *RuleRemediator) apply(rule compiledRule, pod v1.Pod, matchingFor time.Duration) bool {
+	reason := fmt.Sprintf("Rule %s matched for=%s", rule.Name, matchingFor.Round(time.Second))
+	switch rule.Action {
+	case RuleActionDelete:
+		return p.deletePod(pod, reason)
+	case RuleActionEvict:
+		return p.evictPod(pod, reason)
+	case RuleActionAnnotate:
+		patch, _ := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": rule.Annotations},
+		})
+		return p.mutate("annotate", &pod, reason, func() error {
+			return p.client.PatchPod(&pod, patch)
+		})
+	case RuleActionNotify:
+		return p.mutate("notify", &pod, reason, func() error { return nil })
+	}
+	return false // untested section
+}
@@ -0,0 +1,197 @@
+package remediator_test
+
+import (
+	"context"
+	"github.com/aksgithub/kube_remediator/pkg/k8s/mock"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/aksgithub/kube_remediator/pkg/remediator"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/record"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TestRuleRemediatorSuite struct {
+	suite.Suite
+	logger         *zap.Logger
+	mockController *gomock.Controller
+	mockClient     *mock_k8s.MockClientInterface
+	pods           []corev1.Pod
+	options        remediator.Options
+	t              *testing.T
+}
+
+func TestSuiteRuleRemediator(t *testing.T) {
+	suite.Run(t, &TestRuleRemediatorSuite{t: t})
+}
+
+func (suite *TestRuleRemediatorSuite) SetupTest() {
+	suite.logger, _ = zap.NewDevelopment()
+	suite.mockController = gomock.NewController(suite.t)
+	suite.mockClient = mock_k8s.NewMockClientInterface(suite.mockController)
+	suite.options = remediator.Options{Name: "RuleRemediator"}
+	suite.pods = []corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "web-1",
+			Namespace:         "default",
+			UID:               "uid-1",
+			Labels:            map[string]string{"app": "web"},
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+		},
+		Status: corev1.PodStatus{
+			Phase:             "Running",
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "web", RestartCount: 3}},
+		},
+	}}
+}
+
+func (suite *TestRuleRemediatorSuite) TeardownTest() {
+	suite.mockController.Finish()
+}
+
+func (suite *TestRuleRemediatorSuite) useRules(rules string) {
+	remediator.RuleRemediatorConfigFile = filepath.Join(suite.t.TempDir(), "rule_remediator.json")
+	err := os.WriteFile(remediator.RuleRemediatorConfigFile, []byte(`{"rules": `+rules+`}`), 0644)
+	assert.NilError(suite.t, err)
+}
+
+// factories with the current pods, started with startInformers after Setup
+func (suite *TestRuleRemediatorSuite) newInformerFactories() (informers.SharedInformerFactory, metadatainformer.SharedInformerFactory) {
+	var objects []runtime.Object
+	for i := range suite.pods {
+		objects = append(objects, &suite.pods[i])
+	}
+	return newInformerFactories(objects...)
+}
+
+func (suite *TestRuleRemediatorSuite) setup() (*remediator.RuleRemediator, error) {
+	factory, metadataFactory := suite.newInformerFactories()
+	suite.mockClient.EXPECT().SharedInformerFactory().Return(factory).MaxTimes(1)
+	suite.mockClient.EXPECT().MetadataInformerFactory().Return(metadataFactory).MaxTimes(1)
+	ruleRemediator := &remediator.RuleRemediator{}
+	ruleRemediator.Configure(suite.options)
+	err := ruleRemediator.Setup(suite.logger, suite.mockClient)
+	startInformers(suite.T(), factory, metadataFactory)
+	return ruleRemediator, err
+}
+
+// runs until the timeout, 0 runs the rules once
+func (suite *TestRuleRemediatorSuite) run(timeout time.Duration) {
+	ruleRemediator, err := suite.setup()
+	assert.NilError(suite.t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ruleRemediator.Run(ctx, &wg)
+}
+
+func (suite *TestRuleRemediatorSuite) assertInvalid(rules string, message string) {
+	suite.useRules(rules)
+	_, err := suite.setup()
+	assert.ErrorContains(suite.t, err, message)
+}
+
+func (suite *TestRuleRemediatorSuite) TestRejectsInvalidRules() {
+	suite.assertInvalid(`[{"name": "a", "condition": "pod.status.phase ==", "action": "delete"}]`, `rule "a": invalid condition: ERROR`)
+	suite.assertInvalid(`[{"name": "a", "condition": "unknown > 1", "action": "delete"}]`, `rule "a": invalid condition: ERROR`)
+	suite.assertInvalid(`[{"name": "a", "condition": "age", "action": "delete"}]`, `rule "a": condition needs to be a bool, got google.protobuf.Duration`)
+	suite.assertInvalid(`[{"name": "a", "condition": "true", "action": "explode"}]`, `rule "a": unknown action "explode"`)
+	suite.assertInvalid(`[{"name": "a", "condition": "true", "action": "annotate"}]`, `rule "a": action "annotate" needs annotations`)
+	suite.assertInvalid(`[{"name": "a", "condition": "true", "action": "delete", "selector": "app in"}]`, `rule "a": invalid selector`)
+	suite.assertInvalid(`[{"name": "a", "action": "delete"}]`, `rule "a": condition is required`)
+	suite.assertInvalid(`[{"condition": "true", "action": "delete"}]`, `rule 0: name is required`)
+	suite.assertInvalid(`[{"name": "a", "condition": "true", "action": "delete"}, {"name": "a", "condition": "true", "action": "delete"}]`,
+		`rule "a": name is used by another rule`)
+	suite.assertInvalid(`[{"name": "a", "conditon": "true", "action": "delete"}]`, `rule "a": unknown field "conditon"`)
+	suite.assertInvalid(`[{"conditon": "true", "action": "delete"}]`, `rule 0: unknown field "conditon"`)
+	suite.assertInvalid(`[{"name": "a", "condition": "true", "action": "delete", "for": "soon"}]`, `rule "a": 1 error(s) decoding`)
+	suite.assertInvalid(`[{"name": "a", "condition": "true", "action": "delete", "namespace": "Not_A_Namespace"}]`,
+		`rule "a": namespace: invalid namespace "Not_A_Namespace"`)
+	suite.assertInvalid(`[{"name": "a", "condition": "true", "action": "delete", "for": "-1m"}]`, `rule "a": for -1m0s can not be negative`)
+	suite.assertInvalid(`["a"]`, `invalid rules: 1 error(s) decoding`)
+	suite.assertInvalid(`{}`, `rules needs to be a list, got {}`)
+}
+
+func (suite *TestRuleRemediatorSuite) TestDoesNothingWithoutRules() {
+	suite.useRules(`[]`)
+	suite.run(0)
+}
+
+func (suite *TestRuleRemediatorSuite) TestDeletesMatchingPods() {
+	suite.useRules(`[{"name": "restarts", "selector": "app=web",
+		"condition": "pod.status.containerStatuses.exists(c, c.restartCount >= 3) && age > duration('1h')", "action": "delete"}]`)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run(0)
+}
+
+func (suite *TestRuleRemediatorSuite) TestEvictsMatchingPods() {
+	suite.useRules(`[{"name": "running", "condition": "pod.status.phase == 'Running'", "action": "evict"}]`)
+	suite.mockClient.EXPECT().EvictPod(&suite.pods[0]).Return(nil)
+	suite.run(0)
+}
+
+func (suite *TestRuleRemediatorSuite) TestIgnoresPodsThatDoNotMatch() {
+	suite.useRules(`[
+		{"name": "selector", "selector": "app=db", "condition": "true", "action": "delete"},
+		{"name": "namespace", "namespace": "kube-system", "condition": "true", "action": "delete"},
+		{"name": "condition", "condition": "pod.status.phase == 'Failed'", "action": "delete"},
+		{"name": "missing-field", "condition": "pod.status.reason == 'Evicted'", "action": "delete"}
+	]`)
+	suite.run(0)
+}
+
+func (suite *TestRuleRemediatorSuite) TestWaitsUntilConditionHeldLongEnough() {
+	suite.useRules(`[{"name": "running", "condition": "pod.status.phase == 'Running'", "for": "1h", "action": "delete"}]`)
+	suite.run(0)
+}
+
+func (suite *TestRuleRemediatorSuite) TestActsOnceAfterConditionHeld() {
+	remediator.RuleInterval = 5 * time.Millisecond
+	defer func() { remediator.RuleInterval = 30 * time.Second }()
+	suite.useRules(`[{"name": "running", "condition": "pod.status.phase == 'Running'", "for": "20ms", "action": "delete"}]`)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil).Times(1)
+	suite.run(100 * time.Millisecond)
+}
+
+func (suite *TestRuleRemediatorSuite) TestAnnotatesMatchingPods() {
+	suite.useRules(`[{"name": "mark", "condition": "true", "action": "annotate", "annotations": {"example.com/flagged": "true"}}]`)
+	suite.mockClient.EXPECT().PatchPod(&suite.pods[0], []byte(`{"metadata":{"annotations":{"example.com/flagged":"true"}}}`)).Return(nil)
+	suite.run(0)
+}
+
+func (suite *TestRuleRemediatorSuite) TestNotifiesWithEvents() {
+	recorder := record.NewFakeRecorder(10)
+	suite.options.Recorder = recorder
+	suite.useRules(`[{"name": "watch", "condition": "true", "action": "notify"}]`)
+	suite.run(0)
+
+	assert.Equal(suite.t, <-recorder.Events, "Normal Remediated RuleRemediator notified about Pod default/web-1: Rule watch matched for=0s")
+}
+
+func (suite *TestRuleRemediatorSuite) TestAnnotatingAndNotifyingDoNotSpendBudget() {
+	suite.options.Budgets = []*remediator.Budget{
+		remediator.NewBudget("RuleRemediator", policy.Budget{MaxActions: 1, Window: time.Hour}),
+	}
+	suite.useRules(`[
+		{"name": "mark", "condition": "true", "action": "annotate", "annotations": {"example.com/flagged": "true"}},
+		{"name": "watch", "condition": "true", "action": "notify"},
+		{"name": "running", "condition": "pod.status.phase == 'Running'", "action": "delete"}
+	]`)
+	suite.mockClient.EXPECT().PatchPod(&suite.pods[0], gomock.Any()).Return(nil)
+	suite.mockClient.EXPECT().DeletePod(&suite.pods[0]).Return(nil)
+	suite.run(0)
+}
@@ -0,0 +1,178 @@
+package remediator
+
+import (
+	"fmt"
+	"github.com/google/cel-go/cel"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sort"
+	"time"
+)
+
+// what a rule can do with a pod that matched long enough
+const (
+	RuleActionDelete   = "delete"
+	RuleActionEvict    = "evict"
+	RuleActionAnnotate = "annotate"
+	RuleActionNotify   = "notify" // only records an event, see README
+)
+
+// Rule defines a remediation in config instead of code, for example:
+// {"name": "stuck-pending", "condition": "pod.status.phase == 'Pending'", "for": "1h", "action": "delete"}
+type Rule struct {
+	Name        string            `mapstructure:"name"`
+	Namespace   string            `mapstructure:"namespace"` // empty matches all namespaces
+	Selector    string            `mapstructure:"selector"`  // label selector like app=web,tier!=db
+	Condition   string            `mapstructure:"condition"` // CEL over pod, age and now
+	For         time.Duration     `mapstructure:"for"`       // how long the condition needs to hold before acting
+	Action      string            `mapstructure:"action"`
+	Annotations map[string]string `mapstructure:"annotations"` // set by the annotate action
+}
+
+// rule that passed validation and is ready to run
+type compiledRule struct {
+	Rule
+	selector  labels.Selector
+	condition cel.Program
+}
+
+// pod is the Pod as it is returned by the api (status.containerStatuses, metadata.ownerReferences, ...),
+// age is a duration since the pod was created
+var ruleEnv = mustEnv(
+	cel.Variable("pod", cel.MapType(cel.StringType, cel.DynType)),
+	cel.Variable("age", cel.DurationType),
+	cel.Variable("now", cel.TimestampType),
+)
+
+// the variables are fixed, so an error is a bug and rules could never compile without it
+func mustEnv(options ...cel.EnvOption) *cel.Env {
+	env, err := cel.NewEnv(options...)
+	if err != nil {
+		panic(fmt.Sprintf("creating CEL environment for rules: %v", err)) // untested section
+	}
+	return env
+}
+
+// compiles the rules of the config, so broken rules are rejected before anything runs, unknown fields included
+func loadRules(config *viper.Viper) ([]compiledRule, error) {
+	var fields []map[string]interface{}
+	if err := config.UnmarshalKey("rules", &fields); err != nil {
+		return nil, err
+	}
+	rules := make([]Rule, len(fields))
+	for i := range fields {
+		var metadata mapstructure.Metadata
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+			Metadata:   &metadata,
+			Result:     &rules[i],
+		})
+		if err != nil {
+			return nil, err // untested section
+		}
+		err = decoder.Decode(fields[i])
+		if err == nil && len(metadata.Unused) > 0 {
+			sort.Strings(metadata.Unused)
+			err = fmt.Errorf("unknown field %q", metadata.Unused[0])
+		}
+		if err != nil {
+			if name, ok := fields[i]["name"].(string); ok && name != "" {
+				return nil, fmt.Errorf("rule %q: %w", name, err)
+			}
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return compileRules(rules)
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	compiled := []compiledRule{}
+	names := map[string]bool{}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q: name is used by another rule", rule.Name)
+		}
+		names[rule.Name] = true
+
+		compiledRule, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		compiled = append(compiled, compiledRule)
+	}
+	return compiled, nil
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	compiled := compiledRule{Rule: rule}
+
+	switch rule.Action {
+	case RuleActionDelete, RuleActionEvict, RuleActionNotify:
+	case RuleActionAnnotate:
+		if len(rule.Annotations) == 0 {
+			return compiled, fmt.Errorf("action %q needs annotations", rule.Action)
+		}
+	default:
+		return compiled, fmt.Errorf("unknown action %q, use %q, %q, %q or %q",
+			rule.Action, RuleActionDelete, RuleActionEvict, RuleActionAnnotate, RuleActionNotify)
+	}
+	if rule.For < 0 {
+		return compiled, fmt.Errorf("for %s can not be negative", rule.For)
+	}
+	if err := validateNamespace("namespace", rule.Namespace); err != nil {
+		return compiled, err
+	}
+
+	selector, err := labels.Parse(rule.Selector)
+	if err != nil {
+		return compiled, fmt.Errorf("invalid selector: %w", err)
+	}
+	compiled.selector = selector
+
+	if rule.Condition == "" {
+		return compiled, fmt.Errorf("condition is required")
+	}
+	ast, issues := ruleEnv.Compile(rule.Condition)
+	if issues.Err() != nil {
+		return compiled, fmt.Errorf("invalid condition: %w", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return compiled, fmt.Errorf("condition needs to be a bool, got %s", ast.OutputType())
+	}
+	compiled.condition, err = ruleEnv.Program(ast)
+	if err != nil {
+		return compiled, fmt.Errorf("invalid condition: %w", err) // untested section
+	}
+	return compiled, nil
+}
+
+// cheap checks first so the pod is only converted for the condition when needed
+func (r compiledRule) selects(pod *v1.Pod) bool {
+	return (r.Namespace == "" || r.Namespace == pod.ObjectMeta.Namespace) &&
+		r.selector.Matches(labels.Set(pod.ObjectMeta.Labels))
+}
+
+// missing fields fail evaluation, so they do not match; use has() to check for them
+func (r compiledRule) matches(pod map[string]interface{}, created time.Time, now time.Time) (bool, error) {
+	result, _, err := r.condition.Eval(map[string]interface{}{
+		"pod": pod,
+		"age": now.Sub(created),
+		"now": now,
+	})
+	if err != nil {
+		return false, err
+	}
+	matched, ok := result.Value().(bool)
+	return ok && matched, nil
+}
+
+// the json representation, so conditions use the same field names as kubectl get -o json
+func podToMap(pod *v1.Pod) (map[string]interface{}, error) {
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+}
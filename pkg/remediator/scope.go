@@ -0,0 +1,96 @@
+package remediator
+
+import (
+	"fmt"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"path"
+)
+
+// policy.Scope with parsed selectors
+type scope struct {
+	config            policy.Scope
+	podSelector       labels.Selector
+	namespaceSelector labels.Selector
+}
+
+// the policy validated the selectors before
+func newScope(config policy.Scope) scope {
+	podSelector, _ := labels.Parse(config.PodSelector)
+	namespaceSelector, _ := labels.Parse(config.NamespaceSelector)
+	return scope{config: config, podSelector: podSelector, namespaceSelector: namespaceSelector}
+}
+
+// why the object is out of scope, empty when it is in scope.
+// Pod selector and priority classes only apply to pods, namespace is nil when it is not known.
+func (s scope) excludes(object metav1.Object, namespace *metav1.PartialObjectMetadata) string {
+	name := object.GetNamespace()
+	if len(s.config.Namespaces) > 0 && !matchesAny(s.config.Namespaces, name) {
+		return "namespace not in namespaces"
+	}
+	if matchesAny(s.config.ExcludeNamespaces, name) {
+		return "namespace in exclude_namespaces"
+	}
+	if !s.namespaceSelector.Empty() && (namespace == nil || !s.namespaceSelector.Matches(labels.Set(namespace.ObjectMeta.Labels))) {
+		return "namespace does not match namespace_selector"
+	}
+	if pod, ok := object.(*v1.Pod); ok {
+		if !s.podSelector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+			return "pod does not match pod_selector"
+		}
+		for _, priorityClass := range s.config.ExcludePriorityClasses {
+			if pod.Spec.PriorityClassName == priorityClass {
+				return fmt.Sprintf("priority class %s in exclude_priority_classes", priorityClass)
+			}
+		}
+	}
+	return ""
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// why the remediator may not act on the object, empty when it may
+func (p *Base) outOfScope(object metav1.Object) string {
+	if p.isSelf(object) {
+		return "kube-remediator itself"
+	}
+	namespace := p.optIns.namespace(object.GetNamespace())
+	for _, scope := range p.scopes {
+		if reason := scope.excludes(object, namespace); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// our own pod and the other replicas of it, which share its controller
+func (p *Base) isSelf(object metav1.Object) bool {
+	self := p.options.Self
+	if self.Name == "" || object.GetNamespace() != self.Namespace {
+		return false
+	}
+	if _, ok := object.(*v1.Pod); !ok {
+		return false
+	}
+	if object.GetName() == self.Name {
+		return true
+	}
+	if p.informers == nil {
+		return false // untested section
+	}
+	pod, err := p.informers.Core().V1().Pods().Lister().Pods(self.Namespace).Get(self.Name)
+	if err != nil {
+		return false // not running in the cluster
+	}
+	ours, theirs := p.optIns.root(pod), p.optIns.root(object)
+	return ours != nil && theirs != nil && ours.GetUID() == theirs.GetUID()
+}
@@ -0,0 +1,131 @@
+package window
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aksgithub/kube_remediator/pkg/metrics"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	httpmux "github.com/google/cadvisor/http/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// how often windows are checked, they are configured in minutes
+var Interval = time.Minute
+
+// State of the windows of a remediator as served on /windows
+type State struct {
+	Open   bool      `json:"open"`
+	Reason string    `json:"reason,omitempty"` // why it is closed
+	Since  time.Time `json:"since"`            // when it was first seen like this
+}
+
+// Monitor shows which remediators are inside their windows in logs, metrics and on /windows.
+// Remediators check their windows themselves, this only reports them.
+type Monitor struct {
+	logger    *zap.Logger
+	now       func() time.Time
+	lock      sync.Mutex
+	schedules map[string][]*policy.Schedule
+	states    map[string]State
+}
+
+func NewMonitor(logger *zap.Logger, now func() time.Time) *Monitor {
+	return &Monitor{
+		logger:    logger,
+		now:       now,
+		schedules: map[string][]*policy.Schedule{},
+		states:    map[string]State{},
+	}
+}
+
+// Update replaces the windows of all remediators, remediators that are not included are no longer reported
+func (m *Monitor) Update(windows map[string][]policy.Windows) {
+	m.lock.Lock()
+	m.schedules = map[string][]*policy.Schedule{}
+	for remediator, configs := range windows {
+		for _, config := range configs {
+			schedule, err := policy.NewSchedule(config)
+			if err != nil {
+				continue // validated with the policy
+			}
+			m.schedules[remediator] = append(m.schedules[remediator], schedule)
+		}
+	}
+	for remediator := range m.states {
+		if _, found := windows[remediator]; !found {
+			delete(m.states, remediator)
+			metrics.DeleteWindowOpen(remediator)
+		}
+	}
+	m.lock.Unlock()
+
+	m.Check()
+}
+
+func (m *Monitor) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check updates the state of all remediators and logs the ones that changed
+func (m *Monitor) Check() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	for remediator, schedules := range m.schedules {
+		state := State{Open: true, Since: now}
+		for _, schedule := range schedules {
+			if open, reason := schedule.IsOpen(now); !open {
+				state = State{Open: false, Reason: reason, Since: now}
+				break
+			}
+		}
+
+		previous, found := m.states[remediator]
+		if found && previous.Open == state.Open && previous.Reason == state.Reason {
+			continue
+		}
+		m.states[remediator] = state
+		metrics.SetWindowOpen(remediator, state.Open)
+		if state.Open {
+			m.logger.Info("Remediation window open, acting again", zap.String("remediator", remediator))
+		} else {
+			m.logger.Info("Remediation window closed, deferring actions",
+				zap.String("remediator", remediator), zap.String("reason", state.Reason))
+		}
+	}
+}
+
+func (m *Monitor) States() map[string]State {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	states := map[string]State{}
+	for remediator, state := range m.states {
+		states[remediator] = state
+	}
+	return states
+}
+
+// serves the state of all remediators as json on /windows
+func (m *Monitor) RegisterHandler(mux httpmux.Mux) error {
+	mux.HandleFunc("/windows", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.States())
+	})
+	return nil
+}
@@ -0,0 +1,117 @@
+package window_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aksgithub/kube_remediator/pkg/policy"
+	"github.com/aksgithub/kube_remediator/pkg/window"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gotest.tools/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TestMonitorSuite struct {
+	suite.Suite
+	now     time.Time
+	logs    *observer.ObservedLogs
+	monitor *window.Monitor
+	t       *testing.T
+}
+
+func TestSuiteMonitor(t *testing.T) {
+	suite.Run(t, &TestMonitorSuite{t: t})
+}
+
+func (suite *TestMonitorSuite) SetupTest() {
+	suite.now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var core zapcore.Core
+	core, suite.logs = observer.New(zap.InfoLevel)
+	suite.monitor = window.NewMonitor(zap.New(core), func() time.Time { return suite.now })
+	suite.monitor.Update(map[string][]policy.Windows{
+		"OldPodDeleter":       {{}, {Allowed: []policy.Window{{Start: "20:00", End: "06:00"}}}},
+		"CompletedPodDeleter": {{}},
+	})
+}
+
+func (suite *TestMonitorSuite) TestReportsState() {
+	states := suite.monitor.States()
+	assert.DeepEqual(suite.t, states, map[string]window.State{
+		"OldPodDeleter":       {Open: false, Reason: "outside of allowed windows", Since: suite.now},
+		"CompletedPodDeleter": {Open: true, Since: suite.now},
+	})
+}
+
+func (suite *TestMonitorSuite) TestLogsChanges() {
+	assert.Equal(suite.t, suite.logs.FilterMessage("Remediation window closed, deferring actions").Len(), 1)
+
+	suite.monitor.Check()
+	assert.Equal(suite.t, suite.logs.Len(), 2, "unchanged windows are not logged again")
+
+	opened := suite.now.Add(8 * time.Hour)
+	suite.now = opened
+	suite.monitor.Check()
+	assert.Equal(suite.t, suite.logs.FilterMessage("Remediation window open, acting again").Len(), 2)
+	assert.DeepEqual(suite.t, suite.monitor.States()["OldPodDeleter"], window.State{Open: true, Since: opened})
+}
+
+func (suite *TestMonitorSuite) TestForgetsRemovedRemediators() {
+	suite.monitor.Update(map[string][]policy.Windows{"CompletedPodDeleter": {{}}})
+	_, found := suite.monitor.States()["OldPodDeleter"]
+	assert.Assert(suite.t, !found)
+}
+
+func (suite *TestMonitorSuite) TestServesWindows() {
+	mux := http.NewServeMux()
+	assert.NilError(suite.t, suite.monitor.RegisterHandler(mux))
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/windows", nil))
+
+	assert.Equal(suite.t, recorder.Code, http.StatusOK)
+	var states map[string]window.State
+	assert.NilError(suite.t, json.Unmarshal(recorder.Body.Bytes(), &states))
+	assert.Equal(suite.t, states["OldPodDeleter"].Reason, "outside of allowed windows")
+	assert.Assert(suite.t, states["CompletedPodDeleter"].Open)
+}
+
+func (suite *TestMonitorSuite) TestSkipsInvalidWindows() {
+	monitor := window.NewMonitor(zap.NewNop(), func() time.Time { return suite.now })
+	monitor.Update(map[string][]policy.Windows{
+		"OldPodDeleter": {{Allowed: []policy.Window{{Start: "noon", End: "06:00"}}}},
+	})
+	assert.DeepEqual(suite.t, monitor.States(), map[string]window.State{})
+}
+
+func (suite *TestMonitorSuite) TestChecksEveryInterval() {
+	defer func(interval time.Duration) { window.Interval = interval }(window.Interval)
+	window.Interval = time.Millisecond
+	checks := 0 // only changed under the lock of the monitor
+	monitor := window.NewMonitor(zap.NewNop(), func() time.Time {
+		checks++
+		if checks > 1 {
+			return suite.now.Add(8 * time.Hour)
+		}
+		return suite.now
+	})
+	monitor.Update(map[string][]policy.Windows{
+		"OldPodDeleter": {{Allowed: []policy.Window{{Start: "20:00", End: "06:00"}}}},
+	})
+	assert.Assert(suite.t, !monitor.States()["OldPodDeleter"].Open)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go monitor.Run(ctx, &wg)
+	defer wg.Wait()
+	defer cancel()
+
+	for !monitor.States()["OldPodDeleter"].Open {
+		time.Sleep(time.Millisecond)
+	}
+}